@@ -9,7 +9,9 @@ import (
 	"io"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -74,6 +76,16 @@ func main() {
 		return
 	}
 
+	// 试运行: tg-down --dry-run [过滤表达式]，只扫描目标聊天并汇总将下载的内容，不下载
+	if len(os.Args) > 1 && os.Args[1] == "--dry-run" {
+		cfg, log := initializeApplication()
+		if err := runDryRun(cfg, log, strings.Join(os.Args[2:], " ")); err != nil {
+			log.Error("%v", err)
+			os.Exit(ExitCodeRunError)
+		}
+		return
+	}
+
 	cfg, log := initializeApplication()
 	if err := runApp(cfg, log); err != nil {
 		log.Error("%v", err)
@@ -96,9 +108,10 @@ func runApp(cfg *config.Config, log *logger.Logger) error {
 
 	mode := selectMode(log)
 
-	// TDLib 客户端始终带更新监听；是否触发实时下载由 targetChatID 控制
+	// TDLib 客户端始终带更新监听；是否触发实时下载由已登记的监控任务控制
 	client := telegram.NewWithUpdates(cfg, log, 0)
 	client.SetRecordFunc(store.NewRecorder(st))
+	client.SetDuplicateLookupFunc(store.NewDuplicateLookup(st))
 	defer client.Close() // Close 在未连接(td==nil)时为无操作，认证失败也可安全调用
 
 	log.Info("正在连接到Telegram...")
@@ -112,7 +125,7 @@ func runApp(cfg *config.Config, log *logger.Logger) error {
 		return err
 	}
 	if mode == ModeMonitorNewMessages || mode == ModeDownloadAndMonitor {
-		client.SetMonitorTask(fmt.Sprintf("cli-monitor-%d", time.Now().UnixNano()), targetChatID)
+		client.AddMonitorTask(fmt.Sprintf("cli-monitor-%d", time.Now().UnixNano()), targetChatID, downloader.HistoryFilters{})
 	}
 
 	if err := executeMode(ctx, cancel, client, log, mode, targetChatID); err != nil {
@@ -121,6 +134,100 @@ func runApp(cfg *config.Config, log *logger.Logger) error {
 	return nil
 }
 
+// runDryRun 试运行目标聊天的历史下载：按过滤表达式扫描并打印将下载的文件统计，不下载任何文件
+func runDryRun(cfg *config.Config, log *logger.Logger, expr string) error {
+	parsed, err := downloader.ParseFilterExpr(expr)
+	if err != nil {
+		return err
+	}
+	st, err := store.Open(cfg.Store.Path)
+	if err != nil {
+		return fmt.Errorf("打开数据库失败: %w", err)
+	}
+	defer func() { _ = st.Close() }()
+
+	ctx, cancel := setupSignalHandling(log)
+	defer cancel()
+
+	client := telegram.New(cfg, log)
+	client.SetDuplicateLookupFunc(store.NewDuplicateLookup(st))
+	defer client.Close()
+
+	log.Info("正在连接到Telegram...")
+	if err := client.Authenticate(ctx); err != nil {
+		return fmt.Errorf("连接/认证失败: %w", err)
+	}
+	targetChatID, err := resolveTargetChat(ctx, cfg, client, log)
+	if err != nil {
+		return err
+	}
+
+	filters := parsed.Filters
+	if err := client.ResolveFilterSources(ctx, &filters, parsed.Senders, parsed.ExcludeSenders, parsed.ForwardSources); err != nil {
+		return err
+	}
+	if msg := filters.Validate(); msg != "" {
+		return errors.New(msg)
+	}
+
+	log.Info("开始试运行（不下载文件）...")
+	preview, err := client.PreviewHistory(ctx, &downloader.HistorySpec{ChatID: targetChatID, Filters: filters}, 0)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return fmt.Errorf("试运行失败: %w", err)
+	}
+	printPreview(os.Stdout, preview)
+	return nil
+}
+
+// printPreview 打印试运行结果
+func printPreview(w io.Writer, p *downloader.Preview) {
+	_, _ = fmt.Fprintf(w, "扫描消息 %d 条，将下载 %d 个文件，共 %s\n", p.ScannedMessages, p.Files, formatBytes(p.Bytes))
+	types := make([]string, 0, len(p.ByType))
+	for t := range p.ByType {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	for _, t := range types {
+		_, _ = fmt.Fprintf(w, "  %-10s %6d 个  %s\n", t, p.ByType[t].Count, formatBytes(p.ByType[t].Bytes))
+	}
+	if p.DateFrom != 0 {
+		_, _ = fmt.Fprintf(w, "日期跨度: %s ~ %s\n",
+			time.Unix(p.DateFrom, 0).Format("2006-01-02"), time.Unix(p.DateTo, 0).Format("2006-01-02"))
+	}
+	_, _ = fmt.Fprintf(w, "将跳过: 已存在 %d 个（%s），unique_id 去重 %d 个（%s）\n",
+		p.Present, formatBytes(p.PresentBytes), p.Duplicates, formatBytes(p.DuplicateBytes))
+	if len(p.Samples) > 0 {
+		_, _ = fmt.Fprintln(w, "样例:")
+	}
+	for _, f := range p.Samples {
+		note := ""
+		switch f.Skip {
+		case downloader.PreviewSkipPresent:
+			note = "（已存在）"
+		case downloader.PreviewSkipDuplicate:
+			note = "（去重）"
+		}
+		_, _ = fmt.Fprintf(w, "  #%d %s %s%s\n", f.MessageID, f.FileName, formatBytes(f.FileSize), note)
+	}
+}
+
+// formatBytes 以 1024 进制把字节数格式化为便于阅读的字符串
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 // runWebMode 启动 Web 管理端（允许无凭据启动，登录信息可在网页内填写）
 func runWebMode(addr string) {
 	cfg, err := config.LoadConfigForWeb()
@@ -233,7 +340,7 @@ func executeMode(
 // logHistoryMediaCount 在下载前统计并打印聊天媒体总数（近似值）；
 // 统计失败仅告警不阻断，返回非 nil 仅表示 ctx 已取消
 func logHistoryMediaCount(ctx context.Context, client *telegram.Client, log *logger.Logger, chatID int64) error {
-	total, err := client.CountHistoryMedia(ctx, chatID, nil)
+	total, err := client.CountHistoryMedia(ctx, chatID, downloader.HistoryFilters{})
 	if err != nil {
 		if errors.Is(err, context.Canceled) {
 			return err
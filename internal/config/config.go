@@ -25,6 +25,8 @@ const (
 	DefaultBatchSize     = 100
 	// DefaultPartitionSize 是历史下载的在途媒体上限（扫描最多领先下载的数量）
 	DefaultPartitionSize = 100
+	// DefaultScanSegments 是历史扫描的默认分段数（1 = 单协程串行扫描）
+	DefaultScanSegments = 1
 
 	// 默认重试配置
 	DefaultMaxRetries = 3
@@ -78,8 +80,11 @@ type DownloadConfig struct {
 	MaxConcurrent int    `yaml:"max_concurrent"` // 同时下载的文件数
 	BatchSize     int    `yaml:"batch_size"`     // 每批拉取的历史消息数
 	PartitionSize int    `yaml:"partition_size"` // 历史下载在途媒体上限（扫描最多领先下载的数量）
+	ScanSegments  int    `yaml:"scan_segments"`  // 历史扫描按消息 id 分段并行的段数（任务未指定时沿用）
 	// SaveMetadata 为 true 时在每个下载文件旁写 <文件>.json 元数据（caption/发送者/日期等）
 	SaveMetadata bool `yaml:"save_metadata"`
+	// TopicDirs 为 true 时论坛超级群组的消息按话题分子目录（topic_<id>）存储
+	TopicDirs bool `yaml:"topic_dirs"`
 	// DisableClassifyByType 为 true 时关闭按媒体类型归档（默认归档开启）
 	DisableClassifyByType bool `yaml:"disable_classify_by_type"`
 }
@@ -288,9 +293,19 @@ func loadDownloadConfig(config *Config) {
 		}
 	}
 
+	if scanSegments := os.Getenv("SCAN_SEGMENTS"); scanSegments != "" {
+		if segments, err := strconv.Atoi(scanSegments); err == nil {
+			config.Download.ScanSegments = segments
+		}
+	}
+
 	if saveMetadata := os.Getenv("SAVE_METADATA"); saveMetadata != "" {
 		config.Download.SaveMetadata = saveMetadata == "1" || strings.EqualFold(saveMetadata, "true")
 	}
+
+	if topicDirs := os.Getenv("TOPIC_DIRS"); topicDirs != "" {
+		config.Download.TopicDirs = topicDirs == "1" || strings.EqualFold(topicDirs, "true")
+	}
 }
 
 // loadChatConfig 加载聊天配置
@@ -374,6 +389,9 @@ func setDefaults(config *Config) {
 	if config.Download.PartitionSize <= 0 {
 		config.Download.PartitionSize = DefaultPartitionSize
 	}
+	if config.Download.ScanSegments <= 0 {
+		config.Download.ScanSegments = DefaultScanSegments
+	}
 
 	if config.Retry.MaxRetries <= 0 {
 		config.Retry.MaxRetries = DefaultMaxRetries
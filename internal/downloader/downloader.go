@@ -9,6 +9,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -35,6 +36,9 @@ const (
 	mediaTypeAnimation = "animation"
 	mediaTypeAudio     = "audio"
 	mediaTypeVoice     = "voice"
+	mediaTypeVideoNote = "video_note" // 圆形视频消息
+	mediaTypeSticker   = "sticker"    // 静态/动态（tgs）/视频（webm）贴纸
+	mediaTypeAvatar    = "avatar"     // 聊天头像与成员个人头像（头像任务），固定归入 avatars 目录
 	mediaTypeOther     = "other"
 
 	// 进度状态（MediaProgress.Status）：与 RecordStatus 语义不同，独立成组
@@ -60,6 +64,48 @@ type MediaInfo struct {
 	AlbumID   int64  // Telegram 相册（media_album_id），0 = 不属于相册
 	Caption   string // 消息 caption 文本（供元数据 sidecar）
 	SenderID  int64  // 发送者 user/chat id（供元数据 sidecar）
+	TopicID   int64  // 论坛超级群组的话题 id（forum_topic_id），0 = 非论坛消息
+
+	// Forwarded 报告消息是否为转发。ForwardFromID 是原始来源：用户为其 user id，频道/群组为其 chat id，
+	// 隐藏了转发身份的用户为 0；ForwardMessageID 是原频道中的消息 id（仅频道来源可知，否则为 0）
+	Forwarded        bool
+	ForwardFromID    int64
+	ForwardMessageID int64
+
+	// ViewCount/ForwardCount/ReactionCount 是扫描时取得的浏览数、被转发数与回应总数（随时间变化，不持久化）
+	ViewCount     int32
+	ForwardCount  int32
+	ReactionCount int32
+
+	// PostChatID/PostID 非 0 时为频道帖子评论区中的媒体：ChatID/MessageID 指向讨论组里的评论，
+	// 这两项指向其所属的频道帖子，用于按帖子归档
+	PostChatID int64
+	PostID     int64
+
+	// StoryID 非 0 时为快拍（story）媒体：ChatID 为发布者聊天，MessageID 为 StoryMessageID(StoryID)
+	StoryID int64
+
+	// StickerSet 非空时为贴纸包任务中的贴纸（值为贴纸包的短名称）：ChatID 为贴纸包 id，MessageID 为贴纸 id
+	StickerSet string
+
+	// AvatarUserID 是成员头像所属用户 id（MediaType 为 avatar 时有效，0 = 聊天自身的头像）；
+	// 头像的 MessageID 为 AvatarMessageID(照片 id)
+	AvatarUserID int64
+}
+
+// avatarKeyBase 是头像记录占用的消息 id 区间下界偏移：头像行落在 [-2^63+1, -2^62]，
+// 与消息（正数）和快拍（-2^31 ~ -1）互不重叠
+const avatarKeyBase = 1 << 62
+
+// AvatarMessageID 返回头像照片在下载记录中占用的消息 id（由照片 id 派生，同一照片稳定不变）
+func AvatarMessageID(photoID int64) int64 {
+	return -(photoID & (avatarKeyBase - 1)) - avatarKeyBase
+}
+
+// StoryMessageID 返回快拍在下载记录中占用的消息 id：取 story id 的相反数，
+// 使快拍与同一聊天的消息共用 (chat_id, message_id) 幂等键而互不冲突（消息 id 恒为正）
+func StoryMessageID(storyID int64) int64 {
+	return -storyID
 }
 
 // RecordStatus 下载记录状态
@@ -95,9 +141,12 @@ type Downloader struct {
 	pauseFunc      func(context.Context, *MediaInfo) error
 	classifyByType atomic.Bool // Web 端可运行时切换，下载 goroutine 并发读取
 	saveMetadata   atomic.Bool // 下载完成后是否写元数据 sidecar
+	topicDirs      atomic.Bool // 论坛消息是否按话题分子目录存储
 	recordFunc     func(context.Context, RecordEvent)
 	// duplicateLookupFunc 按 unique_id 查找已完成下载的既有文件路径（内容级去重），可为 nil
 	duplicateLookupFunc func(context.Context, string) (string, bool)
+	// topicNameFunc 按 (聊天, 话题 id) 返回话题名称（写入元数据 sidecar），可为 nil
+	topicNameFunc func(chatID, topicID int64) string
 
 	progressMu        sync.RWMutex
 	progressByKey     map[string]*MediaProgress
@@ -106,10 +155,10 @@ type Downloader struct {
 	controls          map[string]*mediaControl
 	allPaused         bool // controlMu 保护：全局暂停闸，置位后新注册媒体以暂停态开始
 
-	rateMu      sync.Mutex
-	rateLast    map[int32]int64 // TDLib file id -> 上次观测的已下载字节数（按文件去重，避免多键扇出重复计数）
-	rateCum     int64           // 累计观测下载字节
-	rateSamples []rateSample    // (时刻, 累计字节) 采样，按时间递增
+	rateMu    sync.Mutex
+	rateLast  map[int32]int64        // TDLib file id -> 上次观测的已下载字节数（按文件去重，避免多键扇出重复计数）
+	rate      rateWindow             // 全部下载的聚合速率
+	taskRates map[string]*rateWindow // 任务 id -> 该任务媒体的速率（窗口内无样本即移除）
 }
 
 type rateSample struct {
@@ -117,6 +166,41 @@ type rateSample struct {
 	bytes int64
 }
 
+// rateWindow 是滑动窗口速率统计：累计字节与按时间递增的 (时刻, 累计字节) 采样
+type rateWindow struct {
+	cum     int64
+	samples []rateSample
+}
+
+func (w *rateWindow) add(n int64, now time.Time) {
+	w.cum += n
+	if k := len(w.samples); k == 0 || now.Sub(w.samples[k-1].at) >= speedSampleMinGap {
+		w.samples = append(w.samples, rateSample{at: now, bytes: w.cum})
+	}
+	w.prune(now)
+}
+
+func (w *rateWindow) prune(now time.Time) {
+	cut := 0
+	for cut < len(w.samples) && now.Sub(w.samples[cut].at) > speedWindow {
+		cut++
+	}
+	w.samples = w.samples[cut:]
+}
+
+func (w *rateWindow) speed(now time.Time) int64 {
+	w.prune(now)
+	if len(w.samples) == 0 {
+		return 0
+	}
+	oldest := w.samples[0]
+	elapsed := now.Sub(oldest.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(w.cum-oldest.bytes) / elapsed)
+}
+
 const (
 	// speedWindow 是下载速度滑动窗口长度
 	speedWindow = 5 * time.Second
@@ -157,6 +241,7 @@ func New(downloadPath string, maxConcurrent int, logger *logger.Logger) *Downloa
 		progressKeyByFile: make(map[int32]map[string]struct{}),
 		controls:          make(map[string]*mediaControl),
 		rateLast:          make(map[int32]int64),
+		taskRates:         make(map[string]*rateWindow),
 	}
 }
 
@@ -268,6 +353,16 @@ func (d *Downloader) SetSaveMetadata(v bool) {
 	d.saveMetadata.Store(v)
 }
 
+// SetTopicDirs 设置论坛消息是否按话题分子目录存储
+func (d *Downloader) SetTopicDirs(v bool) {
+	d.topicDirs.Store(v)
+}
+
+// SetTopicNameFunc 设置话题名称查找回调（话题名称记入元数据 sidecar，子目录固定为 topic_<id>）
+func (d *Downloader) SetTopicNameFunc(fn func(chatID, topicID int64) string) {
+	d.topicNameFunc = fn
+}
+
 // SetMaxConcurrent updates the number of media files that may download at once.
 func (d *Downloader) SetMaxConcurrent(maxConcurrent int) {
 	d.limiter.setLimit(maxConcurrent)
@@ -296,7 +391,8 @@ func (d *Downloader) record(ctx context.Context, evt RecordEvent) {
 // classifyDir 根据媒体类型返回分类子目录名
 func classifyDir(mediaType string) string {
 	switch mediaType {
-	case mediaTypePhoto, mediaTypeDocument, mediaTypeVideo, mediaTypeAnimation, mediaTypeAudio, mediaTypeVoice:
+	case mediaTypePhoto, mediaTypeDocument, mediaTypeVideo, mediaTypeAnimation, mediaTypeAudio, mediaTypeVoice,
+		mediaTypeVideoNote, mediaTypeSticker:
 		return mediaType
 	default:
 		return mediaTypeOther
@@ -371,12 +467,16 @@ func (d *Downloader) UpdateProgress(tdFileID int32, downloaded, total int64, com
 		return
 	}
 	now := time.Now()
-	d.noteFileBytes(tdFileID, downloaded, now)
+	delta := d.noteFileBytes(tdFileID, downloaded, now)
+	taskIDs := make([]string, 0, len(keys))
 	for key := range keys {
 		p := d.progressByKey[key]
 		if p == nil {
 			continue
 		}
+		if p.TaskID != "" && !slices.Contains(taskIDs, p.TaskID) {
+			taskIDs = append(taskIDs, p.TaskID)
+		}
 		if total > 0 {
 			p.FileSize = total
 		}
@@ -399,32 +499,44 @@ func (d *Downloader) UpdateProgress(tdFileID int32, downloaded, total int64, com
 		}
 		p.UpdatedAt = now
 	}
+	d.noteTaskBytes(taskIDs, delta, now)
 }
 
-// noteFileBytes 按 TDLib 文件维度累计下载字节正增量并采样，用于计算聚合下载速度。
+// noteFileBytes 按 TDLib 文件维度累计下载字节正增量并采样，用于计算聚合下载速度，返回本次计入的增量。
 // downloaded 变小视为重新下载，只重置基线不计负增量。
-func (d *Downloader) noteFileBytes(fileID int32, downloaded int64, now time.Time) {
+func (d *Downloader) noteFileBytes(fileID int32, downloaded int64, now time.Time) int64 {
 	if downloaded < 0 {
-		return
+		return 0
 	}
 	d.rateMu.Lock()
 	defer d.rateMu.Unlock()
+	var delta int64
 	if last := d.rateLast[fileID]; downloaded > last {
-		d.rateCum += downloaded - last
+		delta = downloaded - last
 	}
 	d.rateLast[fileID] = downloaded
-	if n := len(d.rateSamples); n == 0 || now.Sub(d.rateSamples[n-1].at) >= speedSampleMinGap {
-		d.rateSamples = append(d.rateSamples, rateSample{at: now, bytes: d.rateCum})
-	}
-	d.pruneSamplesLocked(now)
+	d.rate.add(delta, now)
+	return delta
 }
 
-func (d *Downloader) pruneSamplesLocked(now time.Time) {
-	cut := 0
-	for cut < len(d.rateSamples) && now.Sub(d.rateSamples[cut].at) > speedWindow {
-		cut++
+// noteTaskBytes 把一次文件增量计入其所属各任务的速率（同一文件被多个任务共享时各自计入），
+// 并移除窗口内已无样本的任务
+func (d *Downloader) noteTaskBytes(taskIDs []string, delta int64, now time.Time) {
+	d.rateMu.Lock()
+	defer d.rateMu.Unlock()
+	for _, id := range taskIDs {
+		w := d.taskRates[id]
+		if w == nil {
+			w = &rateWindow{}
+			d.taskRates[id] = w
+		}
+		w.add(delta, now)
+	}
+	for id, w := range d.taskRates {
+		if w.prune(now); len(w.samples) == 0 {
+			delete(d.taskRates, id)
+		}
 	}
-	d.rateSamples = d.rateSamples[cut:]
 }
 
 // SpeedBps 返回滑动窗口内的平均下载速度（字节/秒），无近期数据时为 0。
@@ -433,16 +545,36 @@ func (d *Downloader) SpeedBps() int64 { return d.speedAt(time.Now()) }
 func (d *Downloader) speedAt(now time.Time) int64 {
 	d.rateMu.Lock()
 	defer d.rateMu.Unlock()
-	d.pruneSamplesLocked(now)
-	if len(d.rateSamples) == 0 {
-		return 0
+	return d.rate.speed(now)
+}
+
+// TaskTransfer 是单个任务的实时传输状态
+type TaskTransfer struct {
+	SpeedBps     int64 // 滑动窗口内该任务媒体的平均下载速度（字节/秒）
+	PendingBytes int64 // 该任务已排队/下载中的媒体尚未下载的字节数
+}
+
+// TaskTransfer 返回任务 taskID 的实时下载速度与在途媒体的剩余字节数
+func (d *Downloader) TaskTransfer(taskID string) TaskTransfer {
+	return d.taskTransferAt(taskID, time.Now())
+}
+
+func (d *Downloader) taskTransferAt(taskID string, now time.Time) TaskTransfer {
+	var tr TaskTransfer
+	d.progressMu.RLock()
+	for _, p := range d.progressByKey {
+		if p.TaskID == taskID && p.FileSize > p.DownloadedSize {
+			tr.PendingBytes += p.FileSize - p.DownloadedSize
+		}
 	}
-	oldest := d.rateSamples[0]
-	elapsed := now.Sub(oldest.at).Seconds()
-	if elapsed <= 0 {
-		return 0
+	d.progressMu.RUnlock()
+
+	d.rateMu.Lock()
+	defer d.rateMu.Unlock()
+	if w := d.taskRates[taskID]; w != nil {
+		tr.SpeedBps = w.speed(now)
 	}
-	return int64(float64(d.rateCum-oldest.bytes) / elapsed)
+	return tr
 }
 
 func (d *Downloader) startProgress(media *MediaInfo, filePath string) string {
@@ -813,17 +945,26 @@ func (d *Downloader) recordSkip(ctx context.Context, media *MediaInfo, filePath,
 	d.record(ctx, RecordEvent{Media: media, Status: RecordSkipped, FilePath: filePath, Reason: reason})
 }
 
-// copyFromDuplicate 尝试按 unique_id 从既有文件复制；成功返回 true（已记 skipped）
-func (d *Downloader) copyFromDuplicate(ctx context.Context, media *MediaInfo, filePath string) bool {
+// duplicateSource 按 unique_id 查找可供复制的既有文件（不含 filePath 自身，源文件须仍在）
+func (d *Downloader) duplicateSource(ctx context.Context, media *MediaInfo, filePath string) (string, bool) {
 	if media.UniqueID == "" || d.duplicateLookupFunc == nil {
-		return false
+		return "", false
 	}
 	src, ok := d.duplicateLookupFunc(ctx, media.UniqueID)
 	if !ok || src == "" || src == filePath {
-		return false
+		return "", false
 	}
 	if _, err := os.Stat(src); err != nil {
-		return false // 源文件已删，照常下载
+		return "", false // 源文件已删，照常下载
+	}
+	return src, true
+}
+
+// copyFromDuplicate 尝试按 unique_id 从既有文件复制；成功返回 true（已记 skipped）
+func (d *Downloader) copyFromDuplicate(ctx context.Context, media *MediaInfo, filePath string) bool {
+	src, ok := d.duplicateSource(ctx, media, filePath)
+	if !ok {
+		return false
 	}
 	if err := copyFile(src, filePath); err != nil {
 		d.logger.Warn("去重复制失败，回退为正常下载: %v", err)
@@ -892,6 +1033,28 @@ func (d *Downloader) writeMetadataSidecar(media *MediaInfo, filePath string) {
 		"file_size":  media.FileSize,
 		"mime_type":  media.MimeType,
 	}
+	if media.PostID != 0 {
+		payload["post_chat_id"] = media.PostChatID
+		payload["post_id"] = media.PostID
+	}
+	if media.StoryID != 0 {
+		payload["story_id"] = media.StoryID
+	}
+	if media.Forwarded {
+		payload["forward_from_id"] = media.ForwardFromID
+		payload["forward_message_id"] = media.ForwardMessageID
+	}
+	if media.AvatarUserID != 0 {
+		payload["avatar_user_id"] = media.AvatarUserID
+	}
+	if media.TopicID != 0 {
+		payload["topic_id"] = media.TopicID
+		if d.topicNameFunc != nil {
+			if name := d.topicNameFunc(media.ChatID, media.TopicID); name != "" {
+				payload["topic_name"] = name
+			}
+		}
+	}
 	data, err := json.MarshalIndent(payload, "", "  ")
 	if err != nil {
 		d.logger.Warn("序列化元数据失败: %v", err)
@@ -944,7 +1107,29 @@ func (d *Downloader) downloadedBytes(key string) int64 {
 
 func (d *Downloader) planMediaPath(media *MediaInfo) (chatDir, fileName, filePath string) {
 	chatDir = filepath.Join(d.downloadPath, fmt.Sprintf("chat_%d", media.ChatID))
-	if d.classifyByType.Load() {
+	if media.PostID != 0 {
+		// 评论媒体归入所属频道目录下的 comments/post_<帖子id>，与帖子本身的媒体放在一起
+		chatDir = filepath.Join(d.downloadPath, fmt.Sprintf("chat_%d", media.PostChatID),
+			"comments", fmt.Sprintf("post_%d", media.PostID))
+	}
+	if media.StoryID != 0 {
+		chatDir = filepath.Join(chatDir, "stories")
+	}
+	if media.TopicID != 0 && d.topicDirs.Load() {
+		// 以 id 命名：同名话题不会混入同一目录，话题改名后已下载文件仍按原路径命中跳过
+		chatDir = filepath.Join(chatDir, fmt.Sprintf("topic_%d", media.TopicID))
+	}
+	switch {
+	case media.StickerSet != "":
+		// 贴纸包中的贴纸不属于任何聊天，按包名归入 stickers/<包名>
+		chatDir = d.StickerSetDir(media.StickerSet)
+	case media.MediaType == mediaTypeAvatar:
+		// 头像固定归入 avatars（成员头像再按用户分子目录），不参与类型分类与相册分组
+		chatDir = filepath.Join(chatDir, "avatars")
+		if media.AvatarUserID != 0 {
+			chatDir = filepath.Join(chatDir, fmt.Sprintf("user_%d", media.AvatarUserID))
+		}
+	case d.classifyByType.Load():
 		chatDir = filepath.Join(chatDir, classifyDir(media.MediaType))
 	}
 	if media.AlbumID != 0 {
@@ -962,6 +1147,30 @@ func (d *Downloader) planMediaPath(media *MediaInfo) (chatDir, fileName, filePat
 	return chatDir, fileName, filePath
 }
 
+// StickerSetDir 返回贴纸包的下载目录 stickers/<包名>（包名清理危险字符），贴纸文件与清单均写入此处
+func (d *Downloader) StickerSetDir(setName string) string {
+	return filepath.Join(d.downloadPath, "stickers", d.sanitizeFileName(setName))
+}
+
+// WriteStickerSetManifest 将贴纸包清单以 JSON 写入 stickers/<包名>/manifest.json（目录不存在时创建）
+func (d *Downloader) WriteStickerSetManifest(setName string, manifest any) error {
+	dir := d.StickerSetDir(setName)
+	if !d.isSafePath(dir, d.downloadPath) {
+		return fmt.Errorf("不安全的贴纸包目录: %s", dir)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化贴纸包清单失败: %w", err)
+	}
+	if err := os.MkdirAll(dir, DirectoryPermission); err != nil {
+		return fmt.Errorf("创建贴纸包目录失败: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "manifest.json"), data, metadataFilePerm); err != nil { // #nosec G306 -- 清单非敏感
+		return fmt.Errorf("写入贴纸包清单失败: %w", err)
+	}
+	return nil
+}
+
 // sanitizeFileName 清理文件名，移除危险字符
 func (d *Downloader) sanitizeFileName(fileName string) string {
 	// 移除路径分隔符和其他危险字符
@@ -1032,6 +1241,8 @@ func (d *Downloader) getFileExtension(mimeType string) string {
 		return ".ogg"
 	case "application/pdf":
 		return ".pdf"
+	case "application/x-tgsticker":
+		return ".tgs"
 	default:
 		return ""
 	}
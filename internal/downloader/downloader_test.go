@@ -2,8 +2,14 @@ package downloader
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"math"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -101,14 +107,16 @@ func TestDownloader_PauseWhileQueued(t *testing.T) {
 // TestClassifyDir 校验媒体类型到分类子目录的映射
 func TestClassifyDir(t *testing.T) {
 	cases := map[string]string{
-		"photo":     "photo",
-		"document":  "document",
-		"video":     "video",
-		"animation": "animation",
-		"audio":     "audio",
-		"voice":     "voice",
-		"sticker":   "other",
-		"":          "other",
+		"photo":      "photo",
+		"document":   "document",
+		"video":      "video",
+		"animation":  "animation",
+		"audio":      "audio",
+		"voice":      "voice",
+		"video_note": "video_note",
+		"sticker":    "sticker",
+		"poll":       "other",
+		"":           "other",
 	}
 	for mediaType, want := range cases {
 		if got := classifyDir(mediaType); got != want {
@@ -164,7 +172,7 @@ func TestDownloadMedia_ClassifyByType(t *testing.T) {
 			return os.WriteFile(filePath, []byte("data"), 0600)
 		})
 
-		media := &MediaInfo{MessageID: 1, ChatID: 100, MediaType: "sticker", FileName: "a.webp"}
+		media := &MediaInfo{MessageID: 1, ChatID: 100, MediaType: "poll", FileName: "a.webp"}
 		if err := d.DownloadMedia(context.Background(), media); err != nil {
 			t.Fatalf("DownloadMedia() error = %v", err)
 		}
@@ -176,6 +184,374 @@ func TestDownloadMedia_ClassifyByType(t *testing.T) {
 	})
 }
 
+// TestDownloadMedia_TopicDirs 校验论坛消息按 topic_<id> 分子目录（同名话题互不混放），话题名称记入 sidecar
+func TestDownloadMedia_TopicDirs(t *testing.T) {
+	dir := t.TempDir()
+	d := newTestDownloader(dir)
+	d.SetTopicDirs(true)
+	d.SetSaveMetadata(true)
+	d.SetTopicNameFunc(func(_, topicID int64) string {
+		if topicID == 7 || topicID == 8 {
+			return "公告"
+		}
+		return ""
+	})
+	d.SetDownloadFunc(func(_ context.Context, _ *MediaInfo, filePath string) error {
+		return os.WriteFile(filePath, []byte("data"), 0600)
+	})
+
+	cases := []struct {
+		media *MediaInfo
+		want  string
+	}{
+		{&MediaInfo{MessageID: 1, ChatID: 100, TopicID: 7, MediaType: "photo", FileName: "a.jpg"},
+			filepath.Join(dir, "chat_100", "topic_7", "a.jpg")},
+		{&MediaInfo{MessageID: 4, ChatID: 100, TopicID: 8, MediaType: "photo", FileName: "a.jpg"},
+			filepath.Join(dir, "chat_100", "topic_8", "a.jpg")},
+		{&MediaInfo{MessageID: 2, ChatID: 100, TopicID: 9, MediaType: "photo", FileName: "b.jpg"},
+			filepath.Join(dir, "chat_100", "topic_9", "b.jpg")},
+		{&MediaInfo{MessageID: 3, ChatID: 100, MediaType: "photo", FileName: "c.jpg"},
+			filepath.Join(dir, "chat_100", "c.jpg")},
+	}
+	for _, tc := range cases {
+		if err := d.DownloadMedia(context.Background(), tc.media); err != nil {
+			t.Fatalf("DownloadMedia() error = %v", err)
+		}
+		if _, err := os.Stat(tc.want); err != nil {
+			t.Errorf("expected file at %s, stat error: %v", tc.want, err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "chat_100", "topic_8", "a.jpg.json"))
+	if err != nil {
+		t.Fatalf("读取元数据 sidecar 失败: %v", err)
+	}
+	var meta map[string]any
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatalf("解析元数据 sidecar 失败: %v", err)
+	}
+	if meta["topic_id"] != float64(8) || meta["topic_name"] != "公告" {
+		t.Errorf("sidecar 话题信息不符: %v", meta)
+	}
+}
+
+// TestDownloadMedia_CommentMedia 校验频道评论媒体归入所属帖子目录，元数据 sidecar 记录所属帖子
+func TestDownloadMedia_CommentMedia(t *testing.T) {
+	dir := t.TempDir()
+	d := newTestDownloader(dir)
+	d.SetSaveMetadata(true)
+	d.SetDownloadFunc(func(_ context.Context, _ *MediaInfo, filePath string) error {
+		return os.WriteFile(filePath, []byte("data"), 0600)
+	})
+
+	media := &MediaInfo{MessageID: 50, ChatID: 200, PostChatID: 100, PostID: 7, MediaType: "photo", FileName: "c.jpg"}
+	if err := d.DownloadMedia(context.Background(), media); err != nil {
+		t.Fatalf("DownloadMedia() error = %v", err)
+	}
+	want := filepath.Join(dir, "chat_100", "comments", "post_7", "c.jpg")
+	if _, err := os.Stat(want); err != nil {
+		t.Fatalf("expected file at %s, stat error: %v", want, err)
+	}
+	data, err := os.ReadFile(want + ".json")
+	if err != nil {
+		t.Fatalf("读取元数据 sidecar 失败: %v", err)
+	}
+	var meta map[string]any
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatalf("解析元数据 sidecar 失败: %v", err)
+	}
+	if meta["post_chat_id"] != float64(100) || meta["post_id"] != float64(7) || meta["chat_id"] != float64(200) {
+		t.Errorf("sidecar 所属帖子信息不符: %v", meta)
+	}
+}
+
+// TestDownloadMedia_StoryMedia 校验快拍媒体归入 stories 子目录，重复下载时按已存在文件跳过
+func TestDownloadMedia_StoryMedia(t *testing.T) {
+	dir := t.TempDir()
+	d := newTestDownloader(dir)
+	calls := 0
+	d.SetDownloadFunc(func(_ context.Context, _ *MediaInfo, filePath string) error {
+		calls++
+		return os.WriteFile(filePath, []byte("data"), 0600)
+	})
+	var events []RecordEvent
+	d.SetRecordFunc(func(_ context.Context, evt RecordEvent) {
+		events = append(events, evt)
+	})
+
+	newMedia := func() *MediaInfo {
+		return &MediaInfo{MessageID: StoryMessageID(9), StoryID: 9, ChatID: 100, MediaType: "video", FileName: "story_100_9.mp4"}
+	}
+	for range 2 {
+		if err := d.DownloadMedia(context.Background(), newMedia()); err != nil {
+			t.Fatalf("DownloadMedia() error = %v", err)
+		}
+	}
+	want := filepath.Join(dir, "chat_100", "stories", "story_100_9.mp4")
+	if _, err := os.Stat(want); err != nil {
+		t.Fatalf("expected file at %s, stat error: %v", want, err)
+	}
+	if calls != 1 {
+		t.Errorf("download calls = %d, want 1", calls)
+	}
+	assertStatuses(t, events, []RecordStatus{RecordStarted, RecordCompleted, RecordSkipped})
+}
+
+// TestDownloadMedia_AvatarPaths 校验头像固定归入 avatars（成员头像按用户分目录），不受类型分类影响
+func TestDownloadMedia_AvatarPaths(t *testing.T) {
+	dir := t.TempDir()
+	d := newTestDownloader(dir)
+	d.SetClassifyByType(true)
+	d.SetDownloadFunc(func(_ context.Context, _ *MediaInfo, filePath string) error {
+		return os.WriteFile(filePath, []byte("data"), 0600)
+	})
+
+	for _, tc := range []struct {
+		media *MediaInfo
+		want  string
+	}{
+		{&MediaInfo{ChatID: 100, MessageID: AvatarMessageID(1), MediaType: "avatar", FileName: "avatar_100_1.jpg"},
+			filepath.Join(dir, "chat_100", "avatars", "avatar_100_1.jpg")},
+		{&MediaInfo{ChatID: 100, MessageID: AvatarMessageID(2), AvatarUserID: 7, MediaType: "avatar", FileName: "avatar_7_2.jpg"},
+			filepath.Join(dir, "chat_100", "avatars", "user_7", "avatar_7_2.jpg")},
+	} {
+		if err := d.DownloadMedia(context.Background(), tc.media); err != nil {
+			t.Fatalf("DownloadMedia() error = %v", err)
+		}
+		if _, err := os.Stat(tc.want); err != nil {
+			t.Errorf("expected file at %s, stat error: %v", tc.want, err)
+		}
+	}
+}
+
+// TestDownloadMedia_StickerSetDir 校验贴纸包贴纸与清单归入 stickers/<包名>（包名清理危险字符），不按聊天与类型分目录
+func TestDownloadMedia_StickerSetDir(t *testing.T) {
+	dir := t.TempDir()
+	d := newTestDownloader(dir)
+	d.SetClassifyByType(true)
+	d.SetDownloadFunc(func(_ context.Context, _ *MediaInfo, filePath string) error {
+		return os.WriteFile(filePath, []byte("data"), 0600)
+	})
+
+	media := &MediaInfo{ChatID: 9001, MessageID: 55, StickerSet: "../Cats", MediaType: "sticker", FileName: "sticker_55.webp"}
+	if err := d.DownloadMedia(context.Background(), media); err != nil {
+		t.Fatalf("DownloadMedia() error = %v", err)
+	}
+	want := filepath.Join(dir, "stickers", "__Cats", "sticker_55.webp")
+	if _, err := os.Stat(want); err != nil {
+		t.Fatalf("expected file at %s, stat error: %v", want, err)
+	}
+	if got := d.StickerSetDir("../Cats"); got != filepath.Dir(want) {
+		t.Errorf("StickerSetDir() = %s, want %s", got, filepath.Dir(want))
+	}
+
+	if err := d.WriteStickerSetManifest("../Cats", map[string]any{"title": "Cats"}); err != nil {
+		t.Fatalf("WriteStickerSetManifest() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(want), "manifest.json"))
+	if err != nil {
+		t.Fatalf("读取贴纸包清单失败: %v", err)
+	}
+	var manifest map[string]any
+	if err := json.Unmarshal(data, &manifest); err != nil || manifest["title"] != "Cats" {
+		t.Errorf("清单内容 = %s, err = %v", data, err)
+	}
+}
+
+// TestAvatarMessageID 校验头像记录 id 稳定且不与消息/快拍的 id 区间重叠
+func TestAvatarMessageID(t *testing.T) {
+	for _, photoID := range []int64{1, 5_000_000_000_000_000_000, -42, math.MaxInt64} {
+		id := AvatarMessageID(photoID)
+		if id != AvatarMessageID(photoID) || id > -avatarKeyBase {
+			t.Errorf("AvatarMessageID(%d) = %d, want stable and <= %d", photoID, id, -avatarKeyBase)
+		}
+		if id <= StoryMessageID(math.MaxInt32) && id >= StoryMessageID(1) {
+			t.Errorf("AvatarMessageID(%d) = %d overlaps story ids", photoID, id)
+		}
+	}
+}
+
+// TestHistoryFilters_SenderIDs 校验发送者过滤：只放行列表中的发送者，且计入 IsZero/Validate
+func TestHistoryFilters_SenderIDs(t *testing.T) {
+	f := HistoryFilters{SenderIDs: []int64{42, -1001234}}
+	if f.IsZero() {
+		t.Fatal("含发送者的过滤器不应为零值")
+	}
+	for sender, want := range map[int64]bool{42: true, -1001234: true, 7: false, 0: false} {
+		if got := f.MatchMedia(&MediaInfo{MediaType: mediaTypePhoto, SenderID: sender}); got != want {
+			t.Errorf("MatchMedia(sender=%d) = %v, want %v", sender, got, want)
+		}
+	}
+	if msg := (HistoryFilters{SenderIDs: []int64{0}}).Validate(); msg == "" {
+		t.Error("sender_ids 含 0 应校验失败")
+	}
+	if msg := (HistoryFilters{SenderIDs: make([]int64, MaxFilterSenders+1)}).Validate(); msg == "" {
+		t.Error("sender_ids 超出上限应校验失败")
+	}
+}
+
+// TestHistoryFilters_Patterns 校验说明文字/文件名/MIME 正则与大小下限
+func TestHistoryFilters_Patterns(t *testing.T) {
+	flac := &MediaInfo{MediaType: mediaTypeDocument, FileName: "Track 01.FLAC", MimeType: "audio/flac", FileSize: 30 << 20, Caption: "专辑"}
+	ad := &MediaInfo{MediaType: mediaTypeDocument, FileName: "promo.flac", MimeType: "audio/flac", FileSize: 30 << 20, Caption: "限时 ad 推广"}
+	small := &MediaInfo{MediaType: mediaTypeDocument, FileName: "x.flac", MimeType: "audio/flac", FileSize: 100, Caption: ""}
+	pdf := &MediaInfo{MediaType: mediaTypeDocument, FileName: "a.pdf", MimeType: "application/pdf", FileSize: 30 << 20}
+
+	f := HistoryFilters{FileNameInclude: `(?i)\.flac$`, CaptionExclude: `\bad\b`, MinFileSize: 1 << 20}
+	if msg := f.Validate(); msg != "" {
+		t.Fatalf("Validate() = %q", msg)
+	}
+	if f.IsZero() {
+		t.Fatal("含正则与大小下限的过滤器不应为零值")
+	}
+	for name, c := range map[string]struct {
+		m    *MediaInfo
+		want bool
+	}{"flac": {flac, true}, "ad": {ad, false}, "small": {small, false}, "pdf": {pdf, false}} {
+		if got := f.MatchMedia(c.m); got != c.want {
+			t.Errorf("MatchMedia(%s) = %v, want %v", name, got, c.want)
+		}
+	}
+	// 预编译的匹配器可重复使用；非法正则一律不匹配，nil 匹配器不过滤
+	match := f.Matcher()
+	if !match.Match(flac) || match.Match(ad) || !match.Match(flac) {
+		t.Error("Matcher 重复匹配结果不一致")
+	}
+	if (HistoryFilters{CaptionInclude: "("}).Matcher().Match(flac) || !(*MediaMatcher)(nil).Match(ad) {
+		t.Error("非法正则应不匹配，nil 匹配器应放行")
+	}
+	if !(HistoryFilters{MimeInclude: "^audio/"}).MatchMedia(flac) || (HistoryFilters{MimeExclude: "flac"}).MatchMedia(flac) {
+		t.Error("MIME 包含/排除正则未生效")
+	}
+
+	for _, bad := range []HistoryFilters{
+		{CaptionInclude: "("},
+		{MimeExclude: "[a-"},
+		{MinFileSize: 10, MaxFileSize: 5},
+		{MinFileSize: -1},
+		{FileNameInclude: strings.Repeat("a", maxPatternLen+1)},
+	} {
+		if msg := bad.Validate(); msg == "" {
+			t.Errorf("Validate(%+v) 应失败", bad)
+		}
+	}
+}
+
+func TestHistoryFilters_Forwards(t *testing.T) {
+	own := &MediaInfo{MediaType: mediaTypePhoto}
+	fromChannel := &MediaInfo{MediaType: mediaTypePhoto, Forwarded: true, ForwardFromID: -1001, ForwardMessageID: 9}
+	hidden := &MediaInfo{MediaType: mediaTypePhoto, Forwarded: true}
+
+	skip := HistoryFilters{ExcludeForwards: true}
+	if !skip.MatchMedia(own) || skip.MatchMedia(fromChannel) || skip.MatchMedia(hidden) {
+		t.Error("exclude_forwards 应只跳过转发消息")
+	}
+	only := HistoryFilters{ForwardFromIDs: []int64{-1001}}
+	if only.MatchMedia(own) || !only.MatchMedia(fromChannel) || only.MatchMedia(hidden) {
+		t.Error("forward_from_ids 应只保留转发自指定来源的消息")
+	}
+	if (HistoryFilters{ExcludeForwards: true, ForwardFromIDs: []int64{1}}).Validate() == "" {
+		t.Error("exclude_forwards 与 forward_from_ids 同时指定应校验失败")
+	}
+}
+
+func TestHistoryFilters_Popularity(t *testing.T) {
+	f := HistoryFilters{MinViews: 1000, MinReactions: 10}
+	if !f.HasPopularity() || f.IsZero() {
+		t.Fatal("设置了热度阈值的过滤器应报告 HasPopularity 且非零值")
+	}
+	for _, c := range []struct {
+		views, reactions int32
+		want             bool
+	}{{1000, 10, true}, {999, 50, false}, {5000, 9, false}} {
+		m := &MediaInfo{MediaType: mediaTypePhoto, ViewCount: c.views, ReactionCount: c.reactions}
+		if got := f.MatchMedia(m); got != c.want {
+			t.Errorf("MatchMedia(views=%d, reactions=%d) = %v, want %v", c.views, c.reactions, got, c.want)
+		}
+	}
+	if (HistoryFilters{MinForwards: -1}).Validate() == "" {
+		t.Error("负的热度阈值应校验失败")
+	}
+}
+
+func TestParseFilterExpr(t *testing.T) {
+	got, err := ParseFilterExpr(`type:video,document size>50MB date>=2024-01-01 caption~"lecture \"1\"" -from:@spambot,42`)
+	if err != nil {
+		t.Fatalf("ParseFilterExpr: %v", err)
+	}
+	f := got.Filters
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local).Unix()
+	if !slices.Equal(f.MediaTypes, []string{"video", "document"}) || f.MinFileSize != 50<<20+1 || f.DateFrom != from ||
+		f.CaptionInclude != `lecture "1"` || !slices.Equal(f.ExcludeSenderIDs, []int64{42}) ||
+		!slices.Equal(got.ExcludeSenders, []string{"@spambot"}) {
+		t.Fatalf("解析结果不符: %+v / %+v", f, got)
+	}
+
+	day, err := ParseFilterExpr("date:2024-03-05")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 3, 5, 0, 0, 0, 0, time.Local).Unix()
+	if day.Filters.DateFrom != start || day.Filters.DateTo != time.Date(2024, 3, 6, 0, 0, 0, 0, time.Local).Unix()-1 {
+		t.Errorf("date: 应覆盖整天，得到 %d..%d", day.Filters.DateFrom, day.Filters.DateTo)
+	}
+
+	for expr, pos := range map[string]int{
+		"type:video size>1XB":             16,
+		"type:video,bogus":                11,
+		"color:red":                       0,
+		"size>1MB size>=2MB":              9,
+		"size>10MB size<5MB":              10,
+		`caption~"(`:                      8,
+		"-size>1MB":                       0,
+		"caption~(":                       8,
+		"date>2024-13-01":                 5,
+		"type":                            4,
+		"from:abc":                        5,
+		"type:photo -type:video":          11,
+		"date:2024-01-01 date>2024-01-01": 16,
+		"is:forward":                      0,
+		"-is:forward forward:-1001":       12,
+		"views<100":                       5,
+		"views>=1k":                       7,
+	} {
+		_, err := ParseFilterExpr(expr)
+		var pe *FilterExprError
+		if !errors.As(err, &pe) {
+			t.Errorf("ParseFilterExpr(%q) 应返回 FilterExprError，得到 %v", expr, err)
+			continue
+		}
+		if pe.Pos != pos {
+			t.Errorf("ParseFilterExpr(%q) 错误位置 %d，want %d（%s）", expr, pe.Pos, pos, pe.Msg)
+		}
+	}
+}
+
+func TestFormatFilterExpr_RoundTrip(t *testing.T) {
+	for _, f := range []HistoryFilters{
+		{},
+		{MediaTypes: []string{"photo"}, MinFileSize: 50<<20 + 1, MaxFileSize: 1 << 30},
+		{MinFileSize: 1000, MaxFileSize: 2<<20 - 1, TopicID: 7},
+		{DateFrom: time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local).Unix(), DateTo: time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local).Unix() - 1},
+		{DateFrom: time.Date(2024, 1, 1, 8, 30, 0, 0, time.Local).Unix(), DateTo: time.Date(2024, 1, 1, 20, 0, 0, 0, time.Local).Unix()},
+		{SenderIDs: []int64{1, -100}, ExcludeSenderIDs: []int64{5}},
+		{ExcludeForwards: true},
+		{ForwardFromIDs: []int64{-1001, 7}},
+		{MinViews: 1000, MinForwards: 1, MinReactions: 50},
+		{CaptionInclude: `a "b" c\\`, FileNameExclude: `\.(tmp|part)$`, MimeInclude: `^video/`},
+	} {
+		text := FormatFilterExpr(f)
+		got, err := ParseFilterExpr(text)
+		if err != nil {
+			t.Errorf("ParseFilterExpr(%q): %v", text, err)
+			continue
+		}
+		if !reflect.DeepEqual(got.Filters, f) {
+			t.Errorf("往返不一致: %q\n got %+v\nwant %+v", text, got.Filters, f)
+		}
+	}
+}
+
 // TestDownloadMedia_RecordFunc 校验下载历史记录回调在各分支的事件序列
 func TestDownloadMedia_RecordFunc(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
@@ -492,6 +868,35 @@ func TestDownloader_SpeedTracking(t *testing.T) {
 	}
 }
 
+// TestDownloader_TaskTransfer 按任务归集速度与在途剩余字节：共享文件计入各自任务，无进度的任务速度为 0
+func TestDownloader_TaskTransfer(t *testing.T) {
+	d := newTestDownloader(t.TempDir())
+	mb := int64(1 << 20)
+	d.startProgress(&MediaInfo{TaskID: "a", ChatID: 1, MessageID: 1, TDFileID: 7, FileSize: 4 * mb}, "a1")
+	d.startProgress(&MediaInfo{TaskID: "b", ChatID: 2, MessageID: 1, TDFileID: 7, FileSize: 4 * mb}, "b1")
+	d.startProgress(&MediaInfo{TaskID: "b", ChatID: 2, MessageID: 2, TDFileID: 8, FileSize: 2 * mb}, "b2")
+	d.startProgress(&MediaInfo{TaskID: "c", ChatID: 3, MessageID: 1, TDFileID: 9, FileSize: mb}, "c1")
+
+	d.UpdateProgress(7, mb, 4*mb, false)
+	time.Sleep(speedSampleMinGap + 50*time.Millisecond)
+	d.UpdateProgress(7, 3*mb, 4*mb, false)
+
+	now := time.Now()
+	a, b, c := d.taskTransferAt("a", now), d.taskTransferAt("b", now), d.taskTransferAt("c", now)
+	if a.PendingBytes != mb || b.PendingBytes != mb+2*mb || c.PendingBytes != mb {
+		t.Fatalf("PendingBytes = %d/%d/%d, want %d/%d/%d", a.PendingBytes, b.PendingBytes, c.PendingBytes, mb, 3*mb, mb)
+	}
+	if a.SpeedBps <= 0 || b.SpeedBps != a.SpeedBps {
+		t.Fatalf("SpeedBps a=%d b=%d, want equal and > 0", a.SpeedBps, b.SpeedBps)
+	}
+	if c.SpeedBps != 0 {
+		t.Fatalf("SpeedBps c = %d, want 0", c.SpeedBps)
+	}
+	if got := d.taskTransferAt("a", now.Add(speedWindow+time.Second)).SpeedBps; got != 0 {
+		t.Fatalf("SpeedBps after window = %d, want 0", got)
+	}
+}
+
 func TestDownloader_PauseAndResumeMedia(t *testing.T) {
 	dir := t.TempDir()
 	d := New(dir, 1, logger.New(logger.LevelError))
@@ -614,3 +1019,61 @@ func TestDownloadMedia_DuplicateLookup(t *testing.T) {
 		t.Fatalf("源文件缺失应回退下载, downloads = %d", downloads)
 	}
 }
+
+func TestPreviewCollector_ClassifiesWithoutSideEffects(t *testing.T) {
+	dir := t.TempDir()
+	d := New(dir, 1, logger.New(logger.LevelError))
+	d.SetDownloadFunc(func(context.Context, *MediaInfo, string) error {
+		t.Error("试运行不应触发下载")
+		return nil
+	})
+	d.SetRecordFunc(func(context.Context, RecordEvent) { t.Error("试运行不应记录下载事件") })
+
+	src := filepath.Join(dir, "existing.bin")
+	if err := os.WriteFile(src, []byte("payload"), 0o600); err != nil {
+		t.Fatalf("WriteFile(src) error = %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "chat_100"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "chat_100", "have.jpg"), []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d.SetDuplicateLookupFunc(func(_ context.Context, uniqueID string) (string, bool) {
+		return src, uniqueID == "dup-1"
+	})
+
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := d.NewPreviewCollector()
+	for _, m := range []*MediaInfo{
+		{MessageID: 1, ChatID: 100, MediaType: "photo", FileName: "have.jpg", FileSize: 10, Date: day},
+		{MessageID: 2, ChatID: 100, MediaType: "document", FileName: "a.bin", FileSize: 100, UniqueID: "dup-1", Date: day.AddDate(0, 0, 1)},
+		{MessageID: 3, ChatID: 100, MediaType: "video", FileName: "v.mp4", FileSize: 1000, UniqueID: "u-3", Date: day.AddDate(0, 0, -3)},
+		{MessageID: 4, ChatID: 101, MediaType: "video", FileName: "v2.mp4", FileSize: 1000, UniqueID: "u-3", Date: day},
+	} {
+		c.Add(context.Background(), m)
+	}
+	p := c.Result(40, false)
+
+	if p.ScannedMessages != 40 || p.Files != 4 || p.Bytes != 2110 {
+		t.Errorf("totals = (%d, %d, %d), want (40, 4, 2110)", p.ScannedMessages, p.Files, p.Bytes)
+	}
+	if v := p.ByType["video"]; v == nil || v.Count != 2 || v.Bytes != 2000 {
+		t.Errorf("ByType[video] = %+v", v)
+	}
+	if p.Present != 1 || p.PresentBytes != 10 || p.Duplicates != 2 || p.DuplicateBytes != 1100 {
+		t.Errorf("skips = present %d/%d, duplicates %d/%d", p.Present, p.PresentBytes, p.Duplicates, p.DuplicateBytes)
+	}
+	if p.DateFrom != day.AddDate(0, 0, -3).Unix() || p.DateTo != day.AddDate(0, 0, 1).Unix() {
+		t.Errorf("date span = %d..%d", p.DateFrom, p.DateTo)
+	}
+	if len(p.Samples) != 4 || p.Samples[0].Skip != PreviewSkipPresent || p.Samples[2].Skip != "" || p.Samples[3].Skip != PreviewSkipDuplicate {
+		t.Errorf("samples = %+v", p.Samples)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "chat_101")); !os.IsNotExist(err) {
+		t.Errorf("试运行不应创建目录: %v", err)
+	}
+	if d.Snapshot().Total != 0 {
+		t.Error("试运行不应计入下载统计")
+	}
+}
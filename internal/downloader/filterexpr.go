@@ -0,0 +1,492 @@
+package downloader
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// 过滤表达式是 HistoryFilters 的文本形式：以空白分隔的若干条件，例如
+//
+//	type:video,document size>50MB date>=2024-01-01 caption~"lecture" -from:@spambot
+//
+// 支持的条件（前缀 - 表示取反，仅 type/from/is/caption/name/mime 可取反）：
+//
+//	type:<类型,...>          媒体类型；-type 为排除这些类型
+//	size>|>=|<|<=<大小>      文件大小，单位 B/KB/MB/GB（1024 进制，可带小数，缺省为字节）
+//	date>|>=|<|<=|:<日期>    消息日期，YYYY-MM-DD 或 YYYY-MM-DDTHH:MM:SS（服务器本地时区），date: 为当天
+//	from:<发送者,...>        发送者 id 或 @用户名；-from 为排除
+//	-is:forward             跳过转发消息
+//	forward:<来源,...>       只保留转发自这些来源（id 或 @用户名）的消息
+//	topic:<id>              论坛话题
+//	views>|>=<n>            浏览数下限；forwards、reactions 同理（被转发数、回应总数）
+//	caption~ name~ mime~    说明文字 / 文件名 / MIME 类型的正则；取反为排除
+//
+// 取值含空白时用双引号包裹，引号内 \" 与 \\ 为转义，其余反斜杠原样保留（便于书写正则）。
+
+// FilterExprError 是过滤表达式的解析错误；Pos 为出错处的字符偏移（从 0 起，按 Unicode 字符计）
+type FilterExprError struct {
+	Pos int
+	Msg string
+}
+
+func (e *FilterExprError) Error() string {
+	return fmt.Sprintf("过滤表达式第 %d 个字符处：%s", e.Pos+1, e.Msg)
+}
+
+// FilterExpr 是过滤表达式的解析结果。Senders/ExcludeSenders/ForwardSources 为尚未解析的 @用户名，
+// 由调用方解析为 id 后并入 Filters.SenderIDs/ExcludeSenderIDs/ForwardFromIDs
+type FilterExpr struct {
+	Filters        HistoryFilters
+	Senders        []string
+	ExcludeSenders []string
+	ForwardSources []string
+}
+
+// exprTerm 是表达式中的单个条件
+type exprTerm struct {
+	pos      int // 条件起点（含取反前缀）
+	negate   bool
+	key      string
+	op       string
+	value    string
+	valuePos int
+	quoted   bool
+}
+
+var (
+	sizePattern = regexp.MustCompile(`(?i)^(\d+(?:\.\d+)?)\s*(b|kb|k|mb|m|gb|g)?$`)
+	sizeUnits   = map[string]int64{"": 1, "b": 1, "k": 1 << 10, "kb": 1 << 10, "m": 1 << 20, "mb": 1 << 20, "g": 1 << 30, "gb": 1 << 30}
+)
+
+const (
+	exprDateLayout     = "2006-01-02"
+	exprDateTimeLayout = "2006-01-02T15:04:05"
+)
+
+// ParseFilterExpr 解析过滤表达式；空表达式得到零值过滤器。
+// 每个条件应用后即校验整体过滤器，冲突（如下限大于上限）报告在引入冲突的条件处
+func ParseFilterExpr(expr string) (FilterExpr, error) {
+	var out FilterExpr
+	src := []rune(expr)
+	seen := make(map[string]bool)
+	for pos := 0; ; {
+		for pos < len(src) && unicode.IsSpace(src[pos]) {
+			pos++
+		}
+		if pos >= len(src) {
+			return out, nil
+		}
+		term, next, err := lexTerm(src, pos)
+		if err != nil {
+			return FilterExpr{}, err
+		}
+		if err := applyTerm(&out, term, seen); err != nil {
+			return FilterExpr{}, err
+		}
+		if msg := out.Filters.Validate(); msg != "" {
+			return FilterExpr{}, &FilterExprError{Pos: term.pos, Msg: msg}
+		}
+		pos = next
+	}
+}
+
+// lexTerm 从 pos 起读取一个条件，返回条件与其后的位置
+func lexTerm(src []rune, pos int) (exprTerm, int, error) {
+	t := exprTerm{pos: pos}
+	if src[pos] == '-' {
+		t.negate = true
+		pos++
+	}
+	keyStart := pos
+	for pos < len(src) && (src[pos] >= 'a' && src[pos] <= 'z' || src[pos] == '_') {
+		pos++
+	}
+	t.key = string(src[keyStart:pos])
+	if t.key == "" {
+		return t, 0, &FilterExprError{Pos: keyStart, Msg: "缺少条件名"}
+	}
+	switch {
+	case pos+1 < len(src) && (src[pos] == '>' || src[pos] == '<') && src[pos+1] == '=':
+		t.op = string(src[pos : pos+2])
+	case pos < len(src) && strings.ContainsRune(":~<>", src[pos]):
+		t.op = string(src[pos])
+	default:
+		return t, 0, &FilterExprError{Pos: pos, Msg: fmt.Sprintf("条件 %s 后缺少运算符（: ~ > >= < <=）", t.key)}
+	}
+	pos += len(t.op)
+	t.valuePos = pos
+
+	if pos < len(src) && src[pos] == '"' {
+		t.quoted = true
+		var b strings.Builder
+		for i := pos + 1; i < len(src); i++ {
+			switch {
+			case src[i] == '"':
+				t.value = b.String()
+				return t, i + 1, nil
+			case src[i] == '\\' && i+1 < len(src) && (src[i+1] == '"' || src[i+1] == '\\'):
+				i++
+				b.WriteRune(src[i])
+			default:
+				b.WriteRune(src[i])
+			}
+		}
+		return t, 0, &FilterExprError{Pos: pos, Msg: "引号未闭合"}
+	}
+	for pos < len(src) && !unicode.IsSpace(src[pos]) {
+		pos++
+	}
+	t.value = string(src[t.valuePos:pos])
+	if t.value == "" {
+		return t, 0, &FilterExprError{Pos: t.valuePos, Msg: fmt.Sprintf("条件 %s 缺少取值", t.key)}
+	}
+	return t, pos, nil
+}
+
+// applyTerm 把单个条件写入解析结果；seen 记录已出现的条件（key+运算方向），拒绝重复
+func applyTerm(out *FilterExpr, t exprTerm, seen map[string]bool) error {
+	fail := func(pos int, format string, args ...any) error {
+		return &FilterExprError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+	}
+	if t.negate && !slices.Contains([]string{"type", "from", "is", "caption", "name", "mime"}, t.key) {
+		return fail(t.pos, "条件 %s 不支持取反", t.key)
+	}
+	wantOp := map[string]string{
+		"type": ":", "from": ":", "is": ":", "forward": ":", "topic": ":",
+		"caption": "~", "name": "~", "mime": "~",
+	}
+	if op, ok := wantOp[t.key]; ok && t.op != op {
+		return fail(t.valuePos-len(t.op), "条件 %s 须使用运算符 %s", t.key, op)
+	}
+	slot := t.key
+	if t.negate {
+		slot = "-" + slot
+	}
+	if t.key == "size" || t.key == "date" {
+		slot += t.op[:1] // 上下限各占一个位置；date: 同时占用两端
+	}
+	if seen[slot] || (t.key == "date" && (t.op == ":" && (seen["date>"] || seen["date<"]) || seen["date:"])) {
+		return fail(t.pos, "重复的 %s 条件", t.key)
+	}
+	seen[slot] = true
+
+	f := &out.Filters
+	switch t.key {
+	case "type":
+		var types []string
+		for _, part := range splitList(t) {
+			if !ValidMediaTypes[part.text] {
+				return fail(part.pos, "无效的媒体类型 %s", part.text)
+			}
+			types = append(types, part.text)
+		}
+		if t.negate {
+			types = complementTypes(types)
+			if len(types) == 0 {
+				return fail(t.pos, "排除了全部媒体类型")
+			}
+		}
+		if len(f.MediaTypes) > 0 {
+			return fail(t.pos, "type 与 -type 不能同时使用")
+		}
+		f.MediaTypes = types
+	case "size":
+		n, err := parseSize(t.value)
+		if err != nil {
+			return fail(t.valuePos, "%v", err)
+		}
+		switch t.op {
+		case ">":
+			f.MinFileSize = n + 1
+		case ">=":
+			f.MinFileSize = n
+		case "<":
+			if n <= 1 {
+				return fail(t.valuePos, "size< 须大于 1 字节")
+			}
+			f.MaxFileSize = n - 1
+		case "<=":
+			f.MaxFileSize = n
+		default:
+			return fail(t.valuePos-len(t.op), "size 须使用 > >= < <=")
+		}
+	case "date":
+		start, dayOnly, err := parseExprDate(t.value)
+		if err != nil {
+			return fail(t.valuePos, "%v", err)
+		}
+		end := start // 日期取值的"之后"为次日零点，日期时间取值为下一秒
+		if dayOnly {
+			end = start.AddDate(0, 0, 1)
+		} else {
+			end = start.Add(time.Second)
+		}
+		switch t.op {
+		case ":":
+			f.DateFrom, f.DateTo = start.Unix(), end.Unix()-1
+		case ">":
+			f.DateFrom = end.Unix()
+		case ">=":
+			f.DateFrom = start.Unix()
+		case "<":
+			f.DateTo = start.Unix() - 1
+		case "<=":
+			f.DateTo = end.Unix() - 1
+		default:
+			return fail(t.valuePos-len(t.op), "date 须使用 : > >= < <=")
+		}
+	case "is":
+		if t.value != "forward" {
+			return fail(t.valuePos, "未知的 is 取值 %s（仅支持 forward）", t.value)
+		}
+		if !t.negate {
+			return fail(t.pos, "is:forward 仅支持取反；只保留转发请用 forward:<来源>")
+		}
+		f.ExcludeForwards = true
+	case "from", "forward":
+		ids, names := &f.SenderIDs, &out.Senders
+		switch {
+		case t.key == "forward":
+			ids, names = &f.ForwardFromIDs, &out.ForwardSources
+		case t.negate:
+			ids, names = &f.ExcludeSenderIDs, &out.ExcludeSenders
+		}
+		for _, part := range splitList(t) {
+			if strings.HasPrefix(part.text, "@") && len(part.text) > 1 {
+				*names = append(*names, part.text)
+				continue
+			}
+			id, err := strconv.ParseInt(part.text, 10, 64)
+			if err != nil || id == 0 {
+				return fail(part.pos, "%s 须为 id 或 @用户名: %s", t.key, part.text)
+			}
+			*ids = append(*ids, id)
+		}
+	case "views", "forwards", "reactions":
+		if t.op != ">" && t.op != ">=" {
+			return fail(t.valuePos-len(t.op), "%s 须使用 > 或 >=", t.key)
+		}
+		n, err := strconv.ParseInt(t.value, 10, 32)
+		if err != nil || n < 0 || (t.op == ">" && n == math.MaxInt32) {
+			return fail(t.valuePos, "无效的计数 %s", t.value)
+		}
+		if t.op == ">" {
+			n++
+		}
+		switch t.key {
+		case "views":
+			f.MinViews = int32(n)
+		case "forwards":
+			f.MinForwards = int32(n)
+		default:
+			f.MinReactions = int32(n)
+		}
+	case "topic":
+		id, err := strconv.ParseInt(t.value, 10, 64)
+		if err != nil || id <= 0 {
+			return fail(t.valuePos, "无效的话题 id: %s", t.value)
+		}
+		f.TopicID = id
+	case "caption", "name", "mime":
+		if _, err := regexp.Compile(t.value); err != nil {
+			return fail(t.valuePos, "不是合法的正则: %v", err)
+		}
+		target := map[string][2]*string{
+			"caption": {&f.CaptionInclude, &f.CaptionExclude},
+			"name":    {&f.FileNameInclude, &f.FileNameExclude},
+			"mime":    {&f.MimeInclude, &f.MimeExclude},
+		}[t.key]
+		if t.negate {
+			*target[1] = t.value
+		} else {
+			*target[0] = t.value
+		}
+	default:
+		return fail(t.pos, "未知条件 %s", t.key)
+	}
+	return nil
+}
+
+// listPart 是逗号分隔取值中的一项及其字符偏移
+type listPart struct {
+	text string
+	pos  int
+}
+
+// splitList 按逗号拆分条件取值（跳过空项）；带引号的取值无法精确定位，各项偏移取取值起点
+func splitList(t exprTerm) []listPart {
+	var parts []listPart
+	offset := 0
+	for _, p := range strings.Split(t.value, ",") {
+		pos := t.valuePos
+		if !t.quoted {
+			pos += offset
+		}
+		offset += len([]rune(p)) + 1
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, listPart{text: p, pos: pos})
+		}
+	}
+	return parts
+}
+
+// complementTypes 返回合法媒体类型中不在 excluded 内的部分（按名称排序，保证输出稳定）
+func complementTypes(excluded []string) []string {
+	var out []string
+	for t := range ValidMediaTypes {
+		if !slices.Contains(excluded, t) {
+			out = append(out, t)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// parseSize 解析带单位的文件大小
+func parseSize(s string) (int64, error) {
+	m := sizePattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("无效的大小 %s（示例：50MB、1.5GB、2048）", s)
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("无效的大小 %s", s)
+	}
+	n := v * float64(sizeUnits[strings.ToLower(m[2])])
+	if n > math.MaxInt64/2 {
+		return 0, fmt.Errorf("大小 %s 超出范围", s)
+	}
+	return int64(n), nil
+}
+
+// parseExprDate 解析日期或日期时间（本地时区），dayOnly 报告取值是否只含日期
+func parseExprDate(s string) (t time.Time, dayOnly bool, err error) {
+	if t, err = time.ParseInLocation(exprDateLayout, s, time.Local); err == nil {
+		return t, true, nil
+	}
+	if t, err = time.ParseInLocation(exprDateTimeLayout, s, time.Local); err == nil {
+		return t, false, nil
+	}
+	return time.Time{}, false, fmt.Errorf("无效的日期 %s（格式 YYYY-MM-DD 或 YYYY-MM-DDTHH:MM:SS）", s)
+}
+
+// FormatFilterExpr 是 ParseFilterExpr 的逆操作：把过滤器写回表达式文本（零值为空串）。
+// 发送者与转发来源以 id 输出；解析输出文本得到的过滤器与 f 等价
+func FormatFilterExpr(f HistoryFilters) string {
+	var terms []string
+	if len(f.MediaTypes) > 0 {
+		terms = append(terms, "type:"+strings.Join(f.MediaTypes, ","))
+	}
+	if f.MinFileSize > 0 {
+		if exact, ok := formatSize(f.MinFileSize); ok || f.MinFileSize == 1 {
+			terms = append(terms, "size>="+exact)
+		} else if below, ok := formatSize(f.MinFileSize - 1); ok {
+			terms = append(terms, "size>"+below)
+		} else {
+			terms = append(terms, "size>="+exact)
+		}
+	}
+	if f.MaxFileSize > 0 {
+		if exact, ok := formatSize(f.MaxFileSize); ok {
+			terms = append(terms, "size<="+exact)
+		} else if above, ok := formatSize(f.MaxFileSize + 1); ok {
+			terms = append(terms, "size<"+above)
+		} else {
+			terms = append(terms, "size<="+exact)
+		}
+	}
+	if f.DateFrom != 0 {
+		terms = append(terms, "date>="+formatExprDate(time.Unix(f.DateFrom, 0).In(time.Local), time.Unix(f.DateFrom, 0)))
+	}
+	if f.DateTo != 0 {
+		// 闭区间上界：恰为某日最后一秒时写作该日期
+		terms = append(terms, "date<="+formatExprDate(time.Unix(f.DateTo+1, 0).In(time.Local), time.Unix(f.DateTo, 0)))
+	}
+	if len(f.SenderIDs) > 0 {
+		terms = append(terms, "from:"+joinIDs(f.SenderIDs))
+	}
+	if len(f.ExcludeSenderIDs) > 0 {
+		terms = append(terms, "-from:"+joinIDs(f.ExcludeSenderIDs))
+	}
+	if f.ExcludeForwards {
+		terms = append(terms, "-is:forward")
+	}
+	if len(f.ForwardFromIDs) > 0 {
+		terms = append(terms, "forward:"+joinIDs(f.ForwardFromIDs))
+	}
+	if f.TopicID != 0 {
+		terms = append(terms, "topic:"+strconv.FormatInt(f.TopicID, 10))
+	}
+	for _, p := range []struct {
+		key string
+		min int32
+	}{{"views", f.MinViews}, {"forwards", f.MinForwards}, {"reactions", f.MinReactions}} {
+		if p.min > 0 {
+			terms = append(terms, p.key+">="+strconv.FormatInt(int64(p.min), 10))
+		}
+	}
+	for _, p := range []struct{ term, expr string }{
+		{"caption~", f.CaptionInclude}, {"-caption~", f.CaptionExclude},
+		{"name~", f.FileNameInclude}, {"-name~", f.FileNameExclude},
+		{"mime~", f.MimeInclude}, {"-mime~", f.MimeExclude},
+	} {
+		if p.expr != "" {
+			terms = append(terms, p.term+quoteExprValue(p.expr))
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// formatSize 输出字节数的表达式写法；ok 报告是否恰为某个单位的整数倍（否则输出字节数）
+func formatSize(n int64) (s string, ok bool) {
+	for _, u := range []struct {
+		name string
+		size int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}} {
+		if n%u.size == 0 {
+			return strconv.FormatInt(n/u.size, 10) + u.name, true
+		}
+	}
+	return strconv.FormatInt(n, 10), false
+}
+
+// formatExprDate 在 boundary 恰为本地零点时输出 day 的日期，否则输出 exact 的日期时间
+func formatExprDate(boundary, exact time.Time) string {
+	if boundary.Hour() == 0 && boundary.Minute() == 0 && boundary.Second() == 0 {
+		return exact.In(time.Local).Format(exprDateLayout)
+	}
+	return exact.In(time.Local).Format(exprDateTimeLayout)
+}
+
+func joinIDs(ids []int64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+// quoteExprValue 以双引号包裹取值：转义引号，以及位于引号前、反斜杠前或末尾的反斜杠
+func quoteExprValue(s string) string {
+	r := []rune(s)
+	var b strings.Builder
+	b.WriteByte('"')
+	for i, c := range r {
+		switch {
+		case c == '"':
+			b.WriteString(`\"`)
+		case c == '\\' && (i+1 == len(r) || r[i+1] == '"' || r[i+1] == '\\'):
+			b.WriteString(`\\`)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
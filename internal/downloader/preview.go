@@ -0,0 +1,150 @@
+package downloader
+
+import (
+	"context"
+	"os"
+	"sync"
+)
+
+// PreviewSampleSize 是试运行结果中样例文件的数量上限
+const PreviewSampleSize = 50
+
+// 试运行中媒体的跳过原因
+const (
+	PreviewSkipPresent   = "present"   // 目标文件已存在
+	PreviewSkipDuplicate = "duplicate" // 同一 unique_id 已下载过（实际下载时复制既有文件）
+)
+
+// PreviewTypeStat 是试运行中单个媒体类型的文件数与字节数
+type PreviewTypeStat struct {
+	Count int64 `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+// PreviewFile 是试运行结果中的一个样例文件
+type PreviewFile struct {
+	ChatID    int64  `json:"chat_id"`
+	MessageID int64  `json:"message_id"`
+	MediaType string `json:"media_type"`
+	FileName  string `json:"file_name"`
+	FileSize  int64  `json:"file_size"`
+	Date      int64  `json:"date"`
+	Skip      string `json:"skip,omitempty"` // 实际下载时的跳过原因，空 = 会下载
+}
+
+// Preview 是试运行（只扫描不下载）的汇总结果。Files/Bytes 与 ByType 计入全部通过过滤的媒体，
+// 其中 Present/Duplicates 为实际下载时会跳过的部分
+type Preview struct {
+	ScannedMessages int64                       `json:"scanned_messages"`
+	Files           int64                       `json:"files"`
+	Bytes           int64                       `json:"bytes"`
+	ByType          map[string]*PreviewTypeStat `json:"by_type"`
+	// DateFrom/DateTo 是所发现媒体的消息日期跨度（unix 秒），无媒体时为 0
+	DateFrom int64 `json:"date_from,omitempty"`
+	DateTo   int64 `json:"date_to,omitempty"`
+
+	Present        int64 `json:"present"`
+	PresentBytes   int64 `json:"present_bytes"`
+	Duplicates     int64 `json:"duplicates"`
+	DuplicateBytes int64 `json:"duplicate_bytes"`
+
+	Samples []PreviewFile `json:"samples"`
+	// Truncated 表示扫描因达到消息数上限提前停止，结果只覆盖已扫描部分
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// PreviewCollector 汇总试运行中发现的媒体（并发安全）：按下载时的目录规划与去重规则
+// 判定每个媒体是否会被跳过，但不创建目录、不记录历史、不计入下载统计
+type PreviewCollector struct {
+	d *Downloader
+
+	mu     sync.Mutex
+	p      Preview
+	paths  map[string]bool // 本次试运行中已规划的目标路径：同名文件实际下载时后者会因已存在被跳过
+	unique map[string]bool // 本次试运行中已出现的 unique_id：重复项实际下载时由内容级去重复制
+}
+
+// NewPreviewCollector 创建试运行汇总器
+func (d *Downloader) NewPreviewCollector() *PreviewCollector {
+	return &PreviewCollector{
+		d:      d,
+		p:      Preview{ByType: make(map[string]*PreviewTypeStat), Samples: []PreviewFile{}},
+		paths:  make(map[string]bool),
+		unique: make(map[string]bool),
+	}
+}
+
+// Add 计入一个通过过滤的媒体
+func (c *PreviewCollector) Add(ctx context.Context, media *MediaInfo) {
+	_, fileName, filePath := c.d.planMediaPath(media)
+	// 磁盘与去重记录的检查在锁外进行，避免并发扫描时互相阻塞
+	skip := ""
+	if _, err := os.Stat(filePath); err == nil {
+		skip = PreviewSkipPresent
+	} else if _, dup := c.d.duplicateSource(ctx, media, filePath); dup {
+		skip = PreviewSkipDuplicate
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case skip != "":
+	case c.paths[filePath]:
+		skip = PreviewSkipPresent
+	case media.UniqueID != "" && c.unique[media.UniqueID]:
+		skip = PreviewSkipDuplicate
+	}
+	c.paths[filePath] = true
+	if media.UniqueID != "" {
+		c.unique[media.UniqueID] = true
+	}
+
+	p := &c.p
+	p.Files++
+	p.Bytes += media.FileSize
+	stat := p.ByType[media.MediaType]
+	if stat == nil {
+		stat = &PreviewTypeStat{}
+		p.ByType[media.MediaType] = stat
+	}
+	stat.Count++
+	stat.Bytes += media.FileSize
+	var date int64
+	if !media.Date.IsZero() {
+		date = media.Date.Unix()
+		if p.DateFrom == 0 || date < p.DateFrom {
+			p.DateFrom = date
+		}
+		p.DateTo = max(p.DateTo, date)
+	}
+	switch skip {
+	case PreviewSkipPresent:
+		p.Present++
+		p.PresentBytes += media.FileSize
+	case PreviewSkipDuplicate:
+		p.Duplicates++
+		p.DuplicateBytes += media.FileSize
+	}
+	if len(p.Samples) < PreviewSampleSize {
+		p.Samples = append(p.Samples, PreviewFile{
+			ChatID: media.ChatID, MessageID: media.MessageID, MediaType: media.MediaType,
+			FileName: fileName, FileSize: media.FileSize, Date: date, Skip: skip,
+		})
+	}
+}
+
+// Result 返回汇总结果的拷贝
+func (c *PreviewCollector) Result(scannedMessages int64, truncated bool) *Preview {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.p
+	p.ScannedMessages = scannedMessages
+	p.Truncated = truncated
+	p.ByType = make(map[string]*PreviewTypeStat, len(c.p.ByType))
+	for k, v := range c.p.ByType {
+		stat := *v
+		p.ByType[k] = &stat
+	}
+	p.Samples = append([]PreviewFile{}, c.p.Samples...)
+	return &p
+}
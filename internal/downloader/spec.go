@@ -1,36 +1,210 @@
 package downloader
 
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+)
+
 // HistorySpec 描述一次历史下载任务的执行参数，由 queue 组装、telegram 客户端消费。
 // 定义在本包（叶子包）以避免 telegram <-> queue 的 import 环。
 type HistorySpec struct {
 	ChatID int64
 	TaskID string
-	// FromMessageID 是续扫游标（最后已扫描页的最旧 message_id），0 表示从最新消息开始
+	// FromMessageID 是续扫游标（最后已扫描页的最旧 message_id；OldestFirst 时为最新 message_id），
+	// 0 表示从头开始
 	FromMessageID int64
+	// OldestFirst 为正序模式：由旧到新翻页下载，使下载目录按发布顺序填充、中断时已有完整的早期历史
+	OldestFirst bool
 	// MessageID 非 0 时为单消息下载任务（t.me 消息链接）：只下载该消息的媒体，不扫描历史
 	MessageID int64
+	// RangeStart/RangeEnd 非 0 时为消息区间任务（两条 t.me 消息链接）：只扫描 [RangeStart, RangeEnd]
+	// 内的消息（闭区间，任一端为 0 表示该端不限），续扫游标始终落在区间内
+	RangeStart int64
+	RangeEnd   int64
+	// SearchQuery 非空时只下载聊天内匹配该关键词或话题标签（#tag）的消息：
+	// 经服务端聊天内搜索翻页而非逐页翻阅全部历史，Filters 在其结果上继续生效
+	SearchQuery string
+	// Comments 为频道评论模式：扫描每条帖子时一并翻阅其评论区（关联讨论组中的回复线程）并下载其中的媒体
+	Comments bool
+	// Members 为头像任务同时下载聊天成员的个人头像（成员列表需可见）
+	Members bool
+	// Messages 非空时为跨聊天搜索任务：只下载所列消息的媒体（ChatID 为 0），
+	// 下载记录沿用各消息所在聊天的真实 chat_id
+	Messages []MessageRef
+	// LeaveAfter 为任务完成后退出该聊天：经邀请链接临时加入、只为下载历史时设置（由任务终结回调执行）
+	LeaveAfter bool
 	// Filters 是任务级媒体过滤条件（零值 = 不过滤）
 	Filters HistoryFilters
 	// RetryMessageIDs 是恢复任务时需优先补下的消息（进程重启清扫的中断行，
 	// 比游标更新，仅靠游标续扫会永久漏掉）
 	RetryMessageIDs []int64
+	// Incremental 为增量模式：只处理聊天同步水位之后的新消息，完成后推进水位（由 queue 持久化与解析）
+	Incremental bool
+	// StopAtMessageID 非 0 时扫描到该消息 id（不含）即停止，增量模式下由 queue 填入聊天水位；
+	// OldestFirst 时改为从该消息之后开始扫描
+	StopAtMessageID int64
+	// ScanSegments > 1 时把聊天按消息 id（SegmentByDate 时按日期）切成若干段并行扫描；0 = 沿用配置
+	ScanSegments  int
+	SegmentByDate bool
+	// Segments 是上次运行持久化的分段状态；非空时按其续扫各段，忽略 ScanSegments/FromMessageID
+	Segments []ScanSegment
+}
+
+// MessageRef 定位某个聊天中的单条消息
+type MessageRef struct {
+	ChatID    int64 `json:"chat_id"`
+	MessageID int64 `json:"message_id"`
+}
+
+// MaxSearchMessages 是单个跨聊天搜索任务可选择的消息数上限
+const MaxSearchMessages = 1000
+
+// IsRange 报告是否为消息区间任务
+func (s *HistorySpec) IsRange() bool {
+	return s.RangeStart != 0 || s.RangeEnd != 0
+}
+
+// MaxScanSegments 是单个任务允许的最大扫描分段数
+const MaxScanSegments = 16
+
+// ScanSegment 是分段扫描中的一段消息 id 区间 (Low, High]，各段互不重叠、由新到旧独立翻页
+type ScanSegment struct {
+	High int64 `json:"high"`
+	Low  int64 `json:"low"`
+	// Cursor 是段内续扫游标（已扫描到的最旧消息 id），0 = 尚未开始
+	Cursor int64 `json:"cursor,omitempty"`
+	Done   bool  `json:"done,omitempty"`
 }
 
 // HistoryFilters 是任务级媒体过滤条件；JSON 序列化后持久化在 tasks.filters 列，
 // 并作为 POST /api/tasks 的 filters 字段
 type HistoryFilters struct {
-	// MediaTypes 是要下载的媒体类型子集（photo/video/document/animation/audio/voice），空 = 全部
+	// MediaTypes 是要下载的媒体类型子集（ValidMediaTypes 中的 photo/video/document/animation/audio/voice/video_note/sticker），空 = 全部
 	MediaTypes []string `json:"media_types,omitempty"`
 	// DateFrom/DateTo 是消息日期区间（unix 秒，闭区间），0 = 不限
 	DateFrom int64 `json:"date_from,omitempty"`
 	DateTo   int64 `json:"date_to,omitempty"`
-	// MaxFileSize 是单文件大小上限（字节），0 = 不限
+	// MaxFileSize 是单文件大小上限（字节），0 = 不限；MinFileSize 为下限
 	MaxFileSize int64 `json:"max_file_size,omitempty"`
+	MinFileSize int64 `json:"min_file_size,omitempty"`
+	// TopicID 限定论坛超级群组的单个话题（forum_topic_id），0 = 全部话题；
+	// history 任务据此只翻阅该话题的历史，monitor 任务只下载该话题的新消息
+	TopicID int64 `json:"topic_id,omitempty"`
+	// SenderIDs 只保留这些发送者（用户 id 或以频道/群组身份发言的聊天 id）的媒体，空 = 不限；
+	// 单个发送者时 history 扫描改用服务端按发送者搜索，无需翻阅全部历史
+	SenderIDs []int64 `json:"sender_ids,omitempty"`
+	// ExcludeSenderIDs 排除这些发送者的媒体（在本地筛选）
+	ExcludeSenderIDs []int64 `json:"exclude_sender_ids,omitempty"`
+	// ExcludeForwards 跳过转发消息；ForwardFromIDs 只保留转发自这些来源（原始用户 id 或频道/群组 chat id）的消息，
+	// 两者互斥
+	ExcludeForwards bool    `json:"exclude_forwards,omitempty"`
+	ForwardFromIDs  []int64 `json:"forward_from_ids,omitempty"`
+	// MinViews/MinForwards/MinReactions 是热度阈值：浏览数、被转发数、回应总数的下限，0 = 不限；
+	// 以扫描时取得的计数判定
+	MinViews     int32 `json:"min_views,omitempty"`
+	MinForwards  int32 `json:"min_forwards,omitempty"`
+	MinReactions int32 `json:"min_reactions,omitempty"`
+	// 以下为 Go 正则（RE2 语法，区分大小写，可用 (?i) 忽略大小写），空 = 不限：
+	// *Include 要求匹配，*Exclude 匹配即排除。分别作用于说明文字、原始文件名与 MIME 类型
+	CaptionInclude  string `json:"caption_include,omitempty"`
+	CaptionExclude  string `json:"caption_exclude,omitempty"`
+	FileNameInclude string `json:"file_name_include,omitempty"`
+	FileNameExclude string `json:"file_name_exclude,omitempty"`
+	MimeInclude     string `json:"mime_include,omitempty"`
+	MimeExclude     string `json:"mime_exclude,omitempty"`
 }
 
+// maxPatternLen 是单个过滤正则的最大长度（字节）
+const maxPatternLen = 512
+
+// MaxFilterSenders 是单个过滤器允许的发送者数上限
+const MaxFilterSenders = 50
+
 // IsZero 报告过滤器是否为零值（不过滤）
 func (f HistoryFilters) IsZero() bool {
-	return len(f.MediaTypes) == 0 && f.DateFrom == 0 && f.DateTo == 0 && f.MaxFileSize == 0
+	return len(f.MediaTypes) == 0 && f.DateFrom == 0 && f.DateTo == 0 && f.MaxFileSize == 0 && f.MinFileSize == 0 &&
+		f.TopicID == 0 && len(f.SenderIDs) == 0 && len(f.ExcludeSenderIDs) == 0 &&
+		!f.ExcludeForwards && len(f.ForwardFromIDs) == 0 && !f.HasPopularity() && !f.hasPatterns()
+}
+
+// SyncKey 返回过滤器的规范形式（列表字段排序去重后的 JSON），用作增量同步水位的键：
+// 条件相同而书写顺序不同的过滤器得到同一个键；零值过滤器为空串，即整聊天的无过滤水位
+func (f HistoryFilters) SyncKey() string {
+	if f.IsZero() {
+		return ""
+	}
+	f.MediaTypes = slices.Compact(slices.Sorted(slices.Values(f.MediaTypes)))
+	f.SenderIDs = slices.Compact(slices.Sorted(slices.Values(f.SenderIDs)))
+	f.ExcludeSenderIDs = slices.Compact(slices.Sorted(slices.Values(f.ExcludeSenderIDs)))
+	f.ForwardFromIDs = slices.Compact(slices.Sorted(slices.Values(f.ForwardFromIDs)))
+	data, err := json.Marshal(f)
+	if err != nil {
+		return fmt.Sprintf("%+v", f) // 仅含基本类型的结构体不会序列化失败，兜底仍保证键确定
+	}
+	return string(data)
+}
+
+// HasPopularity 报告过滤器是否设置了热度阈值
+func (f HistoryFilters) HasPopularity() bool {
+	return f.MinViews > 0 || f.MinForwards > 0 || f.MinReactions > 0
+}
+
+// hasPatterns 报告是否设置了任一正则条件
+func (f HistoryFilters) hasPatterns() bool {
+	return f.CaptionInclude != "" || f.CaptionExclude != "" || f.FileNameInclude != "" ||
+		f.FileNameExclude != "" || f.MimeInclude != "" || f.MimeExclude != ""
+}
+
+// patternRule 是一组作用于同一文本的包含/排除正则
+type patternRule struct {
+	field            string // JSON 字段名前缀，用于校验报错
+	include, exclude string
+}
+
+// patternRules 返回过滤器的三组正则条件（字段名前缀与 JSON 一致）
+func (f HistoryFilters) patternRules() [3]patternRule {
+	return [3]patternRule{
+		{"caption", f.CaptionInclude, f.CaptionExclude},
+		{"file_name", f.FileNameInclude, f.FileNameExclude},
+		{"mime", f.MimeInclude, f.MimeExclude},
+	}
+}
+
+// compiledRule 是编译后的一组包含/排除正则；invalid 表示表达式非法（已由 Validate 拦截），一律不匹配
+type compiledRule struct {
+	include, exclude *regexp.Regexp
+	invalid          bool
+}
+
+// compileRule 编译一组包含/排除正则
+func compileRule(r patternRule) compiledRule {
+	var c compiledRule
+	var err error
+	if r.include != "" {
+		if c.include, err = regexp.Compile(r.include); err != nil {
+			c.invalid = true
+		}
+	}
+	if r.exclude != "" {
+		if c.exclude, err = regexp.Compile(r.exclude); err != nil {
+			c.invalid = true
+		}
+	}
+	return c
+}
+
+// match 报告 text 是否满足该组正则
+func (c compiledRule) match(text string) bool {
+	if c.invalid {
+		return false
+	}
+	if c.include != nil && !c.include.MatchString(text) {
+		return false
+	}
+	return c.exclude == nil || !c.exclude.MatchString(text)
 }
 
 // Match 报告一个媒体项（类型/消息日期 unix 秒/文件字节数）是否通过过滤
@@ -56,13 +230,71 @@ func (f HistoryFilters) Match(mediaType string, date, size int64) bool {
 	if f.MaxFileSize > 0 && size > f.MaxFileSize {
 		return false
 	}
+	if f.MinFileSize > 0 && size < f.MinFileSize {
+		return false
+	}
 	return true
 }
 
+// MediaMatcher 是预编译了正则条件的过滤器，由 HistoryFilters.Matcher 创建。
+// 逐媒体匹配的扫描循环与监控任务应持有同一个 MediaMatcher，避免每项重新编译正则
+type MediaMatcher struct {
+	f     HistoryFilters
+	rules [3]compiledRule // 与 patternRules 顺序一致：说明文字、文件名、MIME
+}
+
+// Matcher 编译过滤器中的正则，返回可并发复用的匹配器
+func (f HistoryFilters) Matcher() *MediaMatcher {
+	mm := &MediaMatcher{f: f}
+	if f.hasPatterns() {
+		for i, r := range f.patternRules() {
+			mm.rules[i] = compileRule(r)
+		}
+	}
+	return mm
+}
+
+// MatchMedia 报告一个已提取的媒体项是否通过过滤；每次调用都会编译正则，仅适合单次判定
+func (f HistoryFilters) MatchMedia(m *MediaInfo) bool {
+	return f.Matcher().Match(m)
+}
+
+// Match 报告一个已提取的媒体项是否通过过滤（含 Match 的全部条件与话题、发送者、转发来源、热度、正则限定）；
+// nil 匹配器不过滤
+func (mm *MediaMatcher) Match(m *MediaInfo) bool {
+	if mm == nil {
+		return true
+	}
+	f := mm.f
+	if f.TopicID != 0 && m.TopicID != f.TopicID {
+		return false
+	}
+	if len(f.SenderIDs) > 0 && !slices.Contains(f.SenderIDs, m.SenderID) {
+		return false
+	}
+	if slices.Contains(f.ExcludeSenderIDs, m.SenderID) {
+		return false
+	}
+	if f.ExcludeForwards && m.Forwarded {
+		return false
+	}
+	if len(f.ForwardFromIDs) > 0 && (!m.Forwarded || !slices.Contains(f.ForwardFromIDs, m.ForwardFromID)) {
+		return false
+	}
+	if m.ViewCount < f.MinViews || m.ForwardCount < f.MinForwards || m.ReactionCount < f.MinReactions {
+		return false
+	}
+	if !f.Match(m.MediaType, m.Date.Unix(), m.FileSize) {
+		return false
+	}
+	return mm.rules[0].match(m.Caption) && mm.rules[1].match(m.FileName) && mm.rules[2].match(m.MimeType)
+}
+
 // ValidMediaTypes 是 MediaTypes 的合法取值集合
 var ValidMediaTypes = map[string]bool{
 	mediaTypePhoto: true, mediaTypeVideo: true, mediaTypeDocument: true,
 	mediaTypeAnimation: true, mediaTypeAudio: true, mediaTypeVoice: true,
+	mediaTypeVideoNote: true, mediaTypeSticker: true,
 }
 
 // Validate 校验过滤器字段合法性，返回首个问题的描述（合法时为空串）
@@ -75,8 +307,45 @@ func (f HistoryFilters) Validate() string {
 	if f.DateFrom != 0 && f.DateTo != 0 && f.DateFrom > f.DateTo {
 		return "date_from 不能晚于 date_to"
 	}
-	if f.MaxFileSize < 0 {
-		return "max_file_size 不能为负"
+	if f.MaxFileSize < 0 || f.MinFileSize < 0 {
+		return "文件大小限制不能为负"
+	}
+	if f.MaxFileSize > 0 && f.MinFileSize > f.MaxFileSize {
+		return "min_file_size 不能大于 max_file_size"
+	}
+	for _, r := range f.patternRules() {
+		for _, p := range [2]struct{ name, expr string }{{r.field + "_include", r.include}, {r.field + "_exclude", r.exclude}} {
+			if p.expr == "" {
+				continue
+			}
+			if len(p.expr) > maxPatternLen {
+				return fmt.Sprintf("%s 过长（最多 %d 字节）", p.name, maxPatternLen)
+			}
+			if _, err := regexp.Compile(p.expr); err != nil {
+				return fmt.Sprintf("%s 不是合法的正则: %v", p.name, err)
+			}
+		}
+	}
+	if f.TopicID < 0 || f.TopicID > math.MaxInt32 {
+		return "无效的 topic_id"
+	}
+	if len(f.SenderIDs) > MaxFilterSenders || len(f.ExcludeSenderIDs) > MaxFilterSenders {
+		return fmt.Sprintf("sender_ids / exclude_sender_ids 各最多 %d 个", MaxFilterSenders)
+	}
+	if slices.Contains(f.SenderIDs, 0) || slices.Contains(f.ExcludeSenderIDs, 0) {
+		return "无效的发送者 id"
+	}
+	if f.ExcludeForwards && len(f.ForwardFromIDs) > 0 {
+		return "exclude_forwards 与 forward_from_ids 不能同时指定"
+	}
+	if len(f.ForwardFromIDs) > MaxFilterSenders {
+		return fmt.Sprintf("forward_from_ids 最多 %d 个", MaxFilterSenders)
+	}
+	if slices.Contains(f.ForwardFromIDs, 0) {
+		return "无效的转发来源 id"
+	}
+	if f.MinViews < 0 || f.MinForwards < 0 || f.MinReactions < 0 {
+		return "热度阈值不能为负"
 	}
 	return ""
 }
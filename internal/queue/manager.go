@@ -3,6 +3,7 @@ package queue
 import (
 	"context"
 	"fmt"
+	"slices"
 	"sync"
 	"time"
 
@@ -20,9 +21,15 @@ const (
 	recordDrainTimeout = 5 * time.Second
 	// persistInterval 是运行中任务进度周期性落盘的间隔，避免崩溃/硬杀丢失中途进度统计
 	persistInterval = 10 * time.Second
+	// transferNotifyInterval 是运行中任务传输估算（速度/剩余时间）的周期推送间隔
+	transferNotifyInterval = 2 * time.Second
 	// retryBaseBackoff/retryMaxBackoff 界定任务级自动重试的指数退避区间
 	retryBaseBackoff = 30 * time.Second
 	retryMaxBackoff  = 5 * time.Minute
+	// monitorDownloadConcurrency 是单个 monitor 任务同时在途的下载数上限（仍受 Downloader 全局并发约束）
+	monitorDownloadConcurrency = 3
+	// monitorCatchUpRetry 是离线补扫失败（如 TDLib 尚未就绪）后的重试间隔
+	monitorCatchUpRetry = 30 * time.Second
 )
 
 // Manager 任务队列管理器：history 任务经有界 worker 池调度，monitor 任务独立运行，互不阻塞。
@@ -41,17 +48,16 @@ type Manager struct {
 	// resumeHistory/resumeMonitor 由 loadTasks 收集、Run 启动时消费一次：
 	// 进程重启前排队中/运行中的任务在此恢复续跑，而非回收为 failed
 	resumeHistory []*task
-	resumeMonitor *task
+	resumeMonitor []*task
 
-	mu          sync.Mutex
-	tasks       map[string]*task
-	order       []*task // 插入顺序（最早在前），List() 据此反转为最新优先
-	monitorTask *task
-	onChange    func(*TaskDTO)
-	onTerminal  func(*TaskDTO) // 任务终结通知（completed/最终 failed，取消与自动重试不触发）
-	runCtx      context.Context
+	mu         sync.Mutex
+	tasks      map[string]*task
+	order      []*task // 插入顺序（最早在前），List() 据此反转为最新优先
+	onChange   func(*TaskDTO)
+	onTerminal func(*TaskDTO) // 任务终结通知（completed/最终 failed，取消与自动重试不触发）
+	runCtx     context.Context
 
-	monitorMu sync.Mutex // 串行化 monitor 切换，保证同一时刻至多一个 monitor 任务在运行
+	monitorMu sync.Mutex // 串行化 monitor 的创建/停止，保证同一聊天运行中的 monitor 任务话题互不重叠
 }
 
 // NewManager 创建任务队列管理器：将 client 的下载记录/去重回调指向自身，
@@ -77,13 +83,10 @@ func NewManager(client ChatDownloader, st *store.Store, log *logger.Logger, maxC
 	}
 	client.SetRecordFunc(m.handleRecordEvent)
 	client.SetScanProgressFunc(m.handleScanProgress)
-	client.SetDuplicateLookupFunc(func(ctx context.Context, uniqueID string) (string, bool) {
-		rec, err := st.FindCompletedByUniqueID(ctx, uniqueID)
-		if err != nil || rec == nil {
-			return "", false
-		}
-		return rec.FilePath, true
-	})
+	client.SetSegmentProgressFunc(m.handleSegmentProgress)
+	client.SetMonitorMediaFunc(m.handleMonitorMedia)
+	client.SetConnectionReadyFunc(m.handleConnectionReady)
+	client.SetDuplicateLookupFunc(store.NewDuplicateLookup(st))
 	m.loadTasks(context.Background())
 	return m
 }
@@ -114,6 +117,20 @@ func (m *Manager) handleScanProgress(taskID string, scannedMessages, foundMedia,
 	}
 }
 
+// handleSegmentProgress 是 client 的分段扫描状态回调：只更新任务内存态，由周期落盘持久化各段游标
+// （扫描进度推送已由 handleScanProgress 限频承担）
+func (m *Manager) handleSegmentProgress(taskID string, segments []downloader.ScanSegment) {
+	m.mu.Lock()
+	t := m.tasks[taskID]
+	m.mu.Unlock()
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.segments = segments
+	t.mu.Unlock()
+}
+
 // loadTasks 从 store 恢复任务历史列表，供 NewManager 在接受任何新任务前调用一次：
 // 终态任务原样载入；重启前排队中/运行中的 history 任务重置为 queued 并记入待恢复列表
 // （保留统计/游标，Run 启动后从游标续扫并补下中断行）；运行中的 monitor 任务同样待恢复重启。
@@ -138,7 +155,7 @@ func (m *Manager) loadTasks(ctx context.Context) {
 	for i := len(rows) - 1; i >= 0; i-- {
 		t := taskFromRow(rows[i])
 		switch {
-		case t.kind == KindHistory && (t.status == StatusQueued || t.status == StatusRunning):
+		case t.kind.pooled() && (t.status == StatusQueued || t.status == StatusRunning):
 			t.status = StatusQueued
 			t.startedAt = nil
 			t.resumed = true
@@ -149,10 +166,10 @@ func (m *Manager) loadTasks(ctx context.Context) {
 			m.logger.Info("任务 %s（聊天 %d）待恢复：游标 %d", t.id, t.chatID, t.scanCursor)
 		case t.kind == KindMonitor && t.status == StatusRunning:
 			// 监控任务重启后自动恢复（用户开着的监控预期保持开启），Run 启动时重建 goroutine
-			if m.resumeMonitor == nil {
-				m.resumeMonitor = t
+			if !m.hasResumeMonitorLocked(t.chatID, t.filters.TopicID) {
+				m.resumeMonitor = append(m.resumeMonitor, t)
 			} else {
-				// 数据异常：多个 running monitor，只恢复最新的一个，其余终结
+				// 数据异常：同一聊天多个话题重叠的 running monitor，只恢复最早的一个，其余终结
 				t.status = StatusCanceled
 				now := time.Now()
 				t.finishedAt = &now
@@ -169,6 +186,17 @@ func (m *Manager) loadTasks(ctx context.Context) {
 	}
 }
 
+// hasResumeMonitorLocked 报告待恢复列表中是否已有与 (chatID, topicID) 话题重叠的 monitor 任务，
+// 规则与 enqueueMonitor 一致（调用方须持有 m.mu）
+func (m *Manager) hasResumeMonitorLocked(chatID, topicID int64) bool {
+	for _, t := range m.resumeMonitor {
+		if t.chatID == chatID && topicsOverlap(t.filters.TopicID, topicID) {
+			return true
+		}
+	}
+	return false
+}
+
 // SetOnChange 设置任务生命周期变化回调（created/running/completed/failed/canceled），不逐文件触发
 func (m *Manager) SetOnChange(fn func(*TaskDTO)) {
 	m.mu.Lock()
@@ -214,6 +242,7 @@ func (m *Manager) Run(ctx context.Context) {
 	}()
 
 	go m.persistLoop(ctx)
+	go m.transferLoop(ctx)
 	go m.runScheduler(ctx)
 
 	var wg sync.WaitGroup
@@ -225,8 +254,8 @@ func (m *Manager) Run(ctx context.Context) {
 		}()
 	}
 
-	if resumeMonitor != nil {
-		m.restartMonitor(ctx, resumeMonitor)
+	for _, t := range resumeMonitor {
+		m.restartMonitor(ctx, t)
 	}
 	for _, t := range resumeHistory {
 		m.notify(t)
@@ -295,7 +324,15 @@ func (m *Manager) persistLoop(ctx context.Context) {
 
 // persistRunning 快照当前处于 running 状态的任务并逐个落盘（不在持有 m.mu 时执行 DB 写入）
 func (m *Manager) persistRunning() {
+	for _, t := range m.runningTasks() {
+		m.persist(t)
+	}
+}
+
+// runningTasks 返回当前处于 running 状态的任务
+func (m *Manager) runningTasks() []*task {
 	m.mu.Lock()
+	defer m.mu.Unlock()
 	running := make([]*task, 0, len(m.tasks))
 	for _, t := range m.tasks {
 		t.mu.Lock()
@@ -305,9 +342,25 @@ func (m *Manager) persistRunning() {
 			running = append(running, t)
 		}
 	}
-	m.mu.Unlock()
-	for _, t := range running {
-		m.persist(t)
+	return running
+}
+
+// transferLoop 周期性推送有在途下载的运行中任务：单个大文件下载期间没有下载记录事件，
+// 不主动推送时前端的速度与预计剩余时间会停在上一次事件
+func (m *Manager) transferLoop(ctx context.Context) {
+	ticker := time.NewTicker(transferNotifyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, t := range m.runningTasks() {
+				if tr := m.client.TaskTransfer(t.id); tr.SpeedBps > 0 || tr.PendingBytes > 0 {
+					m.notify(t)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
@@ -326,7 +379,7 @@ func (m *Manager) historyWorker(ctx context.Context) {
 	}
 }
 
-// runHistoryTask 执行单个 history 任务的完整生命周期：queued -> running -> completed/failed/canceled
+// runHistoryTask 执行 worker 池中单个任务（history/story/avatar/sticker_set/search）的完整生命周期：queued -> running -> completed/failed/canceled
 func (m *Manager) runHistoryTask(ctx context.Context, t *task) {
 	t.mu.Lock()
 	if t.status != StatusQueued {
@@ -344,24 +397,135 @@ func (m *Manager) runHistoryTask(ctx context.Context, t *task) {
 	m.persist(t)
 	m.notify(t)
 
+	var (
+		err             error
+		tracksWatermark bool
+		syncKey         string
+		syncTop         int64
+	)
+	switch t.kind {
+	case KindStory:
+		err = m.downloadChatMedia(taskCtx, t, m.client.DownloadStories)
+	case KindAvatar:
+		err = m.downloadChatMedia(taskCtx, t, m.client.DownloadAvatars)
+	case KindStickerSet:
+		err = m.downloadChatMedia(taskCtx, t, m.client.DownloadStickerSet)
+	case KindSearch:
+		err = m.downloadChatMedia(taskCtx, t, m.client.DownloadMessages)
+	default:
+		tracksWatermark, syncKey, syncTop, err = m.downloadHistory(taskCtx, t)
+	}
+
+	t.mu.Lock()
+	t.cancel = nil
+	t.phase = ""
+	t.scannedMessages = 0
+	t.foundMedia = 0
+	t.resumed = false
+	canceled := taskCtx.Err() != nil
+	retryScheduled := false
+	if err == nil {
+		t.status = StatusCompleted
+		t.scanCursor = 0 // 完整扫完，清游标与分段状态；后续手动重试从头重扫（去重使重扫廉价）
+		t.segments = nil
+	} else if !canceled && m.autoRetry > 0 && t.attempts < m.autoRetry {
+		// 自动重试：同一任务 id 续命（保留游标/统计），退避后重新入队
+		t.attempts++
+		t.status = StatusQueued
+		t.errMsg = err.Error()
+		t.resumed = true // 重跑前补下本轮中断的行
+		retryScheduled = true
+	} else {
+		finishedAt := time.Now()
+		t.finishedAt = &finishedAt
+		if canceled {
+			t.status = StatusCanceled
+		} else {
+			// final-failure point：自动重试耗尽的最终失败（M4 完成通知在此触发）
+			t.status = StatusFailed
+			t.errMsg = err.Error()
+		}
+	}
+	attempt := t.attempts
+	t.mu.Unlock()
+	cancel()
+
+	if err == nil && tracksWatermark && syncTop > 0 {
+		if wmErr := m.store.AdvanceChatWatermark(context.Background(), t.chatID, syncKey, syncTop); wmErr != nil {
+			m.logger.Warn("更新聊天 %d 同步水位失败: %v", t.chatID, wmErr)
+		}
+	}
+	m.persist(t)
+	m.notify(t)
+	if retryScheduled {
+		m.scheduleRetry(t, attempt, err)
+		return // 任务未终结，不 markDone
+	}
+	if !canceled {
+		m.fireTerminal(t) // completed 或最终 failed
+	}
+	t.markDone()
+}
+
+// downloadHistory 执行 history 任务的计数与扫描下载阶段；
+// 返回任务是否跟踪聊天同步水位、水位键（过滤条件的规范形式）及本次同步上界，由调用方在成功后推进水位
+func (m *Manager) downloadHistory(
+	ctx context.Context, t *task,
+) (tracksWatermark bool, syncKey string, syncTop int64, err error) {
 	t.mu.Lock()
 	isSingleMessage := t.messageID != 0
-	mediaTypes := t.filters.MediaTypes
+	isRange := t.rangeStart != 0 || t.rangeEnd != 0
+	tracksWatermark = !isSingleMessage && !isRange && t.filters.TopicID == 0 && t.searchQuery == ""
+	filters := t.filters
+	searchQuery := t.searchQuery
+	incremental := t.incremental
+	comments := t.comments
+	syncKey = t.filters.SyncKey()
 	t.mu.Unlock()
 
+	// 增量任务只扫描聊天同步水位之后的消息；首次同步（无水位）退化为全量扫描。
+	// 水位按整个聊天记录：消息区间与单话题任务只覆盖聊天的一部分，不参与水位；
+	// 水位按过滤条件分别记录，带过滤器的定时同步推进自己的水位，不会令无过滤同步跳过被它滤掉的媒体
+	var watermark int64
+	if incremental && tracksWatermark {
+		var wmErr error
+		if watermark, wmErr = m.store.GetChatWatermark(ctx, t.chatID, syncKey); wmErr != nil {
+			m.logger.Warn("查询聊天 %d 同步水位失败，回退为全量扫描: %v", t.chatID, wmErr)
+		}
+	}
+	if tracksWatermark {
+		m.captureSyncTop(ctx, t)
+	}
+
 	// 计数阶段：下载开始前先统计媒体总数并落库+推送，前端立即可见"共约 N 个"；
 	// 单消息任务无需统计，总数恒为 1
 	t.mu.Lock()
 	t.phase = phaseCounting
 	t.mu.Unlock()
 	m.notify(t)
-	if isSingleMessage {
+	if comments {
+		// 评论区媒体分散在讨论组的各帖子线程中，无法预先统计，保持总数未知
+		m.logger.Info("聊天 %d 历史下载：包含帖子评论区的媒体", t.chatID)
+	} else if isSingleMessage {
 		t.mu.Lock()
 		t.expectedTotal = 1
 		t.mu.Unlock()
 		m.persist(t)
-	} else if total, cntErr := m.client.CountHistoryMedia(taskCtx, t.chatID, mediaTypes); cntErr != nil {
-		if taskCtx.Err() == nil {
+	} else if searchQuery != "" || len(filters.SenderIDs) > 0 {
+		// 服务端计数不支持关键词与发送者，这类任务保持总数未知
+		m.logger.Info("聊天 %d 按关键词「%s」/发送者 %v 下载", t.chatID, searchQuery, filters.SenderIDs)
+	} else if filters.HasPopularity() {
+		// 热度阈值通常只放行少数消息，整聊天计数会严重高估，保持总数未知
+		m.logger.Info("聊天 %d 按热度阈值下载：浏览 ≥%d、转发 ≥%d、回应 ≥%d",
+			t.chatID, filters.MinViews, filters.MinForwards, filters.MinReactions)
+	} else if isRange {
+		// 服务端计数只能按整聊天统计，区间任务保持总数未知
+		m.logger.Info("聊天 %d 区间下载：消息 %d ~ %d", t.chatID, t.rangeStart, t.rangeEnd)
+	} else if watermark > 0 {
+		// 增量任务的待扫范围远小于整聊天，全量计数无意义，保持总数未知
+		m.logger.Info("聊天 %d 增量同步：扫描消息 %d 之后的新消息", t.chatID, watermark)
+	} else if total, cntErr := m.client.CountHistoryMedia(ctx, t.chatID, filters); cntErr != nil {
+		if ctx.Err() == nil {
 			m.logger.Warn("统计任务 %s 媒体总数失败，回退为未知总数: %v", t.id, cntErr)
 		}
 	} else {
@@ -374,19 +538,30 @@ func (m *Manager) runHistoryTask(ctx context.Context, t *task) {
 	t.mu.Lock()
 	t.phase = phaseDownloading
 	spec := &downloader.HistorySpec{
-		ChatID:        t.chatID,
-		TaskID:        t.id,
-		FromMessageID: t.scanCursor,
-		MessageID:     t.messageID,
-		Filters:       t.filters,
+		ChatID:          t.chatID,
+		TaskID:          t.id,
+		FromMessageID:   t.scanCursor,
+		MessageID:       t.messageID,
+		RangeStart:      t.rangeStart,
+		RangeEnd:        t.rangeEnd,
+		Filters:         t.filters,
+		Incremental:     t.incremental,
+		OldestFirst:     t.oldestFirst,
+		Comments:        t.comments,
+		SearchQuery:     t.searchQuery,
+		StopAtMessageID: watermark,
+		ScanSegments:    t.scanSegments,
+		SegmentByDate:   t.segmentByDate,
+		Segments:        slices.Clone(t.segments),
 	}
 	resumed := t.resumed
+	syncTop = t.lastMessageID
 	t.mu.Unlock()
 	m.notify(t)
 
 	// 恢复的任务先补下被进程重启清扫的中断行：这些消息比游标更新，仅靠游标续扫会永久漏掉
 	if resumed {
-		if ids, listErr := m.store.ListInterruptedByTask(taskCtx, t.id); listErr != nil {
+		if ids, listErr := m.store.ListInterruptedByTask(ctx, t.id, t.chatID); listErr != nil {
 			m.logger.Warn("查询任务 %s 中断行失败: %v", t.id, listErr)
 		} else if len(ids) > 0 {
 			m.logger.Info("任务 %s 恢复：补下 %d 个中断的媒体", t.id, len(ids))
@@ -394,51 +569,44 @@ func (m *Manager) runHistoryTask(ctx context.Context, t *task) {
 		}
 	}
 
-	err := m.client.DownloadHistoryMedia(taskCtx, spec)
+	return tracksWatermark, syncKey, syncTop, m.client.DownloadHistoryMedia(ctx, spec)
+}
 
+// downloadChatMedia 执行 story/avatar/sticker_set/search 任务：由 download 列出快拍、头像、贴纸或所选消息并下载。
+// 这类媒体数量有限且按文件与 unique_id 去重，恢复/重试直接整体重跑，无需游标
+func (m *Manager) downloadChatMedia(
+	ctx context.Context, t *task, download func(context.Context, *downloader.HistorySpec) error,
+) error {
 	t.mu.Lock()
-	t.cancel = nil
-	t.phase = ""
-	t.scannedMessages = 0
-	t.foundMedia = 0
-	t.resumed = false
-	canceled := taskCtx.Err() != nil
-	retryScheduled := false
-	if err == nil {
-		t.status = StatusCompleted
-		t.scanCursor = 0 // 完整扫完，清游标；后续手动重试从头重扫（去重使重扫廉价）
-	} else if !canceled && m.autoRetry > 0 && t.attempts < m.autoRetry {
-		// 自动重试：同一任务 id 续命（保留游标/统计），退避后重新入队
-		t.attempts++
-		t.status = StatusQueued
-		t.errMsg = err.Error()
-		t.resumed = true // 重跑前补下本轮中断的行
-		retryScheduled = true
-	} else {
-		finishedAt := time.Now()
-		t.finishedAt = &finishedAt
-		if canceled {
-			t.status = StatusCanceled
-		} else {
-			// final-failure point：自动重试耗尽的最终失败（M4 完成通知在此触发）
-			t.status = StatusFailed
-			t.errMsg = err.Error()
-		}
+	t.phase = phaseDownloading
+	spec := &downloader.HistorySpec{
+		ChatID: t.chatID, TaskID: t.id, Filters: t.filters, Members: t.members, Messages: slices.Clone(t.messages),
 	}
-	attempt := t.attempts
 	t.mu.Unlock()
-	cancel()
-
-	m.persist(t)
 	m.notify(t)
-	if retryScheduled {
-		m.scheduleRetry(t, attempt, err)
-		return // 任务未终结，不 markDone
+	return download(ctx, spec)
+}
+
+// captureSyncTop 为整聊天 history 任务记下本次同步上界（开扫时聊天最新消息 id）并落库；
+// 恢复/重试的任务沿用已持久化的上界，否则续扫期间新增的消息会被误计入水位之下
+func (m *Manager) captureSyncTop(ctx context.Context, t *task) {
+	t.mu.Lock()
+	known := t.lastMessageID != 0
+	t.mu.Unlock()
+	if known {
+		return
 	}
-	if !canceled {
-		m.fireTerminal(t) // completed 或最终 failed
+	top, err := m.client.LatestMessageID(ctx, t.chatID)
+	if err != nil {
+		if ctx.Err() == nil {
+			m.logger.Warn("获取聊天 %d 最新消息失败，本次不更新同步水位: %v", t.chatID, err)
+		}
+		return
 	}
-	t.markDone()
+	t.mu.Lock()
+	t.lastMessageID = top
+	t.mu.Unlock()
+	m.persist(t)
 }
 
 // scheduleRetry 在指数退避后把任务重新投入 history 队列；触发时若任务已被取消或管理器已关停则放弃
@@ -465,22 +633,34 @@ func (m *Manager) scheduleRetry(t *task, attempt int, cause error) {
 	})
 }
 
-// Enqueue 创建并提交一个新任务。history 任务进入有界 worker 池排队；
-// monitor 任务立即以独立 goroutine 长期运行（不占用 history 配额），ChatID 为 0 表示停止监控。
-// spec 携带 ChatID 以及 history 任务的过滤器/单消息参数（monitor 忽略后两者）。
+// Enqueue 创建并提交一个新任务。monitor 以外的任务进入有界 worker 池排队；
+// monitor 任务立即以独立 goroutine 长期运行（不占用 history 配额），可与其它聊天的 monitor 并存，
+// ChatID 为 0 表示停止全部监控（停止单个监控请对其任务调用 Cancel）。
+// spec 携带 ChatID、过滤器以及 history 任务的单消息参数（monitor 忽略后者）。
 func (m *Manager) Enqueue(kind Kind, spec *downloader.HistorySpec, chatTitle string) (TaskDTO, error) {
 	switch kind {
 	case KindHistory:
 		return m.enqueueHistory(spec, chatTitle)
+	case KindStory, KindAvatar, KindStickerSet:
+		return m.enqueueChatMedia(kind, spec, chatTitle)
+	case KindSearch:
+		if len(spec.Messages) == 0 {
+			return TaskDTO{}, fmt.Errorf("搜索任务未选择任何消息")
+		}
+		return m.enqueueChatMedia(kind, spec, chatTitle)
 	case KindMonitor:
-		return m.enqueueMonitor(spec.ChatID, chatTitle)
+		if spec.ChatID == 0 {
+			return m.stopAllMonitors(), nil
+		}
+		return m.enqueueMonitor(spec, chatTitle)
 	default:
 		return TaskDTO{}, fmt.Errorf("未知任务类型: %s", kind)
 	}
 }
 
 // enqueueHistory 创建 history 任务、持久化后投递给 worker 池；
-// 排队中/运行中的重复任务拒绝创建（整聊天任务按 chatID 去重，单消息任务按 (chatID, messageID) 去重）
+// 排队中/运行中的重复任务拒绝创建（单消息任务按 (chatID, messageID) 去重，
+// 整聊天与消息区间任务按扫描区间是否重叠去重，整聊天视为两端不限的区间；关键词不同的聊天内搜索任务互不冲突）
 func (m *Manager) enqueueHistory(spec *downloader.HistorySpec, chatTitle string) (TaskDTO, error) {
 	m.mu.Lock()
 	for _, existing := range m.tasks {
@@ -490,12 +670,25 @@ func (m *Manager) enqueueHistory(spec *downloader.HistorySpec, chatTitle string)
 		existing.mu.Lock()
 		status := existing.status
 		existingMsgID := existing.messageID
+		lo, hi := existing.rangeStart, existing.rangeEnd
+		topicID := existing.filters.TopicID
+		query := existing.searchQuery
 		existing.mu.Unlock()
 		if status != StatusQueued && status != StatusRunning {
 			continue
 		}
-		if existingMsgID != spec.MessageID {
-			continue // 单消息任务与整聊天任务互不冲突，不同消息的单消息任务亦然
+		if !topicsOverlap(topicID, spec.Filters.TopicID) {
+			continue // 同一论坛的不同话题互不冲突
+		}
+		if query != "" && spec.SearchQuery != "" && query != spec.SearchQuery {
+			continue // 不同关键词的搜索任务各自只取匹配的消息
+		}
+		if existingMsgID != 0 || spec.MessageID != 0 {
+			if existingMsgID != spec.MessageID {
+				continue // 单消息任务只与同一消息的单消息任务冲突
+			}
+		} else if !rangesOverlap(lo, hi, spec.RangeStart, spec.RangeEnd) {
+			continue // 互不重叠的消息区间可并行下载
 		}
 		m.mu.Unlock()
 		return TaskDTO{}, fmt.Errorf("该会话已有下载任务在队列中")
@@ -515,30 +708,103 @@ func (m *Manager) enqueueHistory(spec *downloader.HistorySpec, chatTitle string)
 	return t.ToDTO(), nil
 }
 
-// enqueueMonitor 取消当前 monitor 任务（若有）并等待其退出，再视 chatID 决定是否启动新任务；
-// chatID == 0 表示仅停止监控：返回被取消任务的快照（无任务时返回零值 TaskDTO），不创建新任务。
-func (m *Manager) enqueueMonitor(chatID int64, chatTitle string) (TaskDTO, error) {
-	m.monitorMu.Lock()
-	defer m.monitorMu.Unlock()
-
+// enqueueChatMedia 创建 story/avatar/sticker_set/search 任务并投递给 history worker 池；
+// 同一聊天（贴纸包任务为同一贴纸包）已有排队中/运行中的同类任务时拒绝创建，search 任务不去重
+func (m *Manager) enqueueChatMedia(kind Kind, spec *downloader.HistorySpec, chatTitle string) (TaskDTO, error) {
 	m.mu.Lock()
-	prev := m.monitorTask
-	runCtx := m.runCtx
+	for _, existing := range m.tasks {
+		// search 任务不绑定单个聊天，各次搜索的选择互不冲突（重复消息由下载历史跳过）
+		if kind == KindSearch || existing.kind != kind || existing.chatID != spec.ChatID {
+			continue
+		}
+		existing.mu.Lock()
+		status := existing.status
+		existing.mu.Unlock()
+		if status == StatusQueued || status == StatusRunning {
+			m.mu.Unlock()
+			return TaskDTO{}, fmt.Errorf("该会话已有同类下载任务在队列中")
+		}
+	}
+
+	t := newTask(kind, &downloader.HistorySpec{
+		ChatID: spec.ChatID, Filters: spec.Filters, Members: spec.Members, Messages: spec.Messages,
+	}, chatTitle)
+	if err := m.createTaskRow(t); err != nil {
+		m.mu.Unlock()
+		return TaskDTO{}, err
+	}
+	m.tasks[t.id] = t
+	m.order = append(m.order, t)
 	m.mu.Unlock()
 
-	if prev != nil {
-		_ = m.cancelTask(prev)
-		<-prev.done
+	m.notify(t)
+	m.historyCh <- t
+	return t.ToDTO(), nil
+}
+
+// topicsOverlap 报告两个任务的话题限定是否可能覆盖同一消息：0（全部话题）与任何话题重叠
+func topicsOverlap(a, b int64) bool {
+	return a == 0 || b == 0 || a == b
+}
+
+// rangesOverlap 报告两个消息 id 闭区间是否重叠；端点为 0 表示该端不限
+func rangesOverlap(aLo, aHi, bLo, bHi int64) bool {
+	return (aHi == 0 || bLo <= aHi) && (bHi == 0 || aLo <= bHi)
+}
+
+// runningMonitors 返回当前运行中的全部 monitor 任务（按创建顺序）
+func (m *Manager) runningMonitors() []*task {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*task
+	for _, t := range m.order {
+		if t.kind != KindMonitor {
+			continue
+		}
+		t.mu.Lock()
+		running := t.status == StatusRunning
+		t.mu.Unlock()
+		if running {
+			out = append(out, t)
+		}
 	}
+	return out
+}
 
-	if chatID == 0 {
-		if prev != nil {
-			return prev.ToDTO(), nil
+// stopAllMonitors 取消全部运行中的 monitor 任务并等待其退出；
+// 返回最后一个被停止任务的快照（无运行中的监控时返回零值 TaskDTO）
+func (m *Manager) stopAllMonitors() TaskDTO {
+	m.monitorMu.Lock()
+	defer m.monitorMu.Unlock()
+
+	var last *task
+	for _, t := range m.runningMonitors() {
+		_ = m.cancelTask(t)
+		<-t.done
+		last = t
+	}
+	if last == nil {
+		return TaskDTO{}
+	}
+	return last.ToDTO()
+}
+
+// enqueueMonitor 为 spec.ChatID 启动一个新的 monitor 任务（携带 spec.Filters），不影响其它聊天的监控；
+// 该聊天已有话题与之重叠的运行中 monitor 任务时拒绝创建。
+func (m *Manager) enqueueMonitor(spec *downloader.HistorySpec, chatTitle string) (TaskDTO, error) {
+	m.monitorMu.Lock()
+	defer m.monitorMu.Unlock()
+
+	for _, existing := range m.runningMonitors() {
+		existing.mu.Lock()
+		topicID := existing.filters.TopicID
+		existing.mu.Unlock()
+		if existing.chatID == spec.ChatID && topicsOverlap(topicID, spec.Filters.TopicID) {
+			return TaskDTO{}, fmt.Errorf("该会话已有监控任务在运行")
 		}
-		return TaskDTO{}, nil
 	}
 
-	t := newTask(KindMonitor, &downloader.HistorySpec{ChatID: chatID}, chatTitle)
+	t := newTask(KindMonitor, &downloader.HistorySpec{ChatID: spec.ChatID, Filters: spec.Filters}, chatTitle)
 	t.mu.Lock()
 	t.status = StatusRunning
 	now := time.Now()
@@ -549,6 +815,9 @@ func (m *Manager) enqueueMonitor(chatID int64, chatTitle string) (TaskDTO, error
 		return TaskDTO{}, err
 	}
 
+	m.mu.Lock()
+	runCtx := m.runCtx
+	m.mu.Unlock()
 	if runCtx == nil {
 		runCtx = context.Background()
 	}
@@ -560,59 +829,240 @@ func (m *Manager) enqueueMonitor(chatID int64, chatTitle string) (TaskDTO, error
 	m.mu.Lock()
 	m.tasks[t.id] = t
 	m.order = append(m.order, t)
-	m.monitorTask = t
 	m.mu.Unlock()
 
 	// 同步建立 client 端关联，确保调用方一旦观察到任务状态为 running，
-	// SetMonitorTask 必然已经生效，不会有 goroutine 异步设置带来的可见性竞争。
-	m.client.SetMonitorTask(t.id, t.chatID)
+	// AddMonitorTask 必然已经生效，不会有 goroutine 异步设置带来的可见性竞争。
+	// 新任务尚无水位，首次补扫仅记下聊天当前最新消息 id；须先于关联请求，使其间的实时投递不推进水位
+	t.requestCatchUp()
+	m.client.AddMonitorTask(t.id, t.chatID, spec.Filters)
 	m.notify(t)
 
 	go m.runMonitorTask(taskCtx, t)
 	return t.ToDTO(), nil
 }
 
-// restartMonitor 重启进程重启前仍在运行的 monitor 任务：沿用同一任务 id 重建 ctx 与 client 端关联
+// restartMonitor 重启进程重启前仍在运行的 monitor 任务：沿用同一任务 id 重建 ctx 与 client 端关联；
+// 被启动清扫标记为中断的下载行并入待下载队列，连同残留的待下载登记与水位之后的离线消息一起由 worker 补下
 func (m *Manager) restartMonitor(runCtx context.Context, t *task) {
 	m.monitorMu.Lock()
 	defer m.monitorMu.Unlock()
 
+	if ids, err := m.store.ListInterruptedByTask(runCtx, t.id, t.chatID); err != nil {
+		m.logger.Warn("查询任务 %s 中断行失败: %v", t.id, err)
+	} else {
+		for _, id := range ids {
+			if err := m.store.AddMonitorPending(runCtx, t.id, t.chatID, id); err != nil {
+				m.logger.Warn("登记监控待下载媒体失败: %v", err)
+			}
+		}
+	}
+
 	taskCtx, cancel := context.WithCancel(runCtx)
 	t.mu.Lock()
 	t.cancel = cancel
+	filters := t.filters
 	t.mu.Unlock()
 
-	m.mu.Lock()
-	m.monitorTask = t
-	m.mu.Unlock()
-
-	m.client.SetMonitorTask(t.id, t.chatID)
+	// 先补扫停机期间错过的消息，再进入实时消费；补扫下界须在建立关联前取定，
+	// 否则 worker 首轮之前到达的实时消息会把水位推过缺口
+	t.requestCatchUp()
+	m.client.AddMonitorTask(t.id, t.chatID, filters)
 	m.logger.Info("已恢复监控任务 %s（聊天 %d）", t.id, t.chatID)
 	m.notify(t)
 	go m.runMonitorTask(taskCtx, t)
 }
 
-// runMonitorTask 阻塞至 ctx 取消，结束时清理 client 端关联并转为 canceled
+// runMonitorTask 运行 monitor 任务的下载 worker 直至 ctx 取消，结束时注销 client 端关联。
+// 用户停止：转为 canceled 并清空待下载登记；进程关停：保持 running 与待下载登记，重启后恢复补下。
+// 注销与状态切换在同一把 t.mu 下完成，UpdateFilters 据此不会在注销后重新登记
 func (m *Manager) runMonitorTask(ctx context.Context, t *task) {
 	defer t.markDone()
-	<-ctx.Done()
-	m.client.SetMonitorTask("", 0)
+	m.runMonitorQueue(ctx, t)
+
+	m.mu.Lock()
+	shuttingDown := m.runCtx != nil && m.runCtx.Err() != nil
+	m.mu.Unlock()
 
 	t.mu.Lock()
+	m.client.RemoveMonitorTask(t.id)
 	t.cancel = nil
-	t.status = StatusCanceled
-	now := time.Now()
-	t.finishedAt = &now
+	if !shuttingDown {
+		t.status = StatusCanceled
+		now := time.Now()
+		t.finishedAt = &now
+	}
 	t.mu.Unlock()
 
-	m.mu.Lock()
-	if m.monitorTask == t {
-		m.monitorTask = nil
+	if !shuttingDown {
+		if err := m.store.ClearMonitorPending(context.Background(), t.id); err != nil {
+			m.logger.Warn("清空监控任务 %s 待下载媒体失败: %v", t.id, err)
+		}
 	}
+	m.persist(t)
+	m.notify(t)
+}
+
+// handleMonitorMedia 是 client 的监控新媒体投递回调。它运行在 TDLib 更新 goroutine 上，须快速返回，
+// 因此登记落库异步完成：写入持久化待下载队列后唤醒该任务的下载 worker
+func (m *Manager) handleMonitorMedia(taskID string, chatID, messageID int64) {
+	m.mu.Lock()
+	t := m.tasks[taskID]
 	m.mu.Unlock()
+	if t == nil {
+		return
+	}
+	go func() {
+		t.mu.Lock()
+		running := t.status == StatusRunning
+		t.mu.Unlock()
+		if !running {
+			return
+		}
+		if err := m.store.AddMonitorPending(context.Background(), taskID, chatID, messageID); err != nil {
+			m.logger.Warn("登记监控待下载媒体失败: %v", err)
+			return
+		}
+		t.advanceWatermark(messageID)
+		t.wakeMonitor()
+	}()
+}
 
+// handleConnectionReady 是 client 的连接就绪回调：断线重连后令全部运行中的 monitor 补扫断线期间的消息
+func (m *Manager) handleConnectionReady() {
+	for _, t := range m.runningMonitors() {
+		t.requestCatchUp()
+	}
+}
+
+// catchUpMonitor 补扫 monitor 缺口下界之后（停机/断线期间）的消息：命中过滤器的媒体并入持久化待下载队列，
+// 水位推进到聊天当前最新消息。失败时（如 TDLib 尚未连接）在 monitorCatchUpRetry 后以同一下界重新请求补扫
+func (m *Manager) catchUpMonitor(ctx context.Context, t *task) {
+	t.mu.Lock()
+	after := t.gapFloor
+	t.mu.Unlock()
+
+	newest, ids, err := m.client.ScanMonitorGap(ctx, t.id, t.chatID, after)
+	if err != nil {
+		if ctx.Err() == nil {
+			m.logger.Warn("监控任务 %s 补扫离线消息失败，%s 后重试: %v", t.id, monitorCatchUpRetry, err)
+			time.AfterFunc(monitorCatchUpRetry, t.requestCatchUp)
+		}
+		return
+	}
+	for _, id := range ids {
+		if err := m.store.AddMonitorPending(ctx, t.id, t.chatID, id); err != nil {
+			if ctx.Err() == nil {
+				m.logger.Warn("登记监控待下载媒体失败，%s 后重新补扫: %v", monitorCatchUpRetry, err)
+				time.AfterFunc(monitorCatchUpRetry, t.requestCatchUp)
+			}
+			return // 缺口保持打开，重试沿用同一下界
+		}
+	}
+	if len(ids) > 0 {
+		m.logger.Info("监控任务 %s 补扫到 %d 个离线期间的媒体", t.id, len(ids))
+	}
+	t.closeGap(newest)
 	m.persist(t)
+}
+
+// runMonitorQueue 是 monitor 任务的下载 worker：每轮先执行待处理的离线补扫，再按消息先后消费持久化待下载队列，
+// 至多 monitorDownloadConcurrency 个下载在途；ctx 取消后等待在途下载退出再返回
+func (m *Manager) runMonitorQueue(ctx context.Context, t *task) {
+	sem := make(chan struct{}, monitorDownloadConcurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	var inflightMu sync.Mutex
+	inflight := make(map[int64]bool)
+	for {
+		if t.catchUpPending.Swap(false) {
+			m.catchUpMonitor(ctx, t)
+		}
+		// 查询与占用在同一把锁下完成：否则下载刚结束（登记已移除、在途标记尚未清除前）读到的旧快照
+		// 会在标记清除后被再次派发，重复下载同一消息
+		inflightMu.Lock()
+		ids, err := m.store.ListMonitorPending(ctx, t.id)
+		if err != nil && ctx.Err() == nil {
+			m.logger.Warn("查询监控任务 %s 待下载媒体失败: %v", t.id, err)
+		}
+		todo := ids[:0]
+		for _, msgID := range ids {
+			if !inflight[msgID] {
+				inflight[msgID] = true
+				todo = append(todo, msgID)
+			}
+		}
+		inflightMu.Unlock()
+		for _, msgID := range todo {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				m.downloadMonitorMessage(ctx, t, msgID)
+				inflightMu.Lock()
+				delete(inflight, msgID)
+				inflightMu.Unlock()
+			}()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.monitorWake:
+		}
+	}
+}
+
+// downloadMonitorMessage 下载一条待下载登记并在处理完毕后移除；ctx 取消导致的中断保留登记以便恢复补下，
+// 其它失败已由下载记录落为 failed 历史行，不在此无限重试
+func (m *Manager) downloadMonitorMessage(ctx context.Context, t *task, msgID int64) {
+	err := m.client.DownloadMonitorMessage(ctx, t.id, t.chatID, msgID)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		m.logger.Warn("监控任务 %s 下载消息 %d 失败: %v", t.id, msgID, err)
+	}
+	if err := m.store.DeleteMonitorPending(context.Background(), t.id, msgID); err != nil {
+		m.logger.Warn("移除监控待下载媒体失败: %v", err)
+	}
+}
+
+// UpdateFilters 修改运行中 monitor 任务的过滤条件：立即对后续新消息生效并落库，
+// 进程重启恢复后沿用新条件
+func (m *Manager) UpdateFilters(id string, filters downloader.HistoryFilters) (TaskDTO, error) {
+	m.mu.Lock()
+	t, ok := m.tasks[id]
+	m.mu.Unlock()
+	if !ok {
+		return TaskDTO{}, fmt.Errorf("任务不存在: %s", id)
+	}
+	if t.kind != KindMonitor {
+		return TaskDTO{}, fmt.Errorf("仅监控任务支持修改过滤条件")
+	}
+
+	t.mu.Lock()
+	if t.status != StatusRunning {
+		status := t.status
+		t.mu.Unlock()
+		return TaskDTO{}, fmt.Errorf("任务状态为 %s，无法修改过滤条件", status)
+	}
+	filters.TopicID = t.filters.TopicID // 话题限定是监控去重的依据，运行中不可修改
+	t.filters = filters
+	m.client.AddMonitorTask(t.id, t.chatID, filters)
+	filtersJSON := t.filtersJSON()
+	t.mu.Unlock()
+
+	if err := m.store.UpdateTaskFilters(context.Background(), t.id, filtersJSON); err != nil {
+		m.logger.Warn("持久化任务过滤条件失败: %v", err)
+	}
 	m.notify(t)
+	return t.ToDTO(), nil
 }
 
 // List 返回全部任务快照，按创建时间倒序（最新优先）；返回值始终为拷贝，不暴露内部指针
@@ -624,7 +1074,7 @@ func (m *Manager) List() []TaskDTO {
 
 	dtos := make([]TaskDTO, len(ts))
 	for i, t := range ts {
-		dtos[len(ts)-1-i] = t.ToDTO()
+		dtos[len(ts)-1-i] = m.snapshot(t)
 	}
 	return dtos
 }
@@ -637,7 +1087,16 @@ func (m *Manager) Get(id string) (TaskDTO, bool) {
 	if !ok {
 		return TaskDTO{}, false
 	}
-	return t.ToDTO(), true
+	return m.snapshot(t), true
+}
+
+// snapshot 返回任务快照；运行中的任务附带实时传输估算（速度、剩余字节、预计剩余时间）
+func (m *Manager) snapshot(t *task) TaskDTO {
+	dto := t.ToDTO()
+	if dto.Status == string(StatusRunning) {
+		applyTransfer(&dto, m.client.TaskTransfer(dto.ID))
+	}
+	return dto
 }
 
 // Cancel 取消一个排队中或运行中的任务：排队中的任务直接标记为 canceled；
@@ -690,7 +1149,13 @@ func (m *Manager) Retry(id string) (TaskDTO, error) {
 
 	t.mu.Lock()
 	status, kind, chatTitle := t.status, t.kind, t.chatTitle
-	spec := &downloader.HistorySpec{ChatID: t.chatID, Filters: t.filters, MessageID: t.messageID}
+	spec := &downloader.HistorySpec{
+		ChatID: t.chatID, Filters: t.filters, MessageID: t.messageID, Incremental: t.incremental,
+		RangeStart: t.rangeStart, RangeEnd: t.rangeEnd,
+		OldestFirst: t.oldestFirst, Comments: t.comments, LeaveAfter: t.leaveAfter, Members: t.members,
+		ScanSegments: t.scanSegments, SegmentByDate: t.segmentByDate, Messages: slices.Clone(t.messages),
+		SearchQuery: t.searchQuery,
+	}
 	t.mu.Unlock()
 
 	if status != StatusFailed && status != StatusCanceled {
@@ -703,18 +1168,31 @@ func (m *Manager) Retry(id string) (TaskDTO, error) {
 func (m *Manager) createTaskRow(t *task) error {
 	dto := t.ToDTO()
 	row := &store.TaskRow{
-		ID:            dto.ID,
-		Kind:          dto.Kind,
-		ChatID:        dto.ChatID,
-		ChatTitle:     dto.ChatTitle,
-		Status:        dto.Status,
-		CreatedAt:     dto.CreatedAt,
-		StartedAt:     dto.StartedAt,
-		ExpectedTotal: dto.ExpectedTotal,
-		ScanCursor:    dto.ScanCursor,
-		Attempts:      dto.Attempts,
-		Filters:       t.filtersJSON(),
-		MessageID:     dto.MessageID,
+		ID:             dto.ID,
+		Kind:           dto.Kind,
+		ChatID:         dto.ChatID,
+		ChatTitle:      dto.ChatTitle,
+		Status:         dto.Status,
+		CreatedAt:      dto.CreatedAt,
+		StartedAt:      dto.StartedAt,
+		ExpectedTotal:  dto.ExpectedTotal,
+		ScanCursor:     dto.ScanCursor,
+		Attempts:       dto.Attempts,
+		Filters:        t.filtersJSON(),
+		MessageID:      dto.MessageID,
+		RangeStart:     dto.RangeStart,
+		RangeEnd:       dto.RangeEnd,
+		LastMessageID:  dto.LastMessageID,
+		Incremental:    dto.Incremental,
+		OldestFirst:    dto.OldestFirst,
+		Comments:       dto.Comments,
+		LeaveAfter:     dto.LeaveAfter,
+		Members:        dto.Members,
+		ScanSegments:   dto.ScanSegments,
+		SegmentByDate:  dto.SegmentByDate,
+		SegmentState:   segmentsJSON(dto.Segments),
+		SearchMessages: messagesJSON(dto.Messages),
+		SearchQuery:    dto.SearchQuery,
 	}
 	if err := m.store.CreateTask(context.Background(), row); err != nil {
 		return fmt.Errorf("创建任务记录失败: %w", err)
@@ -734,6 +1212,7 @@ func (m *Manager) persist(t *task) {
 		Failed: dto.Stats.Failed, Skipped: dto.Stats.Skipped,
 		TotalSize: dto.Stats.TotalSize, DownloadedSize: dto.Stats.DownloadedSize,
 		ExpectedTotal: dto.ExpectedTotal, ScanCursor: dto.ScanCursor, Attempts: dto.Attempts,
+		LastMessageID: dto.LastMessageID, SegmentState: segmentsJSON(dto.Segments),
 	}); err != nil {
 		m.logger.Warn("持久化任务进度失败: %v", err)
 	}
@@ -745,7 +1224,7 @@ func (m *Manager) notify(t *task) {
 	fn := m.onChange
 	m.mu.Unlock()
 	if fn != nil {
-		dto := t.ToDTO()
+		dto := m.snapshot(t)
 		fn(&dto)
 	}
 }
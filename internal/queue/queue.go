@@ -1,5 +1,6 @@
 // Package queue 实现位于 internal/telegram、internal/downloader、internal/store 之上的任务队列管理器：
-// history 任务经有界 worker 池调度（受 maxConcurrentTasks 限制），monitor 任务长期运行、不占用该配额。
+// history 任务经有界 worker 池调度（受 maxConcurrentTasks 限制），monitor 任务长期运行、不占用该配额，
+// 可同时监控任意多个聊天（同一聊天可按互不重叠的论坛话题各开一个）。
 package queue
 
 import (
@@ -18,8 +19,26 @@ const (
 	KindHistory Kind = "history"
 	// KindMonitor 实时监控任务，长生命周期，独立运行不占用并发配额
 	KindMonitor Kind = "monitor"
+	// KindStory 快拍下载任务：下载聊天可见的活跃/主页/归档快拍，与 history 共用 worker 池
+	KindStory Kind = "story"
+	// KindAvatar 头像下载任务：下载聊天的头像历史（可选含成员个人头像），与 history 共用 worker 池
+	KindAvatar Kind = "avatar"
+	// KindStickerSet 贴纸包下载任务（t.me/addstickers 链接）：ChatID 为贴纸包 id，与 history 共用 worker 池
+	KindStickerSet Kind = "sticker_set"
+	// KindSearch 跨聊天搜索下载任务：下载全局搜索结果中所选的消息，ChatID 为 0
+	KindSearch Kind = "search"
 )
 
+// pooled 报告该类型任务是否在 history worker 池中排队执行（monitor 独立长期运行）
+func (k Kind) pooled() bool {
+	switch k {
+	case KindHistory, KindStory, KindAvatar, KindStickerSet, KindSearch:
+		return true
+	default:
+		return false
+	}
+}
+
 // Status 任务状态
 type Status string
 
@@ -41,12 +60,24 @@ const (
 // ChatDownloader 是 Manager 依赖的最小接口（而非直接依赖 *telegram.Client），
 // 使本包可在无真实 TDLib 连接的情况下进行单元测试。
 type ChatDownloader interface {
-	CountHistoryMedia(ctx context.Context, chatID int64, mediaTypes []string) (int64, error)
+	CountHistoryMedia(ctx context.Context, chatID int64, filters downloader.HistoryFilters) (int64, error)
 	DownloadHistoryMedia(ctx context.Context, spec *downloader.HistorySpec) error
-	SetMonitorTask(taskID string, chatID int64)
+	DownloadStories(ctx context.Context, spec *downloader.HistorySpec) error
+	DownloadAvatars(ctx context.Context, spec *downloader.HistorySpec) error
+	DownloadStickerSet(ctx context.Context, spec *downloader.HistorySpec) error
+	DownloadMessages(ctx context.Context, spec *downloader.HistorySpec) error
+	AddMonitorTask(taskID string, chatID int64, filters downloader.HistoryFilters)
+	RemoveMonitorTask(taskID string)
+	DownloadMonitorMessage(ctx context.Context, taskID string, chatID, messageID int64) error
+	SetMonitorMediaFunc(fn func(taskID string, chatID, messageID int64))
+	LatestMessageID(ctx context.Context, chatID int64) (int64, error)
+	ScanMonitorGap(ctx context.Context, taskID string, chatID, afterMessageID int64) (newest int64, messageIDs []int64, err error)
+	SetConnectionReadyFunc(fn func())
 	SetRecordFunc(fn func(context.Context, downloader.RecordEvent))
 	SetScanProgressFunc(fn func(taskID string, scannedMessages, foundMedia, scanCursor int64))
+	SetSegmentProgressFunc(fn func(taskID string, segments []downloader.ScanSegment))
 	SetDuplicateLookupFunc(fn func(ctx context.Context, uniqueID string) (existingPath string, ok bool))
+	TaskTransfer(taskID string) downloader.TaskTransfer
 }
 
 // TaskDTO 是任务状态对外暴露的值拷贝快照，用于 List/Get/onChange，不持有内部指针
@@ -65,6 +96,12 @@ type TaskDTO struct {
 	Phase string `json:"phase,omitempty"`
 	// ExpectedTotal 是下载前统计出的媒体总数（近似值），0 表示未知
 	ExpectedTotal int64 `json:"expected_total,omitempty"`
+	// SpeedBps 是该任务媒体的实时下载速度（字节/秒）；BytesRemaining 是估算的剩余下载字节数：
+	// 在途媒体的未下载部分，加上统计总数中尚未处理的媒体按已处理媒体平均大小的估计（总数未知时不含此项）；
+	// ETASeconds 由两者得出。均仅运行中有值，不落库
+	SpeedBps       int64 `json:"speed_bps,omitempty"`
+	BytesRemaining int64 `json:"bytes_remaining,omitempty"`
+	ETASeconds     int64 `json:"eta_seconds,omitempty"`
 	// ScannedMessages/FoundMedia 是 history 任务扫描历史的实时进度（仅运行中有值，不落库）
 	ScannedMessages int64 `json:"scanned_messages,omitempty"`
 	FoundMedia      int64 `json:"found_media,omitempty"`
@@ -74,6 +111,33 @@ type TaskDTO struct {
 	Attempts int `json:"attempts,omitempty"`
 	// Filters 是任务级过滤条件（nil = 不过滤）
 	Filters *downloader.HistoryFilters `json:"filters,omitempty"`
+	// FilterExpr 是 Filters 的过滤表达式文本，供界面回显与编辑
+	FilterExpr string `json:"filter_expr,omitempty"`
 	// MessageID 非 0 时为单消息下载任务（t.me 消息链接）
 	MessageID int64 `json:"message_id,omitempty"`
+	// RangeStart/RangeEnd 非 0 时为消息区间任务（闭区间，任一端为 0 表示不限）
+	RangeStart int64 `json:"range_start,omitempty"`
+	RangeEnd   int64 `json:"range_end,omitempty"`
+	// LastMessageID 对 monitor 是已见到的最新消息 id，重启/重连后据此补扫离线期间的消息；
+	// 对整聊天 history 任务是开扫时聊天的最新消息 id，任务完成后记为该聊天的同步水位
+	LastMessageID int64 `json:"last_message_id,omitempty"`
+	// Incremental 为增量模式：只扫描聊天同步水位之后的新消息
+	Incremental bool `json:"incremental,omitempty"`
+	// OldestFirst 为正序模式：由旧到新下载，ScanCursor 为已扫描到的最新消息 id
+	OldestFirst bool `json:"oldest_first,omitempty"`
+	// Comments 为频道评论模式：同时下载各帖子评论区的媒体（按帖子归档）
+	Comments bool `json:"comments,omitempty"`
+	// LeaveAfter 为任务完成后退出该聊天（经邀请链接加入的聊天）
+	LeaveAfter bool `json:"leave_after,omitempty"`
+	// Members 为 avatar 任务同时下载成员的个人头像
+	Members bool `json:"members,omitempty"`
+	// SearchQuery 为 history 聊天内搜索：只下载匹配该关键词/话题标签的消息
+	SearchQuery string `json:"search_query,omitempty"`
+	// Messages 是 search 任务所选的消息（各自的真实 chat_id）
+	Messages []downloader.MessageRef `json:"messages,omitempty"`
+	// ScanSegments 是分段并行扫描的段数（0 = 沿用配置），SegmentByDate 为按日期区间分段；
+	// Segments 是各段区间与续扫游标，仅分段扫描开始后有值
+	ScanSegments  int                      `json:"scan_segments,omitempty"`
+	SegmentByDate bool                     `json:"segment_by_date,omitempty"`
+	Segments      []downloader.ScanSegment `json:"segments,omitempty"`
 }
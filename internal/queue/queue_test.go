@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -28,18 +30,36 @@ type fakeClient struct {
 	scanProgressFn func(taskID string, scannedMessages, foundMedia, scanCursor int64)
 	dupLookupFn    func(ctx context.Context, uniqueID string) (string, bool)
 	specs          map[string][]downloader.HistorySpec
-	monitorTaskID  string
-	monitorChatID  int64
+	monitors       map[string]int64
+	monitorFilters map[string]downloader.HistoryFilters
+	monitorMediaFn func(taskID string, chatID, messageID int64)
+	monitorDLs     map[string][]int64
+	gaps           map[int64][]int64 // chatID -> 聊天内全部媒体消息 id（升序），供 ScanMonitorGap 模拟
+	latest         map[int64]int64   // chatID -> 聊天最新消息 id，供 LatestMessageID 模拟
+	segmentFn      func(taskID string, segments []downloader.ScanSegment)
+	gapAfter       map[string][]int64
+	gapFails       map[string]int // taskID -> ScanMonitorGap 剩余的注入失败次数
+	chatMediaCalls map[string]int // DownloadStories/DownloadAvatars/DownloadStickerSet/DownloadMessages 调用次数
+	transfers      map[string]downloader.TaskTransfer
 }
 
 func newFakeClient() *fakeClient {
 	return &fakeClient{
-		gates:     make(map[string]chan struct{}),
-		errs:      make(map[string]error),
-		calls:     make(map[string]int),
-		counts:    make(map[int64]int64),
-		countErrs: make(map[int64]error),
-		specs:     make(map[string][]downloader.HistorySpec),
+		gates:          make(map[string]chan struct{}),
+		errs:           make(map[string]error),
+		calls:          make(map[string]int),
+		counts:         make(map[int64]int64),
+		countErrs:      make(map[int64]error),
+		specs:          make(map[string][]downloader.HistorySpec),
+		chatMediaCalls: make(map[string]int),
+		monitors:       make(map[string]int64),
+		monitorFilters: make(map[string]downloader.HistoryFilters),
+		monitorDLs:     make(map[string][]int64),
+		gaps:           make(map[int64][]int64),
+		gapAfter:       make(map[string][]int64),
+		gapFails:       make(map[string]int),
+		latest:         make(map[int64]int64),
+		transfers:      make(map[string]downloader.TaskTransfer),
 	}
 }
 
@@ -69,10 +89,18 @@ func (f *fakeClient) callCount(id string) int {
 	return f.calls[id]
 }
 
-func (f *fakeClient) monitor() (string, int64) {
+// monitor 返回 taskID 在 client 端登记的监控聊天（未登记时 ok=false）
+func (f *fakeClient) monitor(taskID string) (int64, bool) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	return f.monitorTaskID, f.monitorChatID
+	chatID, ok := f.monitors[taskID]
+	return chatID, ok
+}
+
+func (f *fakeClient) monitorCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.monitors)
 }
 
 func (f *fakeClient) setCount(chatID, total int64) {
@@ -87,7 +115,7 @@ func (f *fakeClient) setCountErr(chatID int64, err error) {
 	f.mu.Unlock()
 }
 
-func (f *fakeClient) CountHistoryMedia(_ context.Context, chatID int64, _ []string) (int64, error) {
+func (f *fakeClient) CountHistoryMedia(_ context.Context, chatID int64, _ downloader.HistoryFilters) (int64, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	if err := f.countErrs[chatID]; err != nil {
@@ -115,10 +143,115 @@ func (f *fakeClient) DownloadHistoryMedia(ctx context.Context, spec *downloader.
 	return err
 }
 
-func (f *fakeClient) SetMonitorTask(taskID string, chatID int64) {
+func (f *fakeClient) DownloadStories(ctx context.Context, spec *downloader.HistorySpec) error {
+	taskID := spec.TaskID
+	f.mu.Lock()
+	f.chatMediaCalls[taskID]++
+	f.mu.Unlock()
+
+	select {
+	case <-f.gate(taskID):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	f.mu.Lock()
+	err := f.errs[taskID]
+	f.mu.Unlock()
+	return err
+}
+
+func (f *fakeClient) DownloadAvatars(ctx context.Context, spec *downloader.HistorySpec) error {
+	f.mu.Lock()
+	f.specs[spec.TaskID] = append(f.specs[spec.TaskID], *spec)
+	f.mu.Unlock()
+	return f.DownloadStories(ctx, spec)
+}
+
+func (f *fakeClient) DownloadStickerSet(ctx context.Context, spec *downloader.HistorySpec) error {
+	return f.DownloadStories(ctx, spec)
+}
+
+func (f *fakeClient) DownloadMessages(ctx context.Context, spec *downloader.HistorySpec) error {
+	return f.DownloadAvatars(ctx, spec) // 同样记录 spec
+}
+
+func (f *fakeClient) AddMonitorTask(taskID string, chatID int64, filters downloader.HistoryFilters) {
+	f.mu.Lock()
+	f.monitors[taskID] = chatID
+	f.monitorFilters[taskID] = filters
+	f.mu.Unlock()
+}
+
+func (f *fakeClient) RemoveMonitorTask(taskID string) {
+	f.mu.Lock()
+	delete(f.monitors, taskID)
+	delete(f.monitorFilters, taskID)
+	f.mu.Unlock()
+}
+
+func (f *fakeClient) DownloadMonitorMessage(_ context.Context, taskID string, _, messageID int64) error {
+	f.mu.Lock()
+	f.monitorDLs[taskID] = append(f.monitorDLs[taskID], messageID)
+	f.mu.Unlock()
+	return nil
+}
+
+// monitorDownloads 返回 monitor 任务经 DownloadMonitorMessage 下载过的消息 id
+func (f *fakeClient) monitorDownloads(taskID string) []int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int64(nil), f.monitorDLs[taskID]...)
+}
+
+// ScanMonitorGap 按 gaps 模拟补扫：最新消息 id 取聊天最后一条，返回 afterMessageID 之后的媒体（0 = 仅取水位）
+func (f *fakeClient) ScanMonitorGap(_ context.Context, taskID string, chatID, afterMessageID int64) (int64, []int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gapAfter[taskID] = append(f.gapAfter[taskID], afterMessageID)
+	if f.gapFails[taskID] > 0 {
+		f.gapFails[taskID]--
+		return 0, nil, errors.New("TDLib 未连接")
+	}
+	all := f.gaps[chatID]
+	if len(all) == 0 {
+		return 0, nil, nil
+	}
+	var ids []int64
+	if afterMessageID != 0 {
+		for _, id := range all {
+			if id > afterMessageID {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return all[len(all)-1], ids, nil
+}
+
+// catchUps 返回 monitor 任务每次补扫传入的水位
+func (f *fakeClient) catchUps(taskID string) []int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int64(nil), f.gapAfter[taskID]...)
+}
+
+func (f *fakeClient) SetConnectionReadyFunc(func()) {}
+
+func (f *fakeClient) SetSegmentProgressFunc(fn func(taskID string, segments []downloader.ScanSegment)) {
+	f.mu.Lock()
+	f.segmentFn = fn
+	f.mu.Unlock()
+}
+
+func (f *fakeClient) LatestMessageID(_ context.Context, chatID int64) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.latest[chatID], nil
+}
+
+func (f *fakeClient) SetMonitorMediaFunc(fn func(taskID string, chatID, messageID int64)) {
 	f.mu.Lock()
-	f.monitorTaskID = taskID
-	f.monitorChatID = chatID
+	f.monitorMediaFn = fn
 	f.mu.Unlock()
 }
 
@@ -140,6 +273,18 @@ func (f *fakeClient) SetDuplicateLookupFunc(fn func(ctx context.Context, uniqueI
 	f.mu.Unlock()
 }
 
+func (f *fakeClient) TaskTransfer(taskID string) downloader.TaskTransfer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.transfers[taskID]
+}
+
+func (f *fakeClient) setTransfer(taskID string, tr downloader.TaskTransfer) {
+	f.mu.Lock()
+	f.transfers[taskID] = tr
+	f.mu.Unlock()
+}
+
 // newTestStore 创建一个基于临时文件的测试用 Store
 func newTestStore(t *testing.T) *store.Store {
 	t.Helper()
@@ -461,8 +606,8 @@ func TestMonitor_DoesNotBlockHistoryQueue(t *testing.T) {
 	if monDTO.Status != string(StatusRunning) {
 		t.Fatalf("monitor task status = %s, want running", monDTO.Status)
 	}
-	if mid, mchat := fc.monitor(); mid != monDTO.ID || mchat != 999 {
-		t.Fatalf("client monitor association = (%s,%d), want (%s,999)", mid, mchat, monDTO.ID)
+	if mchat, ok := fc.monitor(monDTO.ID); !ok || mchat != 999 {
+		t.Fatalf("client monitor association = (%d,%v), want (999,true)", mchat, ok)
 	}
 
 	stopped, err := m.Enqueue(KindMonitor, &downloader.HistorySpec{ChatID: 0}, "")
@@ -473,253 +618,683 @@ func TestMonitor_DoesNotBlockHistoryQueue(t *testing.T) {
 		t.Fatalf("stopping monitor returned %+v, want canceled snapshot of %s", stopped, monDTO.ID)
 	}
 	waitForStatus(t, m, monDTO.ID, StatusCanceled, testWaitTimeout)
-	if mid, mchat := fc.monitor(); mid != "" || mchat != 0 {
-		t.Fatalf("client monitor association not cleared after stop: (%s,%d)", mid, mchat)
+	if n := fc.monitorCount(); n != 0 {
+		t.Fatalf("client monitor association not cleared after stop: %d left", n)
 	}
 
 	fc.release(dto1.ID)
 	waitForStatus(t, m, dto1.ID, StatusCompleted, testWaitTimeout)
 }
 
-// TestMonitor_SwitchCancelsPrevious 验证切换监控目标会先取消旧的 monitor 任务再启动新的
-func TestMonitor_SwitchCancelsPrevious(t *testing.T) {
+// TestMonitor_MultipleChatsRunConcurrently 验证多个聊天的 monitor 任务并存、各自独立：
+// 同一聊天的重复监控被拒绝，取消其一不影响其它，ChatID 0 停止全部
+func TestMonitor_MultipleChatsRunConcurrently(t *testing.T) {
 	m, fc := newTestManager(t, 1)
 
 	first, err := m.Enqueue(KindMonitor, &downloader.HistorySpec{ChatID: 111}, "first")
 	if err != nil {
 		t.Fatalf("Enqueue(first monitor) error = %v", err)
 	}
-	waitForStatus(t, m, first.ID, StatusRunning, testWaitTimeout)
-
 	second, err := m.Enqueue(KindMonitor, &downloader.HistorySpec{ChatID: 222}, "second")
 	if err != nil {
 		t.Fatalf("Enqueue(second monitor) error = %v", err)
 	}
-	waitForStatus(t, m, first.ID, StatusCanceled, testWaitTimeout)
+	waitForStatus(t, m, first.ID, StatusRunning, testWaitTimeout)
 	waitForStatus(t, m, second.ID, StatusRunning, testWaitTimeout)
 
-	if mid, mchat := fc.monitor(); mid != second.ID || mchat != 222 {
-		t.Fatalf("client monitor association = (%s,%d), want (%s,222)", mid, mchat, second.ID)
-	}
-}
-
-// TestEnqueueHistory_DuplicateChatRejected 验证同一 chat_id 已存在排队中/运行中的 history 任务时，
-// 重复 Enqueue 被拒绝，且既不创建新的内存任务也不写入新的 store 行；任务终结后允许重新入队。
-func TestEnqueueHistory_DuplicateChatRejected(t *testing.T) {
-	m, fc := newTestManager(t, 1)
-
-	dto1, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 1}, "chat-1")
-	if err != nil {
-		t.Fatalf("Enqueue(task1) error = %v", err)
-	}
-	waitForStatus(t, m, dto1.ID, StatusRunning, testWaitTimeout)
-
-	if _, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 1}, "chat-1"); err == nil {
-		t.Fatal("对处于 running 状态的同一 chat_id 重复 Enqueue 应返回错误")
-	}
-
-	dto2, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 2}, "chat-2")
-	if err != nil {
-		t.Fatalf("Enqueue(task2) error = %v", err)
+	if _, err := m.Enqueue(KindMonitor, &downloader.HistorySpec{ChatID: 111}, "first"); err == nil {
+		t.Fatal("同一聊天已有运行中的 monitor 时重复 Enqueue 应返回错误")
 	}
-	time.Sleep(50 * time.Millisecond)
-	if got, ok := m.Get(dto2.ID); !ok || got.Status != string(StatusQueued) {
-		t.Fatalf("task2 should still be queued while task1 occupies the only worker, got %+v ok=%v", got, ok)
+	if mchat, ok := fc.monitor(first.ID); !ok || mchat != 111 {
+		t.Fatalf("first monitor association = (%d,%v), want (111,true)", mchat, ok)
 	}
-
-	if _, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 2}, "chat-2"); err == nil {
-		t.Fatal("对处于 queued 状态的同一 chat_id 重复 Enqueue 应返回错误")
+	if mchat, ok := fc.monitor(second.ID); !ok || mchat != 222 {
+		t.Fatalf("second monitor association = (%d,%v), want (222,true)", mchat, ok)
 	}
 
-	if got := len(m.List()); got != 2 {
-		t.Fatalf("重复入队不应创建新任务，当前任务数 = %d, want 2", got)
+	if err := m.Cancel(first.ID); err != nil {
+		t.Fatalf("Cancel(first) error = %v", err)
 	}
-	rows, err := m.store.ListTasks(context.Background())
-	if err != nil {
-		t.Fatalf("ListTasks() error = %v", err)
+	waitForStatus(t, m, first.ID, StatusCanceled, testWaitTimeout)
+	if _, ok := fc.monitor(first.ID); ok {
+		t.Fatal("取消后 first monitor 的 client 端关联未注销")
 	}
-	if len(rows) != 2 {
-		t.Fatalf("重复入队不应写入新的 store 行，当前行数 = %d, want 2", len(rows))
+	if got, _ := m.Get(second.ID); got.Status != string(StatusRunning) {
+		t.Fatalf("取消 first 不应影响 second，got status=%s", got.Status)
 	}
 
-	fc.release(dto1.ID)
-	waitForStatus(t, m, dto1.ID, StatusCompleted, testWaitTimeout)
-	waitForStatus(t, m, dto2.ID, StatusRunning, testWaitTimeout)
-
-	// task1 已终结，chat-1 应允许重新入队
-	dto3, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 1}, "chat-1")
+	third, err := m.Enqueue(KindMonitor, &downloader.HistorySpec{ChatID: 111}, "first")
 	if err != nil {
-		t.Fatalf("任务终结后重新 Enqueue(chat-1) error = %v", err)
+		t.Fatalf("first 停止后重新监控同一聊天 error = %v", err)
 	}
-	waitForStatus(t, m, dto3.ID, StatusQueued, testWaitTimeout)
-
-	fc.release(dto2.ID)
-	waitForStatus(t, m, dto2.ID, StatusCompleted, testWaitTimeout)
-	waitForStatus(t, m, dto3.ID, StatusRunning, testWaitTimeout)
-	fc.release(dto3.ID)
-	waitForStatus(t, m, dto3.ID, StatusCompleted, testWaitTimeout)
-}
-
-// TestNewManager_ResumesInterruptedTasksFromStore 验证 v2.0 断点续跑：重启前运行中的
-// history 任务以同一 id 重置为 queued 并在 Run 启动后从持久化游标续扫；
-// 运行中的 monitor 任务自动恢复；终态任务原样载入；List() 保持最新优先的顺序。
-func TestNewManager_ResumesInterruptedTasksFromStore(t *testing.T) {
-	st := newTestStore(t)
-	ctx := context.Background()
+	waitForStatus(t, m, third.ID, StatusRunning, testWaitTimeout)
 
-	interrupted := &store.TaskRow{
-		ID: "old-1", Kind: string(KindHistory), ChatID: 1, ChatTitle: "chat-1",
-		Status: string(StatusRunning), CreatedAt: time.Now().Add(-2 * time.Minute),
-		ScanCursor: 777,
-	}
-	if err := st.CreateTask(ctx, interrupted); err != nil {
-		t.Fatalf("CreateTask(interrupted) error = %v", err)
-	}
-	monitor := &store.TaskRow{
-		ID: "mon-1", Kind: string(KindMonitor), ChatID: 9, ChatTitle: "chat-9",
-		Status: string(StatusRunning), CreatedAt: time.Now().Add(-time.Minute),
-	}
-	if err := st.CreateTask(ctx, monitor); err != nil {
-		t.Fatalf("CreateTask(monitor) error = %v", err)
-	}
-	done := &store.TaskRow{
-		ID: "new-1", Kind: string(KindHistory), ChatID: 2, ChatTitle: "chat-2",
-		Status: string(StatusCompleted), CreatedAt: time.Now(),
+	if _, err := m.Enqueue(KindMonitor, &downloader.HistorySpec{ChatID: 0}, ""); err != nil {
+		t.Fatalf("Enqueue(stop all monitors) error = %v", err)
 	}
-	if err := st.CreateTask(ctx, done); err != nil {
-		t.Fatalf("CreateTask(done) error = %v", err)
+	waitForStatus(t, m, second.ID, StatusCanceled, testWaitTimeout)
+	waitForStatus(t, m, third.ID, StatusCanceled, testWaitTimeout)
+	if n := fc.monitorCount(); n != 0 {
+		t.Fatalf("停止全部监控后仍有 %d 个 client 端关联", n)
 	}
+}
 
+// TestMonitor_FiltersPersistedAndEditable 验证 monitor 任务的过滤器随 client 端登记生效、落库，
+// 且可在运行中修改（client 端登记与 store 行同步更新）
+func TestMonitor_FiltersPersistedAndEditable(t *testing.T) {
 	fc := newFakeClient()
+	st := newTestStore(t)
 	m := NewManager(fc, st, logger.New(logger.LevelError), 1, 0)
 
-	list := m.List()
-	if len(list) != 3 {
-		t.Fatalf("List() len = %d, want 3", len(list))
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go m.Run(ctx)
+
+	filters := downloader.HistoryFilters{MediaTypes: []string{"photo"}}
+	dto, err := m.Enqueue(KindMonitor, &downloader.HistorySpec{ChatID: 5, Filters: filters}, "chat-5")
+	if err != nil {
+		t.Fatalf("Enqueue(monitor) error = %v", err)
 	}
-	if list[0].ID != "new-1" || list[1].ID != "mon-1" || list[2].ID != "old-1" {
-		t.Fatalf("List() order = [%s,%s,%s], want [new-1,mon-1,old-1] (最新优先)", list[0].ID, list[1].ID, list[2].ID)
+	fc.mu.Lock()
+	got := fc.monitorFilters[dto.ID]
+	fc.mu.Unlock()
+	if len(got.MediaTypes) != 1 || got.MediaTypes[0] != "photo" {
+		t.Fatalf("client 端过滤器 = %+v, want photo", got)
 	}
-	if list[0].Status != string(StatusCompleted) {
-		t.Fatalf("终态任务不应被改动，got status = %s", list[0].Status)
+
+	updated, err := m.UpdateFilters(dto.ID, downloader.HistoryFilters{MaxFileSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("UpdateFilters() error = %v", err)
 	}
-	if list[2].Status != string(StatusQueued) {
-		t.Fatalf("中断的 history 任务应重置为 queued 待恢复，got status=%s", list[2].Status)
+	if updated.Filters == nil || updated.Filters.MaxFileSize != 1<<20 || len(updated.Filters.MediaTypes) != 0 {
+		t.Fatalf("DTO 过滤器未更新: %+v", updated.Filters)
 	}
-
-	runCtx, cancel := context.WithCancel(context.Background())
-	t.Cleanup(cancel)
-	go m.Run(runCtx)
-
-	// history 任务恢复执行：同一 id、从持久化游标续扫
-	waitForStatus(t, m, "old-1", StatusRunning, testWaitTimeout)
-	fc.release("old-1")
-	waitForStatus(t, m, "old-1", StatusCompleted, testWaitTimeout)
 	fc.mu.Lock()
-	specs := fc.specs["old-1"]
-	monitorTaskID, monitorChatID := fc.monitorTaskID, fc.monitorChatID
+	got = fc.monitorFilters[dto.ID]
 	fc.mu.Unlock()
-	if len(specs) != 1 || specs[0].FromMessageID != 777 {
-		t.Fatalf("恢复任务应携带持久化游标 777, got specs=%+v", specs)
+	if got.MaxFileSize != 1<<20 {
+		t.Fatalf("client 端过滤器未更新: %+v", got)
 	}
-
-	// monitor 任务自动恢复：同一 id 重新关联 client
-	if monitorTaskID != "mon-1" || monitorChatID != 9 {
-		t.Fatalf("monitor 任务未恢复: SetMonitorTask(%q, %d), want (mon-1, 9)", monitorTaskID, monitorChatID)
+	row, err := st.GetTask(context.Background(), dto.ID)
+	if err != nil || row == nil || row.Filters != `{"max_file_size":1048576}` {
+		t.Fatalf("过滤器未落库: row=%+v err=%v", row, err)
 	}
 
-	row2, err := st.GetTask(ctx, "new-1")
-	if err != nil {
-		t.Fatalf("GetTask(new-1) error = %v", err)
+	if err := m.Cancel(dto.ID); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
 	}
-	if row2 == nil || row2.Status != string(StatusCompleted) {
-		t.Fatalf("终态任务的 store 行不应被改动: %+v", row2)
+	waitForStatus(t, m, dto.ID, StatusCanceled, testWaitTimeout)
+	if _, err := m.UpdateFilters(dto.ID, downloader.HistoryFilters{}); err == nil {
+		t.Fatal("已停止的监控任务不应允许修改过滤条件")
+	}
+	if _, ok := fc.monitor(dto.ID); ok {
+		t.Fatal("已停止的监控任务不应重新登记到 client")
 	}
 }
 
-// TestHandleRecordEvent_AsyncPersistPreservesOrder 验证下载记录持久化异步化后：
-// 事件最终仍会到达 store（轮询等待，而非同步断言），且同一媒体项的 Started 先于 Completed 落盘
-// ——若顺序颠倒，该记录会停留在 downloading 而非到达 completed。
-func TestHandleRecordEvent_AsyncPersistPreservesOrder(t *testing.T) {
+// TestMonitor_ForumTopicsDedup 验证同一论坛不同话题的监控可并存，同话题或全论坛监控冲突；
+// 运行中修改过滤条件不会丢失话题限定
+func TestMonitor_ForumTopicsDedup(t *testing.T) {
 	m, fc := newTestManager(t, 1)
+	enqueue := func(topicID int64) (TaskDTO, error) {
+		return m.Enqueue(KindMonitor, &downloader.HistorySpec{ChatID: 9, Filters: downloader.HistoryFilters{TopicID: topicID}}, "forum")
+	}
 
-	dto, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 1}, "chat-1")
+	first, err := enqueue(10)
 	if err != nil {
-		t.Fatalf("Enqueue() error = %v", err)
+		t.Fatalf("Enqueue(topic 10) error = %v", err)
+	}
+	if _, err := enqueue(20); err != nil {
+		t.Fatalf("不同话题的监控应允许并存: %v", err)
+	}
+	if _, err := enqueue(10); err == nil {
+		t.Fatal("同一话题重复监控应被拒绝")
+	}
+	if _, err := enqueue(0); err == nil {
+		t.Fatal("已有话题监控时整个论坛的监控应被拒绝")
 	}
-	waitForStatus(t, m, dto.ID, StatusRunning, testWaitTimeout)
 
+	updated, err := m.UpdateFilters(first.ID, downloader.HistoryFilters{MediaTypes: []string{"video"}})
+	if err != nil {
+		t.Fatalf("UpdateFilters() error = %v", err)
+	}
+	if updated.Filters == nil || updated.Filters.TopicID != 10 {
+		t.Fatalf("修改过滤条件后话题限定丢失: %+v", updated.Filters)
+	}
 	fc.mu.Lock()
-	recordFn := fc.recordFn
+	got := fc.monitorFilters[first.ID]
 	fc.mu.Unlock()
-	if recordFn == nil {
-		t.Fatal("Manager 未通过 SetRecordFunc 注册记录回调")
-	}
-
-	media := &downloader.MediaInfo{
-		TaskID: dto.ID, ChatID: 1, MessageID: 42,
-		MediaType: "photo", FileName: "a.jpg", FileSize: 100,
+	if got.TopicID != 10 || len(got.MediaTypes) != 1 {
+		t.Fatalf("client 端过滤器 = %+v, want topic 10 + video", got)
 	}
-	ctx := context.Background()
-	recordFn(ctx, downloader.RecordEvent{Media: media, Status: downloader.RecordStarted, FilePath: "/tmp/a.jpg"})
-	recordFn(ctx, downloader.RecordEvent{Media: media, Status: downloader.RecordCompleted, FilePath: "/tmp/a.jpg"})
+}
 
+// waitMonitorDrained 轮询直至 monitor 任务下载过 want 中的全部消息且持久化待下载队列已清空
+func waitMonitorDrained(t *testing.T, fc *fakeClient, st *store.Store, taskID string, want ...int64) {
+	t.Helper()
 	deadline := time.Now().Add(testWaitTimeout)
-	var recs []*store.HistoryRecord
 	for {
-		recs, _, err = m.store.QueryHistory(ctx, &store.HistoryFilter{ChatID: 1})
+		got := fc.monitorDownloads(taskID)
+		pending, err := st.ListMonitorPending(context.Background(), taskID)
 		if err != nil {
-			t.Fatalf("QueryHistory() error = %v", err)
+			t.Fatalf("ListMonitorPending() error = %v", err)
 		}
-		if len(recs) == 1 && recs[0].Status == store.HistoryStatusCompleted {
-			break
+		seen := make(map[int64]bool, len(got))
+		for _, id := range got {
+			seen[id] = true
+		}
+		all := true
+		for _, id := range want {
+			all = all && seen[id]
+		}
+		if all && len(pending) == 0 {
+			return
 		}
 		if time.Now().After(deadline) {
-			t.Fatalf("等待记录异步落盘为 completed 超时，当前记录: %+v", recs)
+			t.Fatalf("等待监控队列消费超时: downloaded=%v pending=%v want=%v", got, pending, want)
 		}
 		time.Sleep(5 * time.Millisecond)
 	}
-	if recs[0].FileSize != media.FileSize {
-		t.Fatalf("记录 FileSize = %d, want %d", recs[0].FileSize, media.FileSize)
-	}
-
-	fc.release(dto.ID)
-	waitForStatus(t, m, dto.ID, StatusCompleted, testWaitTimeout)
 }
 
-// TestRunHistoryTask_AutoRetry 验证任务级自动重试：失败后同一 id 退避重入队并续扫，
-// 重试耗尽后落定为 failed（最终失败点）
-func TestRunHistoryTask_AutoRetry(t *testing.T) {
+// TestMonitor_PendingQueueDownloadsNewMedia 验证监控新媒体经持久化待下载队列由 worker 下载，完成后移除登记
+func TestMonitor_PendingQueueDownloadsNewMedia(t *testing.T) {
 	fc := newFakeClient()
-	m := NewManager(fc, newTestStore(t), logger.New(logger.LevelError), 1, 2)
-	m.retryBackoff = func(int) time.Duration { return time.Millisecond }
+	st := newTestStore(t)
+	m := NewManager(fc, st, logger.New(logger.LevelError), 1, 0)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	t.Cleanup(cancel)
 	go m.Run(ctx)
 
-	dto, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 1}, "chat-1")
+	dto, err := m.Enqueue(KindMonitor, &downloader.HistorySpec{ChatID: 5}, "chat-5")
 	if err != nil {
-		t.Fatalf("Enqueue() error = %v", err)
+		t.Fatalf("Enqueue(monitor) error = %v", err)
 	}
-	waitForStatus(t, m, dto.ID, StatusRunning, testWaitTimeout)
-
-	// 三次执行（首跑 + 2 次自动重试）全部失败
 	fc.mu.Lock()
-	fc.errs[dto.ID] = errors.New("network down")
+	deliver := fc.monitorMediaFn
 	fc.mu.Unlock()
-	fc.release(dto.ID)
-
-	waitForStatus(t, m, dto.ID, StatusFailed, testWaitTimeout)
-	got, _ := m.Get(dto.ID)
-	if got.Attempts != 2 {
-		t.Fatalf("Attempts = %d, want 2", got.Attempts)
+	if deliver == nil {
+		t.Fatal("Manager 未通过 SetMonitorMediaFunc 注册监控投递回调")
 	}
-	fc.mu.Lock()
-	calls := fc.calls[dto.ID]
-	fc.mu.Unlock()
-	if calls != 3 {
-		t.Fatalf("DownloadHistoryMedia 调用次数 = %d, want 3（首跑+2次重试）", calls)
+	deliver(dto.ID, 5, 100)
+	deliver(dto.ID, 5, 101)
+	waitMonitorDrained(t, fc, st, dto.ID, 100, 101)
+
+	// 未知任务的投递被忽略
+	deliver("missing", 5, 102)
+	time.Sleep(20 * time.Millisecond)
+	if ids, _ := st.ListMonitorPending(context.Background(), "missing"); len(ids) != 0 {
+		t.Fatalf("未知任务不应登记待下载媒体: %v", ids)
+	}
+}
+
+// TestMonitor_ResumeDownloadsPendingAndInterrupted 验证进程重启后恢复的 monitor 任务补下残留的
+// 待下载登记与被启动清扫标记为中断的下载行
+func TestMonitor_ResumeDownloadsPendingAndInterrupted(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+	if err := st.CreateTask(ctx, &store.TaskRow{
+		ID: "mon-1", Kind: string(KindMonitor), ChatID: 9, Status: string(StatusRunning), CreatedAt: time.Now(),
+	}); err != nil {
+		t.Fatalf("CreateTask() error = %v", err)
+	}
+	if err := st.AddMonitorPending(ctx, "mon-1", 9, 42); err != nil {
+		t.Fatalf("AddMonitorPending() error = %v", err)
+	}
+	if err := st.UpsertHistoryStart(ctx, &store.HistoryRecord{
+		TaskID: "mon-1", ChatID: 9, MessageID: 43, MediaType: "photo",
+		FileName: "a.jpg", FilePath: "/tmp/a.jpg", Status: store.HistoryStatusDownloading,
+	}); err != nil {
+		t.Fatalf("UpsertHistoryStart() error = %v", err)
+	}
+
+	fc := newFakeClient()
+	m := NewManager(fc, st, logger.New(logger.LevelError), 1, 0)
+	runCtx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go m.Run(runCtx)
+
+	waitForStatus(t, m, "mon-1", StatusRunning, testWaitTimeout)
+	waitMonitorDrained(t, fc, st, "mon-1", 42, 43)
+}
+
+// TestMonitor_CatchUpGapAfterRestart 验证恢复的 monitor 从持久化水位补扫停机期间的消息：
+// 水位之后的媒体被下载，之前的不重复下载，水位推进到最新消息并落库
+func TestMonitor_CatchUpGapAfterRestart(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+	if err := st.CreateTask(ctx, &store.TaskRow{
+		ID: "mon-1", Kind: string(KindMonitor), ChatID: 9, Status: string(StatusRunning),
+		CreatedAt: time.Now(), LastMessageID: 100,
+	}); err != nil {
+		t.Fatalf("CreateTask() error = %v", err)
+	}
+
+	fc := newFakeClient()
+	fc.gaps[9] = []int64{90, 100, 105, 110}
+	m := NewManager(fc, st, logger.New(logger.LevelError), 1, 0)
+	runCtx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go m.Run(runCtx)
+
+	waitForStatus(t, m, "mon-1", StatusRunning, testWaitTimeout)
+	waitMonitorDrained(t, fc, st, "mon-1", 105, 110)
+	if got := fc.monitorDownloads("mon-1"); len(got) != 2 {
+		t.Fatalf("补扫应只下载水位之后的媒体, got %v", got)
+	}
+	if got := fc.catchUps("mon-1"); len(got) != 1 || got[0] != 100 {
+		t.Fatalf("补扫起点 = %v, want [100]", got)
+	}
+	deadline := time.Now().Add(testWaitTimeout)
+	for {
+		row, err := st.GetTask(ctx, "mon-1")
+		if err != nil {
+			t.Fatalf("GetTask() error = %v", err)
+		}
+		if row.LastMessageID == 110 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("水位未落库: last_message_id = %d, want 110", row.LastMessageID)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if dto, _ := m.Get("mon-1"); dto.LastMessageID != 110 {
+		t.Fatalf("DTO LastMessageID = %d, want 110", dto.LastMessageID)
+	}
+}
+
+// TestMonitor_CatchUpRetryKeepsGapFloor 验证补扫失败后到达的实时消息不推进水位：
+// 重试仍从原水位补扫，缺口内的媒体不会遗漏
+func TestMonitor_CatchUpRetryKeepsGapFloor(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+	if err := st.CreateTask(ctx, &store.TaskRow{
+		ID: "mon-1", Kind: string(KindMonitor), ChatID: 9, Status: string(StatusRunning),
+		CreatedAt: time.Now(), LastMessageID: 100,
+	}); err != nil {
+		t.Fatalf("CreateTask() error = %v", err)
+	}
+
+	fc := newFakeClient()
+	fc.gaps[9] = []int64{90, 100, 105, 110, 120}
+	fc.gapFails["mon-1"] = 1
+	m := NewManager(fc, st, logger.New(logger.LevelError), 1, 0)
+	runCtx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go m.Run(runCtx)
+
+	waitForStatus(t, m, "mon-1", StatusRunning, testWaitTimeout)
+	deadline := time.Now().Add(testWaitTimeout)
+	for len(fc.catchUps("mon-1")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("等待首次补扫超时")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// 首次补扫失败，重试前到达一条实时消息
+	m.handleMonitorMedia("mon-1", 9, 120)
+	waitMonitorDrained(t, fc, st, "mon-1", 120)
+	if dto, _ := m.Get("mon-1"); dto.LastMessageID != 100 {
+		t.Fatalf("补扫成功前实时消息不应推进水位, LastMessageID = %d, want 100", dto.LastMessageID)
+	}
+
+	m.handleConnectionReady() // 代替 monitorCatchUpRetry 后的定时重试
+	waitMonitorDrained(t, fc, st, "mon-1", 105, 110, 120)
+	if got := fc.catchUps("mon-1"); len(got) != 2 || got[0] != 100 || got[1] != 100 {
+		t.Fatalf("补扫起点 = %v, want [100 100]", got)
+	}
+	deadline = time.Now().Add(testWaitTimeout)
+	for {
+		if dto, _ := m.Get("mon-1"); dto.LastMessageID == 120 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("补扫成功后水位应推进到 120")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestEnqueueHistory_DuplicateChatRejected 验证同一 chat_id 已存在排队中/运行中的 history 任务时，
+// 重复 Enqueue 被拒绝，且既不创建新的内存任务也不写入新的 store 行；任务终结后允许重新入队。
+func TestEnqueueHistory_DuplicateChatRejected(t *testing.T) {
+	m, fc := newTestManager(t, 1)
+
+	dto1, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 1}, "chat-1")
+	if err != nil {
+		t.Fatalf("Enqueue(task1) error = %v", err)
+	}
+	waitForStatus(t, m, dto1.ID, StatusRunning, testWaitTimeout)
+
+	if _, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 1}, "chat-1"); err == nil {
+		t.Fatal("对处于 running 状态的同一 chat_id 重复 Enqueue 应返回错误")
+	}
+
+	dto2, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 2}, "chat-2")
+	if err != nil {
+		t.Fatalf("Enqueue(task2) error = %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got, ok := m.Get(dto2.ID); !ok || got.Status != string(StatusQueued) {
+		t.Fatalf("task2 should still be queued while task1 occupies the only worker, got %+v ok=%v", got, ok)
+	}
+
+	if _, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 2}, "chat-2"); err == nil {
+		t.Fatal("对处于 queued 状态的同一 chat_id 重复 Enqueue 应返回错误")
+	}
+
+	if got := len(m.List()); got != 2 {
+		t.Fatalf("重复入队不应创建新任务，当前任务数 = %d, want 2", got)
+	}
+	rows, err := m.store.ListTasks(context.Background())
+	if err != nil {
+		t.Fatalf("ListTasks() error = %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("重复入队不应写入新的 store 行，当前行数 = %d, want 2", len(rows))
+	}
+
+	fc.release(dto1.ID)
+	waitForStatus(t, m, dto1.ID, StatusCompleted, testWaitTimeout)
+	waitForStatus(t, m, dto2.ID, StatusRunning, testWaitTimeout)
+
+	// task1 已终结，chat-1 应允许重新入队
+	dto3, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 1}, "chat-1")
+	if err != nil {
+		t.Fatalf("任务终结后重新 Enqueue(chat-1) error = %v", err)
+	}
+	waitForStatus(t, m, dto3.ID, StatusQueued, testWaitTimeout)
+
+	fc.release(dto2.ID)
+	waitForStatus(t, m, dto2.ID, StatusCompleted, testWaitTimeout)
+	waitForStatus(t, m, dto3.ID, StatusRunning, testWaitTimeout)
+	fc.release(dto3.ID)
+	waitForStatus(t, m, dto3.ID, StatusCompleted, testWaitTimeout)
+}
+
+// TestEnqueueHistory_RangeOverlapRejected 验证消息区间任务按区间重叠去重：与整聊天任务或重叠区间冲突，
+// 不重叠的区间与单消息任务可并行入队
+func TestEnqueueHistory_RangeOverlapRejected(t *testing.T) {
+	m, _ := newTestManager(t, 1)
+	enqueue := func(spec *downloader.HistorySpec) error {
+		_, err := m.Enqueue(KindHistory, spec, "chat-1")
+		return err
+	}
+
+	if err := enqueue(&downloader.HistorySpec{ChatID: 1, RangeStart: 100, RangeEnd: 200}); err != nil {
+		t.Fatalf("Enqueue(range) error = %v", err)
+	}
+	if err := enqueue(&downloader.HistorySpec{ChatID: 1, RangeStart: 150, RangeEnd: 300}); err == nil {
+		t.Fatal("重叠的消息区间应被拒绝")
+	}
+	if err := enqueue(&downloader.HistorySpec{ChatID: 1, RangeEnd: 100}); err == nil {
+		t.Fatal("终点落在已有区间内的区间应被拒绝")
+	}
+	if err := enqueue(&downloader.HistorySpec{ChatID: 1}); err == nil {
+		t.Fatal("整聊天任务与已有区间重叠，应被拒绝")
+	}
+	if err := enqueue(&downloader.HistorySpec{ChatID: 1, RangeStart: 201, RangeEnd: 300}); err != nil {
+		t.Fatalf("不重叠的区间应允许入队: %v", err)
+	}
+	if err := enqueue(&downloader.HistorySpec{ChatID: 1, MessageID: 150}); err != nil {
+		t.Fatalf("单消息任务不受区间任务影响: %v", err)
+	}
+	if err := enqueue(&downloader.HistorySpec{ChatID: 2}); err != nil {
+		t.Fatalf("其他聊天不受影响: %v", err)
+	}
+}
+
+// TestHistory_RangeResumesFromCursorInsideRange 验证区间任务随任务持久化，恢复后区间不变、从区间内游标续扫
+func TestHistory_RangeResumesFromCursorInsideRange(t *testing.T) {
+	st := newTestStore(t)
+	if err := st.CreateTask(context.Background(), &store.TaskRow{
+		ID: "rng-1", Kind: string(KindHistory), ChatID: 5, Status: string(StatusRunning), CreatedAt: time.Now(),
+		RangeStart: 100, RangeEnd: 400, ScanCursor: 250,
+	}); err != nil {
+		t.Fatalf("CreateTask() error = %v", err)
+	}
+
+	fc := newFakeClient()
+	m := NewManager(fc, st, logger.New(logger.LevelError), 1, 0)
+	runCtx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go m.Run(runCtx)
+
+	waitForStatus(t, m, "rng-1", StatusRunning, testWaitTimeout)
+	deadline := time.Now().Add(testWaitTimeout)
+	for fc.callCount("rng-1") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("恢复的任务未开始扫描")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	fc.mu.Lock()
+	spec := fc.specs["rng-1"][0]
+	fc.mu.Unlock()
+	if spec.RangeStart != 100 || spec.RangeEnd != 400 || spec.FromMessageID != 250 {
+		t.Fatalf("恢复的区间任务应保留区间并从游标 250 续扫, spec = %+v", spec)
+	}
+	if dto, _ := m.Get("rng-1"); dto.RangeStart != 100 || dto.RangeEnd != 400 {
+		t.Fatalf("DTO 区间 = [%d, %d], want [100, 400]", dto.RangeStart, dto.RangeEnd)
+	}
+	fc.release("rng-1")
+	waitForStatus(t, m, "rng-1", StatusCompleted, testWaitTimeout)
+}
+
+// TestNewManager_ResumesInterruptedTasksFromStore 验证 v2.0 断点续跑：重启前运行中的
+// history 任务以同一 id 重置为 queued 并在 Run 启动后从持久化游标续扫；
+// 运行中的 monitor 任务全部自动恢复；终态任务原样载入；List() 保持最新优先的顺序。
+// TestNewManager_ResumesTopicMonitorsOfSameChat 验证重启恢复按话题去重：同一论坛聊天不同话题的监控都恢复，
+// 与已恢复监控话题重叠（整聊天）的较新监控才被终结
+func TestNewManager_ResumesTopicMonitorsOfSameChat(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+	base := time.Now().Add(-time.Hour)
+	for i, row := range []*store.TaskRow{
+		{ID: "topic-1", Filters: `{"topic_id":11}`},
+		{ID: "topic-2", Filters: `{"topic_id":22}`},
+		{ID: "whole"},
+	} {
+		row.Kind, row.ChatID, row.ChatTitle = string(KindMonitor), 5, "forum"
+		row.Status, row.CreatedAt = string(StatusRunning), base.Add(time.Duration(i)*time.Minute)
+		if err := st.CreateTask(ctx, row); err != nil {
+			t.Fatalf("CreateTask(%s) error = %v", row.ID, err)
+		}
+	}
+
+	fc := newFakeClient()
+	m := NewManager(fc, st, logger.New(logger.LevelError), 1, 0)
+	runCtx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go m.Run(runCtx)
+
+	for _, id := range []string{"topic-1", "topic-2"} {
+		waitForStatus(t, m, id, StatusRunning, testWaitTimeout)
+		deadline := time.Now().Add(testWaitTimeout)
+		for {
+			if chatID, ok := fc.monitor(id); ok && chatID == 5 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("话题监控 %s 未恢复", id)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	if got, _ := m.Get("whole"); got.Status != string(StatusCanceled) {
+		t.Fatalf("与话题监控重叠的整聊天监控 status = %s, want canceled", got.Status)
+	}
+}
+
+func TestNewManager_ResumesInterruptedTasksFromStore(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+
+	interrupted := &store.TaskRow{
+		ID: "old-1", Kind: string(KindHistory), ChatID: 1, ChatTitle: "chat-1",
+		Status: string(StatusRunning), CreatedAt: time.Now().Add(-2 * time.Minute),
+		ScanCursor: 777,
+	}
+	if err := st.CreateTask(ctx, interrupted); err != nil {
+		t.Fatalf("CreateTask(interrupted) error = %v", err)
+	}
+	monitor := &store.TaskRow{
+		ID: "mon-1", Kind: string(KindMonitor), ChatID: 9, ChatTitle: "chat-9",
+		Status: string(StatusRunning), CreatedAt: time.Now().Add(-time.Minute),
+	}
+	if err := st.CreateTask(ctx, monitor); err != nil {
+		t.Fatalf("CreateTask(monitor) error = %v", err)
+	}
+	monitor2 := &store.TaskRow{
+		ID: "mon-2", Kind: string(KindMonitor), ChatID: 10, ChatTitle: "chat-10",
+		Status: string(StatusRunning), CreatedAt: time.Now().Add(-time.Minute),
+	}
+	if err := st.CreateTask(ctx, monitor2); err != nil {
+		t.Fatalf("CreateTask(monitor2) error = %v", err)
+	}
+	done := &store.TaskRow{
+		ID: "new-1", Kind: string(KindHistory), ChatID: 2, ChatTitle: "chat-2",
+		Status: string(StatusCompleted), CreatedAt: time.Now(),
+	}
+	if err := st.CreateTask(ctx, done); err != nil {
+		t.Fatalf("CreateTask(done) error = %v", err)
+	}
+
+	fc := newFakeClient()
+	m := NewManager(fc, st, logger.New(logger.LevelError), 1, 0)
+
+	list := m.List()
+	if len(list) != 4 {
+		t.Fatalf("List() len = %d, want 4", len(list))
+	}
+	if list[0].ID != "new-1" || list[3].ID != "old-1" {
+		t.Fatalf("List() order = [%s,...,%s], want [new-1,...,old-1] (最新优先)", list[0].ID, list[3].ID)
+	}
+	if list[0].Status != string(StatusCompleted) {
+		t.Fatalf("终态任务不应被改动，got status = %s", list[0].Status)
+	}
+	if list[3].Status != string(StatusQueued) {
+		t.Fatalf("中断的 history 任务应重置为 queued 待恢复，got status=%s", list[3].Status)
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go m.Run(runCtx)
+
+	// history 任务恢复执行：同一 id、从持久化游标续扫
+	waitForStatus(t, m, "old-1", StatusRunning, testWaitTimeout)
+	fc.release("old-1")
+	waitForStatus(t, m, "old-1", StatusCompleted, testWaitTimeout)
+	fc.mu.Lock()
+	specs := fc.specs["old-1"]
+	fc.mu.Unlock()
+	if len(specs) != 1 || specs[0].FromMessageID != 777 {
+		t.Fatalf("恢复任务应携带持久化游标 777, got specs=%+v", specs)
+	}
+
+	// 全部 monitor 任务自动恢复：同一 id 重新关联 client
+	if chatID, ok := fc.monitor("mon-1"); !ok || chatID != 9 {
+		t.Fatalf("monitor 任务未恢复: mon-1 -> (%d,%v), want (9,true)", chatID, ok)
+	}
+	if chatID, ok := fc.monitor("mon-2"); !ok || chatID != 10 {
+		t.Fatalf("monitor 任务未恢复: mon-2 -> (%d,%v), want (10,true)", chatID, ok)
+	}
+
+	row2, err := st.GetTask(ctx, "new-1")
+	if err != nil {
+		t.Fatalf("GetTask(new-1) error = %v", err)
+	}
+	if row2 == nil || row2.Status != string(StatusCompleted) {
+		t.Fatalf("终态任务的 store 行不应被改动: %+v", row2)
+	}
+}
+
+// TestHandleRecordEvent_AsyncPersistPreservesOrder 验证下载记录持久化异步化后：
+// 事件最终仍会到达 store（轮询等待，而非同步断言），且同一媒体项的 Started 先于 Completed 落盘
+// ——若顺序颠倒，该记录会停留在 downloading 而非到达 completed。
+func TestHandleRecordEvent_AsyncPersistPreservesOrder(t *testing.T) {
+	m, fc := newTestManager(t, 1)
+
+	dto, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 1}, "chat-1")
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	waitForStatus(t, m, dto.ID, StatusRunning, testWaitTimeout)
+
+	fc.mu.Lock()
+	recordFn := fc.recordFn
+	fc.mu.Unlock()
+	if recordFn == nil {
+		t.Fatal("Manager 未通过 SetRecordFunc 注册记录回调")
+	}
+
+	media := &downloader.MediaInfo{
+		TaskID: dto.ID, ChatID: 1, MessageID: 42,
+		MediaType: "photo", FileName: "a.jpg", FileSize: 100,
+	}
+	ctx := context.Background()
+	recordFn(ctx, downloader.RecordEvent{Media: media, Status: downloader.RecordStarted, FilePath: "/tmp/a.jpg"})
+	recordFn(ctx, downloader.RecordEvent{Media: media, Status: downloader.RecordCompleted, FilePath: "/tmp/a.jpg"})
+
+	deadline := time.Now().Add(testWaitTimeout)
+	var recs []*store.HistoryRecord
+	for {
+		recs, _, err = m.store.QueryHistory(ctx, &store.HistoryFilter{ChatID: 1})
+		if err != nil {
+			t.Fatalf("QueryHistory() error = %v", err)
+		}
+		if len(recs) == 1 && recs[0].Status == store.HistoryStatusCompleted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待记录异步落盘为 completed 超时，当前记录: %+v", recs)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if recs[0].FileSize != media.FileSize {
+		t.Fatalf("记录 FileSize = %d, want %d", recs[0].FileSize, media.FileSize)
+	}
+
+	fc.release(dto.ID)
+	waitForStatus(t, m, dto.ID, StatusCompleted, testWaitTimeout)
+}
+
+// TestRunHistoryTask_AutoRetry 验证任务级自动重试：失败后同一 id 退避重入队并续扫，
+// 重试耗尽后落定为 failed（最终失败点）
+func TestRunHistoryTask_AutoRetry(t *testing.T) {
+	fc := newFakeClient()
+	m := NewManager(fc, newTestStore(t), logger.New(logger.LevelError), 1, 2)
+	m.retryBackoff = func(int) time.Duration { return time.Millisecond }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go m.Run(ctx)
+
+	dto, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 1}, "chat-1")
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	waitForStatus(t, m, dto.ID, StatusRunning, testWaitTimeout)
+
+	// 三次执行（首跑 + 2 次自动重试）全部失败
+	fc.mu.Lock()
+	fc.errs[dto.ID] = errors.New("network down")
+	fc.mu.Unlock()
+	fc.release(dto.ID)
+
+	waitForStatus(t, m, dto.ID, StatusFailed, testWaitTimeout)
+	got, _ := m.Get(dto.ID)
+	if got.Attempts != 2 {
+		t.Fatalf("Attempts = %d, want 2", got.Attempts)
+	}
+	fc.mu.Lock()
+	calls := fc.calls[dto.ID]
+	fc.mu.Unlock()
+	if calls != 3 {
+		t.Fatalf("DownloadHistoryMedia 调用次数 = %d, want 3（首跑+2次重试）", calls)
 	}
 }
 
@@ -730,37 +1305,249 @@ func TestEnqueue_FiltersFlowThroughSpecAndRetry(t *testing.T) {
 	st := newTestStore(t)
 	m := NewManager(fc, st, logger.New(logger.LevelError), 1, 0)
 
-	ctx, cancel := context.WithCancel(context.Background())
-	t.Cleanup(cancel)
-	go m.Run(ctx)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go m.Run(ctx)
+
+	filters := downloader.HistoryFilters{MediaTypes: []string{"photo"}, DateFrom: 1700000000, MaxFileSize: 1 << 20}
+	dto, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 1, Filters: filters}, "chat-1")
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if dto.Filters == nil || dto.Filters.MediaTypes[0] != "photo" {
+		t.Fatalf("DTO 未携带过滤器: %+v", dto.Filters)
+	}
+
+	waitForStatus(t, m, dto.ID, StatusRunning, testWaitTimeout)
+	fc.setErr(dto.ID, errors.New("boom"))
+	fc.release(dto.ID)
+	waitForStatus(t, m, dto.ID, StatusFailed, testWaitTimeout)
+
+	fc.mu.Lock()
+	specs := fc.specs[dto.ID]
+	fc.mu.Unlock()
+	if len(specs) != 1 || specs[0].Filters.MaxFileSize != 1<<20 || len(specs[0].Filters.MediaTypes) != 1 {
+		t.Fatalf("spec 未携带过滤器: %+v", specs)
+	}
+
+	row, err := st.GetTask(context.Background(), dto.ID)
+	if err != nil || row == nil || row.Filters == "" {
+		t.Fatalf("过滤器未落库: row=%+v err=%v", row, err)
+	}
+
+	// 手动重试产生新任务，过滤器随行
+	retryDTO, err := m.Retry(dto.ID)
+	if err != nil {
+		t.Fatalf("Retry() error = %v", err)
+	}
+	waitForStatus(t, m, retryDTO.ID, StatusRunning, testWaitTimeout)
+	fc.release(retryDTO.ID)
+	waitForStatus(t, m, retryDTO.ID, StatusCompleted, testWaitTimeout)
+	fc.mu.Lock()
+	retrySpecs := fc.specs[retryDTO.ID]
+	fc.mu.Unlock()
+	if len(retrySpecs) != 1 || retrySpecs[0].Filters.DateFrom != 1700000000 {
+		t.Fatalf("Retry 未携带过滤器: %+v", retrySpecs)
+	}
+}
+
+// TestHistory_CommentsSkipsCountAndSurvivesRetry 验证评论模式：不做整聊天计数（总数保持未知），
+// 选项随 spec 抵达 client、落库持久化，且手动 Retry 后仍然携带
+func TestHistory_CommentsSkipsCountAndSurvivesRetry(t *testing.T) {
+	fc := newFakeClient()
+	fc.setCount(3, 42)
+	st := newTestStore(t)
+	m := NewManager(fc, st, logger.New(logger.LevelError), 1, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go m.Run(ctx)
+
+	dto, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 3, Comments: true}, "channel-3")
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	waitForStatus(t, m, dto.ID, StatusRunning, testWaitTimeout)
+	fc.setErr(dto.ID, errors.New("boom"))
+	fc.release(dto.ID)
+	final := waitForStatus(t, m, dto.ID, StatusFailed, testWaitTimeout)
+	if final.ExpectedTotal != 0 || !final.Comments {
+		t.Fatalf("ExpectedTotal=%d Comments=%v, want 0/true", final.ExpectedTotal, final.Comments)
+	}
+	if row, _ := st.GetTask(ctx, dto.ID); row == nil || !row.Comments {
+		t.Fatalf("评论模式未落库: %+v", row)
+	}
+
+	retryDTO, err := m.Retry(dto.ID)
+	if err != nil {
+		t.Fatalf("Retry() error = %v", err)
+	}
+	waitForStatus(t, m, retryDTO.ID, StatusRunning, testWaitTimeout)
+	fc.release(retryDTO.ID)
+	waitForStatus(t, m, retryDTO.ID, StatusCompleted, testWaitTimeout)
+	fc.mu.Lock()
+	specs := append(fc.specs[dto.ID], fc.specs[retryDTO.ID]...)
+	fc.mu.Unlock()
+	if len(specs) != 2 || !specs[0].Comments || !specs[1].Comments {
+		t.Fatalf("spec 未携带评论模式: %+v", specs)
+	}
+}
+
+// TestOnTerminal_CarriesLeaveAfter 验证 leave_after 随任务落库，并经终结回调交给调用方执行退出聊天
+func TestOnTerminal_CarriesLeaveAfter(t *testing.T) {
+	fc := newFakeClient()
+	st := newTestStore(t)
+	m := NewManager(fc, st, logger.New(logger.LevelError), 1, 0)
+	terminal := make(chan TaskDTO, 1)
+	m.SetOnTerminal(func(dto *TaskDTO) { terminal <- *dto })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go m.Run(ctx)
+
+	dto, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 8, LeaveAfter: true}, "invited")
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if row, _ := st.GetTask(ctx, dto.ID); row == nil || !row.LeaveAfter {
+		t.Fatalf("leave_after 未落库: %+v", row)
+	}
+	waitForStatus(t, m, dto.ID, StatusRunning, testWaitTimeout)
+	fc.release(dto.ID)
+
+	select {
+	case got := <-terminal:
+		if got.ID != dto.ID || !got.LeaveAfter || got.Status != string(StatusCompleted) {
+			t.Fatalf("终结回调 = %+v, want completed + leave_after", got)
+		}
+	case <-time.After(testWaitTimeout):
+		t.Fatal("等待终结回调超时")
+	}
+}
+
+// TestStory_RunsInWorkerPoolAndDedups 验证 story 任务不计数、走 DownloadStories，
+// 同一聊天重复入队被拒绝，且不与同聊天的 history 任务冲突
+func TestStory_RunsInWorkerPoolAndDedups(t *testing.T) {
+	m, fc := newTestManager(t, 2)
+	fc.setCount(5, 42)
 
-	filters := downloader.HistoryFilters{MediaTypes: []string{"photo"}, DateFrom: 1700000000, MaxFileSize: 1 << 20}
-	dto, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 1, Filters: filters}, "chat-1")
+	dto, err := m.Enqueue(KindStory, &downloader.HistorySpec{ChatID: 5}, "user-5")
 	if err != nil {
-		t.Fatalf("Enqueue() error = %v", err)
+		t.Fatalf("Enqueue(story) error = %v", err)
 	}
-	if dto.Filters == nil || dto.Filters.MediaTypes[0] != "photo" {
-		t.Fatalf("DTO 未携带过滤器: %+v", dto.Filters)
+	waitForStatus(t, m, dto.ID, StatusRunning, testWaitTimeout)
+	if _, err := m.Enqueue(KindStory, &downloader.HistorySpec{ChatID: 5}, "user-5"); err == nil {
+		t.Fatal("同一聊天重复的 story 任务应被拒绝")
+	}
+	histDTO, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 5}, "user-5")
+	if err != nil {
+		t.Fatalf("story 任务不应阻止同聊天的 history 任务: %v", err)
+	}
+
+	fc.release(dto.ID)
+	final := waitForStatus(t, m, dto.ID, StatusCompleted, testWaitTimeout)
+	if final.Kind != string(KindStory) || final.ExpectedTotal != 0 {
+		t.Fatalf("story 任务终态 = %+v, want kind=story 且不计数", final)
 	}
+	fc.release(histDTO.ID)
+	waitForStatus(t, m, histDTO.ID, StatusCompleted, testWaitTimeout)
 
+	fc.mu.Lock()
+	storyCalls, historyCalls := fc.chatMediaCalls[dto.ID], fc.calls[dto.ID]
+	fc.mu.Unlock()
+	if storyCalls != 1 || historyCalls != 0 {
+		t.Fatalf("DownloadStories=%d DownloadHistoryMedia=%d, want 1/0", storyCalls, historyCalls)
+	}
+}
+
+// TestAvatar_MembersFlowThroughSpecAndRetry 验证 avatar 任务的 members 选项落库并随 spec 与重试传递
+func TestAvatar_MembersFlowThroughSpecAndRetry(t *testing.T) {
+	m, fc := newTestManager(t, 1)
+
+	dto, err := m.Enqueue(KindAvatar, &downloader.HistorySpec{ChatID: 6, Members: true}, "group-6")
+	if err != nil {
+		t.Fatalf("Enqueue(avatar) error = %v", err)
+	}
+	if row, _ := m.store.GetTask(context.Background(), dto.ID); row == nil || !row.Members {
+		t.Fatalf("members 未落库: %+v", row)
+	}
 	waitForStatus(t, m, dto.ID, StatusRunning, testWaitTimeout)
 	fc.setErr(dto.ID, errors.New("boom"))
 	fc.release(dto.ID)
 	waitForStatus(t, m, dto.ID, StatusFailed, testWaitTimeout)
 
+	retryDTO, err := m.Retry(dto.ID)
+	if err != nil {
+		t.Fatalf("Retry() error = %v", err)
+	}
+	if retryDTO.Kind != string(KindAvatar) || !retryDTO.Members {
+		t.Fatalf("重试任务 = %+v, want avatar + members", retryDTO)
+	}
+	waitForStatus(t, m, retryDTO.ID, StatusRunning, testWaitTimeout)
+	fc.release(retryDTO.ID)
+	waitForStatus(t, m, retryDTO.ID, StatusCompleted, testWaitTimeout)
+
 	fc.mu.Lock()
-	specs := fc.specs[dto.ID]
+	specs := append(fc.specs[dto.ID], fc.specs[retryDTO.ID]...)
 	fc.mu.Unlock()
-	if len(specs) != 1 || specs[0].Filters.MaxFileSize != 1<<20 || len(specs[0].Filters.MediaTypes) != 1 {
-		t.Fatalf("spec 未携带过滤器: %+v", specs)
+	if len(specs) != 2 || !specs[0].Members || !specs[1].Members {
+		t.Fatalf("spec 未携带 members: %+v", specs)
 	}
+}
 
-	row, err := st.GetTask(context.Background(), dto.ID)
-	if err != nil || row == nil || row.Filters == "" {
-		t.Fatalf("过滤器未落库: row=%+v err=%v", row, err)
+// TestStickerSet_RunsInWorkerPool 验证贴纸包任务经 DownloadStickerSet 执行，同一贴纸包不重复入队
+func TestStickerSet_RunsInWorkerPool(t *testing.T) {
+	m, fc := newTestManager(t, 1)
+
+	dto, err := m.Enqueue(KindStickerSet, &downloader.HistorySpec{ChatID: 7}, "Cats")
+	if err != nil {
+		t.Fatalf("Enqueue(sticker_set) error = %v", err)
+	}
+	waitForStatus(t, m, dto.ID, StatusRunning, testWaitTimeout)
+	if _, err := m.Enqueue(KindStickerSet, &downloader.HistorySpec{ChatID: 7}, "Cats"); err == nil {
+		t.Fatal("同一贴纸包重复的任务应被拒绝")
+	}
+	fc.release(dto.ID)
+	final := waitForStatus(t, m, dto.ID, StatusCompleted, testWaitTimeout)
+	if final.Kind != string(KindStickerSet) || final.ChatTitle != "Cats" {
+		t.Fatalf("贴纸包任务终态 = %+v", final)
 	}
 
-	// 手动重试产生新任务，过滤器随行
+	fc.mu.Lock()
+	calls := fc.chatMediaCalls[dto.ID]
+	fc.mu.Unlock()
+	if calls != 1 {
+		t.Fatalf("DownloadStickerSet 调用 %d 次, want 1", calls)
+	}
+}
+
+// TestSearch_MessagesPersistAndFlowToSpec 验证 search 任务所选消息落库、随 spec 与重试传递，
+// 且多个 search 任务可同时排队
+func TestSearch_MessagesPersistAndFlowToSpec(t *testing.T) {
+	m, fc := newTestManager(t, 2)
+	refs := []downloader.MessageRef{{ChatID: 11, MessageID: 100}, {ChatID: -10022, MessageID: 200}}
+
+	if _, err := m.Enqueue(KindSearch, &downloader.HistorySpec{}, "搜索：x"); err == nil {
+		t.Fatal("未选择消息的 search 任务应被拒绝")
+	}
+	dto, err := m.Enqueue(KindSearch, &downloader.HistorySpec{Messages: refs}, "搜索：cat")
+	if err != nil {
+		t.Fatalf("Enqueue(search) error = %v", err)
+	}
+	if row, _ := m.store.GetTask(context.Background(), dto.ID); row == nil || row.SearchMessages == "" {
+		t.Fatalf("所选消息未落库: %+v", row)
+	}
+	other, err := m.Enqueue(KindSearch, &downloader.HistorySpec{Messages: refs[:1]}, "搜索：dog")
+	if err != nil {
+		t.Fatalf("第二个 search 任务不应被拒绝: %v", err)
+	}
+	waitForStatus(t, m, dto.ID, StatusRunning, testWaitTimeout)
+	fc.setErr(dto.ID, errors.New("boom"))
+	fc.release(dto.ID)
+	waitForStatus(t, m, dto.ID, StatusFailed, testWaitTimeout)
+	fc.release(other.ID)
+	waitForStatus(t, m, other.ID, StatusCompleted, testWaitTimeout)
+
 	retryDTO, err := m.Retry(dto.ID)
 	if err != nil {
 		t.Fatalf("Retry() error = %v", err)
@@ -768,11 +1555,162 @@ func TestEnqueue_FiltersFlowThroughSpecAndRetry(t *testing.T) {
 	waitForStatus(t, m, retryDTO.ID, StatusRunning, testWaitTimeout)
 	fc.release(retryDTO.ID)
 	waitForStatus(t, m, retryDTO.ID, StatusCompleted, testWaitTimeout)
+
 	fc.mu.Lock()
-	retrySpecs := fc.specs[retryDTO.ID]
+	specs := append(fc.specs[dto.ID], fc.specs[retryDTO.ID]...)
 	fc.mu.Unlock()
-	if len(retrySpecs) != 1 || retrySpecs[0].Filters.DateFrom != 1700000000 {
-		t.Fatalf("Retry 未携带过滤器: %+v", retrySpecs)
+	if len(specs) != 2 || !slices.Equal(specs[0].Messages, refs) || !slices.Equal(specs[1].Messages, refs) {
+		t.Fatalf("spec 未携带所选消息: %+v", specs)
+	}
+}
+
+// TestHistorySearchQuery_PersistsAndSkipsWatermark 验证聊天内搜索任务：关键词落库并随 spec 与重试传递，
+// 不统计总数、不推进同步水位；不同关键词可并行，相同关键词视为重复
+func TestHistorySearchQuery_PersistsAndSkipsWatermark(t *testing.T) {
+	m, fc := newTestManager(t, 2)
+	fc.setCount(8, 99)
+	fc.mu.Lock()
+	fc.latest[8] = 500
+	fc.mu.Unlock()
+
+	dto, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 8, SearchQuery: "#cats"}, "chat-8")
+	if err != nil {
+		t.Fatalf("Enqueue(search_query) error = %v", err)
+	}
+	if row, _ := m.store.GetTask(context.Background(), dto.ID); row == nil || row.SearchQuery != "#cats" {
+		t.Fatalf("search_query 未落库: %+v", row)
+	}
+	if _, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 8, SearchQuery: "#cats"}, "chat-8"); err == nil {
+		t.Fatal("相同关键词的搜索任务应被拒绝")
+	}
+	other, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 8, SearchQuery: "#dogs"}, "chat-8")
+	if err != nil {
+		t.Fatalf("不同关键词的搜索任务不应冲突: %v", err)
+	}
+
+	waitForStatus(t, m, dto.ID, StatusRunning, testWaitTimeout)
+	fc.setErr(dto.ID, errors.New("boom"))
+	fc.release(dto.ID)
+	failed := waitForStatus(t, m, dto.ID, StatusFailed, testWaitTimeout)
+	if failed.ExpectedTotal != 0 {
+		t.Fatalf("搜索任务不应统计总数: ExpectedTotal = %d", failed.ExpectedTotal)
+	}
+	fc.release(other.ID)
+	waitForStatus(t, m, other.ID, StatusCompleted, testWaitTimeout)
+	if wm, _ := m.store.GetChatWatermark(context.Background(), 8, ""); wm != 0 {
+		t.Fatalf("搜索任务不应推进同步水位: %d", wm)
+	}
+
+	retryDTO, err := m.Retry(dto.ID)
+	if err != nil {
+		t.Fatalf("Retry() error = %v", err)
+	}
+	if retryDTO.SearchQuery != "#cats" {
+		t.Fatalf("重试任务 search_query = %q", retryDTO.SearchQuery)
+	}
+	waitForStatus(t, m, retryDTO.ID, StatusRunning, testWaitTimeout)
+	fc.release(retryDTO.ID)
+	waitForStatus(t, m, retryDTO.ID, StatusCompleted, testWaitTimeout)
+
+	fc.mu.Lock()
+	specs := append(fc.specs[dto.ID], fc.specs[retryDTO.ID]...)
+	fc.mu.Unlock()
+	if len(specs) != 2 || specs[0].SearchQuery != "#cats" || specs[1].SearchQuery != "#cats" {
+		t.Fatalf("spec 未携带 search_query: %+v", specs)
+	}
+}
+
+// TestHistorySenderFilter_SkipsCount 验证发送者过滤的 history 任务不做整聊天计数，过滤器原样传入 spec
+func TestHistorySenderFilter_SkipsCount(t *testing.T) {
+	m, fc := newTestManager(t, 1)
+	fc.setCount(9, 120)
+
+	filters := downloader.HistoryFilters{SenderIDs: []int64{42}}
+	dto, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 9, Filters: filters}, "group-9")
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	waitForStatus(t, m, dto.ID, StatusRunning, testWaitTimeout)
+	fc.release(dto.ID)
+	final := waitForStatus(t, m, dto.ID, StatusCompleted, testWaitTimeout)
+	if final.ExpectedTotal != 0 {
+		t.Fatalf("ExpectedTotal = %d, want 0（发送者过滤无法预先计数）", final.ExpectedTotal)
+	}
+
+	fc.mu.Lock()
+	specs := fc.specs[dto.ID]
+	fc.mu.Unlock()
+	if len(specs) != 1 || !slices.Equal(specs[0].Filters.SenderIDs, []int64{42}) {
+		t.Fatalf("spec 未携带发送者过滤: %+v", specs)
+	}
+}
+
+func TestHistoryPopularityFilter_SkipsCountAndShowsInDTO(t *testing.T) {
+	m, fc := newTestManager(t, 1)
+	fc.setCount(9, 120)
+
+	filters := downloader.HistoryFilters{MinViews: 1000, MinReactions: 20}
+	dto, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 9, Filters: filters}, "channel-9")
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if dto.Filters == nil || dto.Filters.MinViews != 1000 || dto.Filters.MinReactions != 20 {
+		t.Fatalf("DTO 未携带热度阈值: %+v", dto.Filters)
+	}
+	if dto.FilterExpr != "views>=1000 reactions>=20" {
+		t.Fatalf("FilterExpr = %q", dto.FilterExpr)
+	}
+	waitForStatus(t, m, dto.ID, StatusRunning, testWaitTimeout)
+	fc.release(dto.ID)
+	final := waitForStatus(t, m, dto.ID, StatusCompleted, testWaitTimeout)
+	if final.ExpectedTotal != 0 {
+		t.Fatalf("ExpectedTotal = %d, want 0（热度阈值无法预先计数）", final.ExpectedTotal)
+	}
+}
+
+// TestRunningTaskTransferEstimate 验证运行中任务的剩余字节与 ETA：在途剩余 + 未处理媒体按平均大小估计，
+// 终态不再携带估算
+func TestRunningTaskTransferEstimate(t *testing.T) {
+	m, fc := newTestManager(t, 1)
+	fc.setCount(1, 10)
+
+	dto, err := m.Enqueue(KindHistory, &downloader.HistorySpec{ChatID: 1}, "chat-1")
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	waitForStatus(t, m, dto.ID, StatusRunning, testWaitTimeout)
+	deadline := time.Now().Add(testWaitTimeout)
+	for {
+		if got, _ := m.Get(dto.ID); got.ExpectedTotal == 10 && got.Phase == phaseDownloading {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("等待计数完成超时")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	fc.mu.Lock()
+	recordFn := fc.recordFn
+	fc.mu.Unlock()
+	ctx := context.Background()
+	for i, size := range []int64{100, 300} {
+		media := &downloader.MediaInfo{TaskID: dto.ID, ChatID: 1, MessageID: int64(i + 1), MediaType: "photo", FileSize: size}
+		recordFn(ctx, downloader.RecordEvent{Media: media, Status: downloader.RecordStarted})
+	}
+	fc.setTransfer(dto.ID, downloader.TaskTransfer{SpeedBps: 50, PendingBytes: 150})
+
+	got, _ := m.Get(dto.ID)
+	// 已处理 2 个（均值 200），剩余 8 个估 1600，加在途 150
+	if got.Stats.TotalSize != 400 || got.BytesRemaining != 1750 || got.SpeedBps != 50 || got.ETASeconds != 35 {
+		t.Fatalf("TotalSize/BytesRemaining/SpeedBps/ETASeconds = %d/%d/%d/%d, want 400/1750/50/35",
+			got.Stats.TotalSize, got.BytesRemaining, got.SpeedBps, got.ETASeconds)
+	}
+
+	fc.release(dto.ID)
+	final := waitForStatus(t, m, dto.ID, StatusCompleted, testWaitTimeout)
+	if final.BytesRemaining != 0 || final.ETASeconds != 0 || final.SpeedBps != 0 {
+		t.Fatalf("终态仍带传输估算: %+v", final)
 	}
 }
 
@@ -829,3 +1767,188 @@ func TestFireDueSchedules(t *testing.T) {
 	fc.release(taskID)
 	waitForStatus(t, m, taskID, StatusCompleted, testWaitTimeout)
 }
+
+// TestHistory_IncrementalStopsAtChatWatermark 验证增量任务：首次无水位全量扫描，完成后以开扫时的最新消息
+// 记为聊天水位；下次增量扫描止于该水位。水位按过滤条件分别记录，互不影响
+func TestHistory_IncrementalStopsAtChatWatermark(t *testing.T) {
+	m, fc := newTestManager(t, 1)
+	ctx := context.Background()
+
+	runOnce := func(spec *downloader.HistorySpec) downloader.HistorySpec {
+		t.Helper()
+		dto, err := m.Enqueue(KindHistory, spec, "chat-7")
+		if err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+		waitForStatus(t, m, dto.ID, StatusRunning, testWaitTimeout)
+		fc.release(dto.ID)
+		waitForStatus(t, m, dto.ID, StatusCompleted, testWaitTimeout)
+		fc.mu.Lock()
+		defer fc.mu.Unlock()
+		return fc.specs[dto.ID][0]
+	}
+
+	fc.mu.Lock()
+	fc.latest[7] = 500
+	fc.mu.Unlock()
+	if spec := runOnce(&downloader.HistorySpec{ChatID: 7, Incremental: true}); spec.StopAtMessageID != 0 || !spec.Incremental {
+		t.Fatalf("首次增量同步应全量扫描, spec = %+v", spec)
+	}
+	if wm, _ := m.store.GetChatWatermark(ctx, 7, ""); wm != 500 {
+		t.Fatalf("首次同步后水位 = %d, want 500", wm)
+	}
+
+	fc.mu.Lock()
+	fc.latest[7] = 800
+	fc.mu.Unlock()
+	if spec := runOnce(&downloader.HistorySpec{ChatID: 7, Incremental: true}); spec.StopAtMessageID != 500 {
+		t.Fatalf("增量扫描应止于水位 500, got %d", spec.StopAtMessageID)
+	}
+	if wm, _ := m.store.GetChatWatermark(ctx, 7, ""); wm != 800 {
+		t.Fatalf("增量同步后水位 = %d, want 800", wm)
+	}
+
+	fc.mu.Lock()
+	fc.latest[7] = 900
+	fc.mu.Unlock()
+	photos := downloader.HistoryFilters{MediaTypes: []string{"photo"}}
+	if spec := runOnce(&downloader.HistorySpec{ChatID: 7, Filters: photos}); spec.StopAtMessageID != 0 {
+		t.Fatalf("非增量任务不应止于水位, got %d", spec.StopAtMessageID)
+	}
+	if wm, _ := m.store.GetChatWatermark(ctx, 7, ""); wm != 800 {
+		t.Fatalf("带过滤器的任务不应推进无过滤水位, got %d", wm)
+	}
+	if wm, _ := m.store.GetChatWatermark(ctx, 7, photos.SyncKey()); wm != 900 {
+		t.Fatalf("带过滤器的任务应推进自己的水位, got %d, want 900", wm)
+	}
+
+	// 连续两次同一过滤条件的增量同步：首次全量并记下水位，第二次止于首次的上界（列表顺序不影响水位键）
+	fc.mu.Lock()
+	fc.latest[7] = 1000
+	fc.mu.Unlock()
+	first := downloader.HistoryFilters{MediaTypes: []string{"video", "photo"}}
+	if spec := runOnce(&downloader.HistorySpec{ChatID: 7, Incremental: true, Filters: first}); spec.StopAtMessageID != 0 {
+		t.Fatalf("该过滤条件的首次增量同步应全量扫描, got %d", spec.StopAtMessageID)
+	}
+	fc.mu.Lock()
+	fc.latest[7] = 1200
+	fc.mu.Unlock()
+	second := downloader.HistoryFilters{MediaTypes: []string{"photo", "video"}}
+	if spec := runOnce(&downloader.HistorySpec{ChatID: 7, Incremental: true, Filters: second}); spec.StopAtMessageID != 1000 {
+		t.Fatalf("第二次带过滤器的增量扫描应止于首次的上界 1000, got %d", spec.StopAtMessageID)
+	}
+	if wm, _ := m.store.GetChatWatermark(ctx, 7, second.SyncKey()); wm != 1200 {
+		t.Fatalf("带过滤器的增量同步后水位 = %d, want 1200", wm)
+	}
+
+	// 无过滤增量同步仍从自己的水位 800 起补齐被过滤掉的媒体
+	if spec := runOnce(&downloader.HistorySpec{ChatID: 7, Incremental: true}); spec.StopAtMessageID != 800 {
+		t.Fatalf("随后的无过滤增量扫描应止于水位 800, got %d", spec.StopAtMessageID)
+	}
+	if wm, _ := m.store.GetChatWatermark(ctx, 7, ""); wm != 1200 {
+		t.Fatalf("无过滤增量同步后水位 = %d, want 1200", wm)
+	}
+}
+
+// TestHistory_SegmentStateResumesAndPersists 验证分段扫描状态：恢复的任务按持久化的各段游标续扫，
+// 运行中上报的段游标随周期落盘，任务完成后清空
+func TestHistory_SegmentStateResumesAndPersists(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+	if err := st.CreateTask(ctx, &store.TaskRow{
+		ID: "seg-1", Kind: string(KindHistory), ChatID: 5, Status: string(StatusRunning), CreatedAt: time.Now(),
+		ScanSegments: 2, SegmentState: `[{"high":400,"low":200,"cursor":300},{"high":200,"low":0,"done":true}]`,
+	}); err != nil {
+		t.Fatalf("CreateTask() error = %v", err)
+	}
+
+	fc := newFakeClient()
+	m := NewManager(fc, st, logger.New(logger.LevelError), 1, 0)
+	runCtx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go m.Run(runCtx)
+
+	waitForStatus(t, m, "seg-1", StatusRunning, testWaitTimeout)
+	deadline := time.Now().Add(testWaitTimeout)
+	for fc.callCount("seg-1") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("恢复的任务未开始扫描")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	fc.mu.Lock()
+	spec := fc.specs["seg-1"][0]
+	segmentFn := fc.segmentFn
+	fc.mu.Unlock()
+	if spec.ScanSegments != 2 || len(spec.Segments) != 2 || spec.Segments[0].Cursor != 300 || !spec.Segments[1].Done {
+		t.Fatalf("恢复任务应按持久化分段续扫, spec = %+v", spec)
+	}
+
+	segmentFn("seg-1", []downloader.ScanSegment{{High: 400, Low: 200, Cursor: 250}, {High: 200, Done: true}})
+	m.persistRunning()
+	row, err := st.GetTask(ctx, "seg-1")
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if !strings.Contains(row.SegmentState, `"cursor":250`) {
+		t.Fatalf("段游标未落盘: %q", row.SegmentState)
+	}
+
+	fc.release("seg-1")
+	dto := waitForStatus(t, m, "seg-1", StatusCompleted, testWaitTimeout)
+	if len(dto.Segments) != 0 {
+		t.Fatalf("完成后分段状态应清空, got %+v", dto.Segments)
+	}
+	if row, _ = st.GetTask(ctx, "seg-1"); row.SegmentState != "" {
+		t.Fatalf("完成后落库的分段状态应清空, got %q", row.SegmentState)
+	}
+}
+
+// TestHistory_OldestFirstResumesWithForwardCursor 验证正序任务：方向随任务持久化，
+// 重启恢复后以游标（已扫描到的最新消息）作为正序续扫起点，重试沿用方向
+func TestHistory_OldestFirstResumesWithForwardCursor(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+	if err := st.CreateTask(ctx, &store.TaskRow{
+		ID: "asc-1", Kind: string(KindHistory), ChatID: 5, Status: string(StatusRunning), CreatedAt: time.Now(),
+		ScanCursor: 300, OldestFirst: true,
+	}); err != nil {
+		t.Fatalf("CreateTask() error = %v", err)
+	}
+
+	fc := newFakeClient()
+	m := NewManager(fc, st, logger.New(logger.LevelError), 1, 0)
+	runCtx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go m.Run(runCtx)
+
+	waitForStatus(t, m, "asc-1", StatusRunning, testWaitTimeout)
+	deadline := time.Now().Add(testWaitTimeout)
+	for fc.callCount("asc-1") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("恢复的任务未开始扫描")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	fc.mu.Lock()
+	spec := fc.specs["asc-1"][0]
+	fc.mu.Unlock()
+	if !spec.OldestFirst || spec.FromMessageID != 300 {
+		t.Fatalf("恢复的正序任务应从游标 300 向新续扫, spec = %+v", spec)
+	}
+
+	if err := m.Cancel("asc-1"); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	waitForStatus(t, m, "asc-1", StatusCanceled, testWaitTimeout)
+	dto, err := m.Retry("asc-1")
+	if err != nil {
+		t.Fatalf("Retry() error = %v", err)
+	}
+	if !dto.OldestFirst {
+		t.Fatal("重试应沿用正序方向")
+	}
+	if row, _ := st.GetTask(ctx, dto.ID); row == nil || !row.OldestFirst {
+		t.Fatalf("正序方向未落库: %+v", row)
+	}
+}
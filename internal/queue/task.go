@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -31,6 +32,9 @@ type task struct {
 	done      chan struct{} // 任务终结时关闭（经 markDone），monitor 切换时用于等待旧任务停止
 	closeOnce sync.Once     // 保证 done 只被关闭一次：排队取消与执行方退出可能竞争同一任务的终结路径
 
+	monitorWake    chan struct{} // monitor 待下载队列有新登记时的唤醒信号（容量 1，合并多次唤醒）
+	catchUpPending atomic.Bool   // monitor 是否需要在下次唤醒时先补扫离线期间的消息（恢复/重连时置位）
+
 	mu            sync.Mutex
 	status        Status
 	errMsg        string
@@ -48,6 +52,21 @@ type task struct {
 	resumed         bool                      // 本任务是否为进程重启后恢复（需补下中断行）
 	filters         downloader.HistoryFilters // 任务级过滤条件（持久化，零值 = 不过滤）
 	messageID       int64                     // 单消息任务的目标消息 id（持久化，0 = 整聊天）
+	rangeStart      int64                     // 消息区间任务的起点消息 id（持久化，0 = 不限）
+	rangeEnd        int64                     // 消息区间任务的终点消息 id（持久化，0 = 不限）
+	lastMessageID   int64                     // monitor：已见最新消息 id（离线补扫水位）；history：本次同步上界（持久化）
+	gapOpen         bool                      // monitor 是否有尚未补扫成功的离线缺口（仅内存态）
+	gapFloor        int64                     // monitor 离线缺口的补扫下界：请求补扫时的水位，保留至补扫成功
+	incremental     bool                      // history 增量模式：扫描到聊天同步水位即停止（持久化）
+	oldestFirst     bool                      // history 正序模式：由旧到新翻页，游标为已扫描的最新消息（持久化）
+	comments        bool                      // history 同时下载频道帖子评论区的媒体（持久化）
+	leaveAfter      bool                      // history 完成后退出该聊天（经邀请链接加入，持久化）
+	members         bool                      // avatar 同时下载成员个人头像（持久化）
+	messages        []downloader.MessageRef   // search 所选的跨聊天消息（持久化）
+	searchQuery     string                    // history 聊天内搜索的关键词/话题标签（持久化，重试与恢复沿用）
+	scanSegments    int                       // 分段并行扫描的段数，0 = 沿用配置（持久化）
+	segmentByDate   bool                      // 分段按日期区间等分（持久化）
+	segments        []downloader.ScanSegment  // 分段扫描各段区间与续扫游标（持久化，重启后按段续扫）
 	lastScanNotify  time.Time                 // 上次扫描进度对外推送时刻，用于限频
 
 	lastRecordNotify      time.Time // 上次下载记录对外推送时刻，用于限频
@@ -66,18 +85,71 @@ const recordNotifyMinGap = 250 * time.Millisecond
 // newTask 创建一个初始状态为 queued 的任务；spec 携带 ChatID/Filters/MessageID
 func newTask(kind Kind, spec *downloader.HistorySpec, chatTitle string) *task {
 	return &task{
-		id:        generateID(),
-		kind:      kind,
-		chatID:    spec.ChatID,
-		chatTitle: chatTitle,
-		createdAt: time.Now(),
-		done:      make(chan struct{}),
-		status:    StatusQueued,
-		filters:   spec.Filters,
-		messageID: spec.MessageID,
+		id:          generateID(),
+		kind:        kind,
+		chatID:      spec.ChatID,
+		chatTitle:   chatTitle,
+		createdAt:   time.Now(),
+		done:        make(chan struct{}),
+		monitorWake: make(chan struct{}, 1),
+		status:      StatusQueued,
+		filters:     spec.Filters,
+		messageID:   spec.MessageID,
+		rangeStart:  spec.RangeStart,
+		rangeEnd:    spec.RangeEnd,
+		incremental: spec.Incremental,
+		oldestFirst: spec.OldestFirst,
+		comments:    spec.Comments,
+		leaveAfter:  spec.LeaveAfter,
+		members:     spec.Members,
+		messages:    slices.Clone(spec.Messages),
+		searchQuery: spec.SearchQuery,
+
+		scanSegments:  spec.ScanSegments,
+		segmentByDate: spec.SegmentByDate,
+	}
+}
+
+// wakeMonitor 非阻塞地唤醒 monitor 下载 worker；已有未消费的唤醒时合并
+func (t *task) wakeMonitor() {
+	select {
+	case t.monitorWake <- struct{}{}:
+	default:
 	}
 }
 
+// requestCatchUp 标记 monitor 需补扫离线期间的消息并唤醒其下载 worker。
+// 补扫下界取首次请求时的水位并保留至补扫成功，失败重试与期间的实时投递都不会越过缺口
+func (t *task) requestCatchUp() {
+	t.mu.Lock()
+	if !t.gapOpen {
+		t.gapOpen, t.gapFloor = true, t.lastMessageID
+	}
+	t.mu.Unlock()
+	t.catchUpPending.Store(true)
+	t.wakeMonitor()
+}
+
+// advanceWatermark 将 monitor 水位推进到 messageID（只增不减）；离线缺口尚未补扫成功时不推进，
+// 否则缺口内的消息会落到水位之下而永久遗漏
+func (t *task) advanceWatermark(messageID int64) {
+	t.mu.Lock()
+	if !t.gapOpen && messageID > t.lastMessageID {
+		t.lastMessageID = messageID
+	}
+	t.mu.Unlock()
+}
+
+// closeGap 在补扫成功后结束离线缺口，并将水位推进到 newest（只增不减）
+func (t *task) closeGap(newest int64) {
+	t.mu.Lock()
+	t.gapOpen = false
+	if newest > t.lastMessageID {
+		t.lastMessageID = newest
+	}
+	t.mu.Unlock()
+}
+
 // markDone 关闭 done 通道，多次调用安全；由任务终结的唯一执行路径调用
 func (t *task) markDone() {
 	t.closeOnce.Do(func() { close(t.done) })
@@ -90,6 +162,14 @@ func taskFromRow(row *store.TaskRow) *task {
 	if row.Filters != "" {
 		_ = json.Unmarshal([]byte(row.Filters), &filters) // 解析失败退化为不过滤
 	}
+	var segments []downloader.ScanSegment
+	if row.SegmentState != "" {
+		_ = json.Unmarshal([]byte(row.SegmentState), &segments) // 解析失败退化为重新规划分段
+	}
+	var messages []downloader.MessageRef
+	if row.SearchMessages != "" {
+		_ = json.Unmarshal([]byte(row.SearchMessages), &messages)
+	}
 	return &task{
 		id:            row.ID,
 		kind:          Kind(row.Kind),
@@ -97,6 +177,7 @@ func taskFromRow(row *store.TaskRow) *task {
 		chatTitle:     row.ChatTitle,
 		createdAt:     row.CreatedAt,
 		done:          make(chan struct{}),
+		monitorWake:   make(chan struct{}, 1),
 		status:        Status(row.Status),
 		errMsg:        row.Error,
 		startedAt:     row.StartedAt,
@@ -106,6 +187,19 @@ func taskFromRow(row *store.TaskRow) *task {
 		attempts:      row.Attempts,
 		filters:       filters,
 		messageID:     row.MessageID,
+		rangeStart:    row.RangeStart,
+		rangeEnd:      row.RangeEnd,
+		lastMessageID: row.LastMessageID,
+		incremental:   row.Incremental,
+		oldestFirst:   row.OldestFirst,
+		comments:      row.Comments,
+		leaveAfter:    row.LeaveAfter,
+		members:       row.Members,
+		messages:      messages,
+		searchQuery:   row.SearchQuery,
+		scanSegments:  row.ScanSegments,
+		segmentByDate: row.SegmentByDate,
+		segments:      segments,
 		stats: downloader.Stats{
 			Total:          row.Total,
 			Downloaded:     row.Downloaded,
@@ -129,18 +223,47 @@ func (t *task) filtersJSON() string {
 	return string(data)
 }
 
+// segmentsJSON 返回分段状态的 JSON 序列化（未分段返回空串，落库为 NULL）
+func segmentsJSON(segments []downloader.ScanSegment) string {
+	if len(segments) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(segments)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// messagesJSON 返回 search 任务所选消息的 JSON 序列化（非 search 任务返回空串，落库为 NULL）
+func messagesJSON(messages []downloader.MessageRef) string {
+	if len(messages) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(messages)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
 // ToDTO 加锁返回任务状态的值拷贝快照
 func (t *task) ToDTO() TaskDTO {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	var filters *downloader.HistoryFilters
+	filterExpr := ""
 	if !t.filters.IsZero() {
 		f := t.filters
 		filters = &f
+		filterExpr = downloader.FormatFilterExpr(f)
 	}
 	return TaskDTO{
 		Filters:         filters,
+		FilterExpr:      filterExpr,
 		MessageID:       t.messageID,
+		RangeStart:      t.rangeStart,
+		RangeEnd:        t.rangeEnd,
 		ID:              t.id,
 		Kind:            string(t.kind),
 		ChatID:          t.chatID,
@@ -157,6 +280,31 @@ func (t *task) ToDTO() TaskDTO {
 		FoundMedia:      t.foundMedia,
 		ScanCursor:      t.scanCursor,
 		Attempts:        t.attempts,
+		LastMessageID:   t.lastMessageID,
+		Incremental:     t.incremental,
+		OldestFirst:     t.oldestFirst,
+		Comments:        t.comments,
+		LeaveAfter:      t.leaveAfter,
+		Members:         t.members,
+		Messages:        slices.Clone(t.messages),
+		SearchQuery:     t.searchQuery,
+		ScanSegments:    t.scanSegments,
+		SegmentByDate:   t.segmentByDate,
+		Segments:        slices.Clone(t.segments),
+	}
+}
+
+// applyTransfer 为运行中任务的快照填入实时速度、剩余字节与预计剩余时间：统计总数已知时，
+// 尚未处理的媒体按已处理媒体的平均大小（TotalSize/Total）估计
+func applyTransfer(dto *TaskDTO, tr downloader.TaskTransfer) {
+	dto.SpeedBps = tr.SpeedBps
+	remaining := tr.PendingBytes
+	if processed := int64(dto.Stats.Total); processed > 0 && dto.ExpectedTotal > processed {
+		remaining += (dto.ExpectedTotal - processed) * (dto.Stats.TotalSize / processed)
+	}
+	dto.BytesRemaining = remaining
+	if remaining > 0 && tr.SpeedBps > 0 {
+		dto.ETASeconds = (remaining + tr.SpeedBps - 1) / tr.SpeedBps
 	}
 }
 
@@ -202,15 +350,17 @@ func (t *task) clearRecordTrailing() {
 }
 
 // applyRecordEvent 按下载事件更新任务统计，增量规则与 downloader.DownloadStats 保持一致：
-// RecordStarted/RecordSkipped 各计一次 Total（互斥触发，不会重复计数同一媒体项）。
+// RecordStarted/RecordSkipped 各计一次 Total 与 TotalSize（互斥触发，不会重复计数同一媒体项）。
 func (t *task) applyRecordEvent(evt downloader.RecordEvent) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	switch evt.Status {
 	case downloader.RecordStarted:
 		t.stats.Total++
+		t.stats.TotalSize += evt.Media.FileSize
 	case downloader.RecordSkipped:
 		t.stats.Total++
+		t.stats.TotalSize += evt.Media.FileSize
 		t.stats.Skipped++
 	case downloader.RecordCompleted:
 		t.stats.Downloaded++
@@ -22,8 +22,9 @@ const (
 func (s *Store) UpsertHistoryStart(ctx context.Context, rec *HistoryRecord) error {
 	const q = `
 INSERT INTO history (task_id, chat_id, chat_title, message_id, media_type, file_name, file_path,
-                      file_size, mime_type, status, reason, created_at, finished_at, unique_id, album_id)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULL, ?, NULL, ?, ?)
+                      file_size, mime_type, status, reason, created_at, finished_at, unique_id, album_id, story_id,
+                      forwarded, forward_from_id, forward_message_id)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULL, ?, NULL, ?, ?, ?, ?, ?, ?)
 ON CONFLICT(chat_id, message_id) DO UPDATE SET
   task_id    = excluded.task_id,
   chat_title = excluded.chat_title,
@@ -37,7 +38,11 @@ ON CONFLICT(chat_id, message_id) DO UPDATE SET
   created_at = excluded.created_at,
   finished_at = NULL,
   unique_id  = COALESCE(NULLIF(excluded.unique_id, ''), history.unique_id),
-  album_id   = excluded.album_id
+  album_id   = excluded.album_id,
+  story_id   = excluded.story_id,
+  forwarded  = excluded.forwarded,
+  forward_from_id    = excluded.forward_from_id,
+  forward_message_id = excluded.forward_message_id
 WHERE history.status NOT IN ('completed', 'failed')
    OR (history.status = 'failed' AND history.reason = '` + HistoryReasonInterrupted + `')`
 
@@ -49,7 +54,8 @@ WHERE history.status NOT IN ('completed', 'failed')
 	_, err := s.execContext(ctx, q,
 		nullString(rec.TaskID), rec.ChatID, nullString(rec.ChatTitle), rec.MessageID,
 		rec.MediaType, rec.FileName, rec.FilePath, rec.FileSize, nullString(rec.MimeType),
-		rec.Status, timeToUnix(createdAt), nullString(rec.UniqueID), rec.AlbumID,
+		rec.Status, timeToUnix(createdAt), nullString(rec.UniqueID), rec.AlbumID, rec.StoryID,
+		rec.Forwarded, rec.ForwardFromID, rec.ForwardMessageID,
 	)
 	if err != nil {
 		return fmt.Errorf("写入下载历史失败: %w", err)
@@ -101,14 +107,15 @@ WHERE status = 'downloading'`
 }
 
 // ListInterruptedByTask 返回指定任务被进程重启清扫的中断行的 message_id 列表，
-// 供任务恢复时逐条补下（这些消息比扫描游标更新，仅靠游标续扫会永久漏掉）
-func (s *Store) ListInterruptedByTask(ctx context.Context, taskID string) ([]int64, error) {
+// 供任务恢复时逐条补下（这些消息比扫描游标更新，仅靠游标续扫会永久漏掉）。
+// 只返回 chatID 下的行：评论媒体记在讨论组名下，其消息 id 不能在任务所在聊天中重取
+func (s *Store) ListInterruptedByTask(ctx context.Context, taskID string, chatID int64) ([]int64, error) {
 	const q = `
 SELECT message_id FROM history
-WHERE task_id = ? AND status = 'failed' AND reason = ?
+WHERE task_id = ? AND chat_id = ? AND status = 'failed' AND reason = ?
 ORDER BY message_id DESC`
 
-	rows, err := s.db.QueryContext(ctx, q, taskID, HistoryReasonInterrupted)
+	rows, err := s.db.QueryContext(ctx, q, taskID, chatID, HistoryReasonInterrupted)
 	if err != nil {
 		return nil, fmt.Errorf("查询中断的下载历史失败: %w", err)
 	}
@@ -136,7 +143,8 @@ func (s *Store) FindCompletedByUniqueID(ctx context.Context, uniqueID string) (*
 	}
 	const q = `
 SELECT id, task_id, chat_id, chat_title, message_id, media_type, file_name, file_path,
-       file_size, mime_type, status, reason, created_at, finished_at
+       file_size, mime_type, status, reason, created_at, finished_at, story_id,
+       forwarded, forward_from_id, forward_message_id
 FROM history
 WHERE unique_id = ? AND status = 'completed'
 ORDER BY finished_at DESC LIMIT 1`
@@ -215,7 +223,8 @@ func (s *Store) QueryHistory(ctx context.Context, f *HistoryFilter) ([]*HistoryR
 
 	var q strings.Builder
 	q.WriteString(`SELECT id, task_id, chat_id, chat_title, message_id, media_type, file_name, file_path,
-       file_size, mime_type, status, reason, created_at, finished_at
+       file_size, mime_type, status, reason, created_at, finished_at, story_id,
+       forwarded, forward_from_id, forward_message_id
 FROM history `)
 	q.WriteString(where)
 	q.WriteString(` ORDER BY created_at DESC LIMIT ? OFFSET ?`)
@@ -288,7 +297,8 @@ func scanHistoryRow(row scanner) (*HistoryRecord, error) {
 
 	if err := row.Scan(
 		&rec.ID, &taskID, &rec.ChatID, &chatTitle, &rec.MessageID, &rec.MediaType, &rec.FileName,
-		&rec.FilePath, &rec.FileSize, &mime, &rec.Status, &reason, &createdAt, &finishedAt,
+		&rec.FilePath, &rec.FileSize, &mime, &rec.Status, &reason, &createdAt, &finishedAt, &rec.StoryID,
+		&rec.Forwarded, &rec.ForwardFromID, &rec.ForwardMessageID,
 	); err != nil {
 		return nil, err
 	}
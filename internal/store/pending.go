@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// AddMonitorPending 将监控任务发现的新媒体消息登记为待下载（同一任务的同一消息重复登记为无操作），
+// 下载结束后由 DeleteMonitorPending 移除；进程中断时残留的行在监控恢复时补下
+func (s *Store) AddMonitorPending(ctx context.Context, taskID string, chatID, messageID int64) error {
+	const q = `
+INSERT INTO monitor_pending (task_id, chat_id, message_id, created_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(task_id, message_id) DO NOTHING`
+
+	if _, err := s.execContext(ctx, q, taskID, chatID, messageID, time.Now().Unix()); err != nil {
+		return fmt.Errorf("登记监控待下载媒体失败: %w", err)
+	}
+	return nil
+}
+
+// ListMonitorPending 返回监控任务的待下载消息 id，按消息先后（message_id 升序）排列
+func (s *Store) ListMonitorPending(ctx context.Context, taskID string) ([]int64, error) {
+	const q = `SELECT message_id FROM monitor_pending WHERE task_id = ? ORDER BY message_id ASC`
+
+	rows, err := s.db.QueryContext(ctx, q, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("查询监控待下载媒体失败: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("解析监控待下载媒体失败: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("遍历监控待下载媒体失败: %w", err)
+	}
+	return ids, nil
+}
+
+// DeleteMonitorPending 移除一条已处理完毕的待下载登记（不存在时为无操作）
+func (s *Store) DeleteMonitorPending(ctx context.Context, taskID string, messageID int64) error {
+	if _, err := s.execContext(ctx,
+		`DELETE FROM monitor_pending WHERE task_id = ? AND message_id = ?`, taskID, messageID); err != nil {
+		return fmt.Errorf("移除监控待下载媒体失败: %w", err)
+	}
+	return nil
+}
+
+// ClearMonitorPending 清空监控任务的全部待下载登记（任务被用户停止时调用）
+func (s *Store) ClearMonitorPending(ctx context.Context, taskID string) error {
+	if _, err := s.execContext(ctx, `DELETE FROM monitor_pending WHERE task_id = ?`, taskID); err != nil {
+		return fmt.Errorf("清空监控待下载媒体失败: %w", err)
+	}
+	return nil
+}
@@ -41,6 +41,11 @@ func NewRecorder(s *Store) func(context.Context, downloader.RecordEvent) {
 				Status:    status,
 				UniqueID:  evt.Media.UniqueID,
 				AlbumID:   evt.Media.AlbumID,
+				StoryID:   evt.Media.StoryID,
+
+				Forwarded:        evt.Media.Forwarded,
+				ForwardFromID:    evt.Media.ForwardFromID,
+				ForwardMessageID: evt.Media.ForwardMessageID,
 			})
 		case downloader.RecordCompleted:
 			_ = s.UpdateHistoryResult(ctx, evt.Media.ChatID, evt.Media.MessageID, HistoryStatusCompleted, "", evt.FilePath)
@@ -49,3 +54,15 @@ func NewRecorder(s *Store) func(context.Context, downloader.RecordEvent) {
 		}
 	}
 }
+
+// NewDuplicateLookup 返回与 downloader 内容级去重回调兼容的查找函数：按 unique_id 返回最近一次
+// 完成下载的文件路径；查询出错按未命中处理（照常下载）
+func NewDuplicateLookup(s *Store) func(context.Context, string) (string, bool) {
+	return func(ctx context.Context, uniqueID string) (string, bool) {
+		rec, err := s.FindCompletedByUniqueID(ctx, uniqueID)
+		if err != nil || rec == nil {
+			return "", false
+		}
+		return rec.FilePath, true
+	}
+}
@@ -17,17 +17,18 @@ type ScheduleRow struct {
 	Enabled     bool       `json:"enabled"`
 	LastRun     *time.Time `json:"last_run,omitempty"`
 	CreatedAt   time.Time  `json:"created_at"`
+	Incremental bool       `json:"incremental"` // 触发增量任务：只扫描上次同步水位之后的新消息
 }
 
 // CreateSchedule 插入一条定时计划
 func (s *Store) CreateSchedule(ctx context.Context, r *ScheduleRow) error {
 	const q = `
-INSERT INTO schedules (id, chat_id, chat_title, interval_min, filters, enabled, last_run, created_at)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+INSERT INTO schedules (id, chat_id, chat_title, interval_min, filters, enabled, last_run, created_at, incremental)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 	_, err := s.execContext(ctx, q,
 		r.ID, r.ChatID, nullString(r.ChatTitle), r.IntervalMin, nullString(r.Filters),
-		r.Enabled, timePtrToUnix(r.LastRun), timeToUnix(r.CreatedAt),
+		r.Enabled, timePtrToUnix(r.LastRun), timeToUnix(r.CreatedAt), r.Incremental,
 	)
 	if err != nil {
 		return fmt.Errorf("创建定时计划失败: %w", err)
@@ -40,7 +41,7 @@ VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
 //nolint:dupl // 与 ListTasks 结构同形但行类型/扫描器不同，泛型化收益低于可读性损失
 func (s *Store) ListSchedules(ctx context.Context) ([]*ScheduleRow, error) {
 	const q = `
-SELECT id, chat_id, chat_title, interval_min, filters, enabled, last_run, created_at
+SELECT id, chat_id, chat_title, interval_min, filters, enabled, last_run, created_at, incremental
 FROM schedules ORDER BY created_at DESC`
 
 	rows, err := s.db.QueryContext(ctx, q)
@@ -97,7 +98,9 @@ func scanScheduleRow(row scanner) (*ScheduleRow, error) {
 		lastRun            sql.NullInt64
 		createdAt          int64
 	)
-	if err := row.Scan(&r.ID, &r.ChatID, &chatTitle, &r.IntervalMin, &filters, &r.Enabled, &lastRun, &createdAt); err != nil {
+	if err := row.Scan(
+		&r.ID, &r.ChatID, &chatTitle, &r.IntervalMin, &filters, &r.Enabled, &lastRun, &createdAt, &r.Incremental,
+	); err != nil {
 		return nil, err
 	}
 	r.ChatTitle = chatTitle.String
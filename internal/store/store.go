@@ -50,7 +50,20 @@ CREATE TABLE IF NOT EXISTS tasks (
   scan_cursor     INTEGER NOT NULL DEFAULT 0,
   attempts        INTEGER NOT NULL DEFAULT 0,
   filters         TEXT,
-  message_id      INTEGER NOT NULL DEFAULT 0
+  message_id      INTEGER NOT NULL DEFAULT 0,
+  last_message_id INTEGER NOT NULL DEFAULT 0,
+  incremental     INTEGER NOT NULL DEFAULT 0,
+  scan_segments   INTEGER NOT NULL DEFAULT 0,
+  scan_segment_state TEXT,
+  segment_by_date INTEGER NOT NULL DEFAULT 0,
+  oldest_first    INTEGER NOT NULL DEFAULT 0,
+  range_start     INTEGER NOT NULL DEFAULT 0,
+  range_end       INTEGER NOT NULL DEFAULT 0,
+  comments        INTEGER NOT NULL DEFAULT 0,
+  leave_after     INTEGER NOT NULL DEFAULT 0,
+  members         INTEGER NOT NULL DEFAULT 0,
+  search_messages TEXT,
+  search_query    TEXT
 );
 CREATE INDEX IF NOT EXISTS idx_tasks_status     ON tasks(status);
 CREATE INDEX IF NOT EXISTS idx_tasks_created_at ON tasks(created_at DESC);
@@ -72,6 +85,10 @@ CREATE TABLE IF NOT EXISTS history (
   finished_at INTEGER,
   unique_id   TEXT,
   album_id    INTEGER NOT NULL DEFAULT 0,
+  story_id    INTEGER NOT NULL DEFAULT 0,
+  forwarded   INTEGER NOT NULL DEFAULT 0,
+  forward_from_id    INTEGER NOT NULL DEFAULT 0,
+  forward_message_id INTEGER NOT NULL DEFAULT 0,
   UNIQUE(chat_id, message_id)
 );
 CREATE INDEX IF NOT EXISTS idx_history_media_type ON history(media_type);
@@ -88,7 +105,24 @@ CREATE TABLE IF NOT EXISTS schedules (
   filters      TEXT,
   enabled      INTEGER NOT NULL DEFAULT 1,
   last_run     INTEGER,
-  created_at   INTEGER NOT NULL
+  created_at   INTEGER NOT NULL,
+  incremental  INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS monitor_pending (
+  task_id    TEXT NOT NULL,
+  chat_id    INTEGER NOT NULL,
+  message_id INTEGER NOT NULL,
+  created_at INTEGER NOT NULL,
+  PRIMARY KEY (task_id, message_id)
+);
+
+CREATE TABLE IF NOT EXISTS chat_sync (
+  chat_id         INTEGER NOT NULL,
+  filter_key      TEXT NOT NULL DEFAULT '',
+  last_message_id INTEGER NOT NULL,
+  updated_at      INTEGER NOT NULL,
+  PRIMARY KEY (chat_id, filter_key)
 );
 `
 
@@ -137,6 +171,10 @@ func Open(path string) (*Store, error) {
 		_ = db.Close()
 		return nil, err
 	}
+	if err := addColumnIfMissing(context.Background(), db, "schedules", `incremental INTEGER NOT NULL DEFAULT 0`); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
 
 	return &Store{db: db}, nil
 }
@@ -158,6 +196,19 @@ func migrateTasksTable(ctx context.Context, db *sql.DB) error {
 		`attempts INTEGER NOT NULL DEFAULT 0`,
 		`filters TEXT`,
 		`message_id INTEGER NOT NULL DEFAULT 0`,
+		`last_message_id INTEGER NOT NULL DEFAULT 0`,
+		`incremental INTEGER NOT NULL DEFAULT 0`,
+		`scan_segments INTEGER NOT NULL DEFAULT 0`,
+		`scan_segment_state TEXT`,
+		`segment_by_date INTEGER NOT NULL DEFAULT 0`,
+		`oldest_first INTEGER NOT NULL DEFAULT 0`,
+		`range_start INTEGER NOT NULL DEFAULT 0`,
+		`range_end INTEGER NOT NULL DEFAULT 0`,
+		`comments INTEGER NOT NULL DEFAULT 0`,
+		`leave_after INTEGER NOT NULL DEFAULT 0`,
+		`members INTEGER NOT NULL DEFAULT 0`,
+		`search_messages TEXT`,
+		`search_query TEXT`,
 	} {
 		if err := addColumnIfMissing(ctx, db, "tasks", col); err != nil {
 			return err
@@ -170,13 +221,19 @@ func migrateTasksTable(ctx context.Context, db *sql.DB) error {
 	return nil
 }
 
-// migrateHistoryTable 为既有库补充 unique_id 列与索引，并将旧版中断原因归一为常量
+// migrateHistoryTable 为既有库补充 unique_id/album_id/story_id/转发来源列与索引，并将旧版中断原因归一为常量
 func migrateHistoryTable(ctx context.Context, db *sql.DB) error {
-	if err := addColumnIfMissing(ctx, db, "history", `unique_id TEXT`); err != nil {
-		return err
-	}
-	if err := addColumnIfMissing(ctx, db, "history", `album_id INTEGER NOT NULL DEFAULT 0`); err != nil {
-		return err
+	for _, col := range []string{
+		`unique_id TEXT`,
+		`album_id INTEGER NOT NULL DEFAULT 0`,
+		`story_id INTEGER NOT NULL DEFAULT 0`,
+		`forwarded INTEGER NOT NULL DEFAULT 0`,
+		`forward_from_id INTEGER NOT NULL DEFAULT 0`,
+		`forward_message_id INTEGER NOT NULL DEFAULT 0`,
+	} {
+		if err := addColumnIfMissing(ctx, db, "history", col); err != nil {
+			return err
+		}
 	}
 	if _, err := db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS idx_history_unique_id ON history(unique_id)`); err != nil {
 		return fmt.Errorf("创建 history unique_id 索引失败: %w", err)
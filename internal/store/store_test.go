@@ -80,6 +80,7 @@ func TestTaskCRUDRoundTrip(t *testing.T) {
 	if err := s.UpdateTaskProgress(ctx, "task-1", TaskProgress{
 		Total: 10, Downloaded: 6, Failed: 2, Skipped: 2,
 		TotalSize: 1000, DownloadedSize: 600, ExpectedTotal: 50, ScanCursor: 12345, Attempts: 1,
+		SegmentState: `[{"high":200,"low":100,"cursor":150}]`,
 	}); err != nil {
 		t.Fatalf("UpdateTaskProgress() error = %v", err)
 	}
@@ -90,6 +91,9 @@ func TestTaskCRUDRoundTrip(t *testing.T) {
 	if got.ExpectedTotal != 50 || got.ScanCursor != 12345 || got.Attempts != 1 {
 		t.Fatalf("ExpectedTotal/ScanCursor/Attempts = %d/%d/%d, want 50/12345/1", got.ExpectedTotal, got.ScanCursor, got.Attempts)
 	}
+	if got.SegmentState != `[{"high":200,"low":100,"cursor":150}]` {
+		t.Fatalf("SegmentState = %q, want 分段游标原样落库", got.SegmentState)
+	}
 
 	if err := s.UpdateTaskStatus(ctx, "task-1", TaskStatusFailed, "网络错误"); err != nil {
 		t.Fatalf("UpdateTaskStatus(failed) error = %v", err)
@@ -294,6 +298,61 @@ func TestUpsertHistoryStart_DoesNotRegressTerminalStatus(t *testing.T) {
 	}
 }
 
+// TestUpsertHistoryStart_StoryRowsCoexistWithMessages 校验快拍行（message_id 取 story id 相反数）
+// 与同一聊天同号消息互不覆盖，并回读 story_id
+func TestUpsertHistoryStart_StoryRowsCoexistWithMessages(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	for _, rec := range []*HistoryRecord{
+		{ChatID: 100, MessageID: 5, MediaType: "photo", FileName: "a.jpg", FilePath: "/tmp/a.jpg", Status: HistoryStatusDownloading},
+		{ChatID: 100, MessageID: -5, StoryID: 5, MediaType: "video", FileName: "s.mp4", FilePath: "/tmp/s.mp4", Status: HistoryStatusDownloading},
+	} {
+		if err := s.UpsertHistoryStart(ctx, rec); err != nil {
+			t.Fatalf("UpsertHistoryStart() error = %v", err)
+		}
+	}
+
+	items, total, err := s.QueryHistory(ctx, &HistoryFilter{ChatID: 100})
+	if err != nil {
+		t.Fatalf("QueryHistory() error = %v", err)
+	}
+	if total != 2 {
+		t.Fatalf("expected 2 history rows, got %d", total)
+	}
+	var stories int
+	for _, it := range items {
+		if it.StoryID != 0 {
+			stories++
+			if it.StoryID != 5 || it.MessageID != -5 {
+				t.Errorf("story row = (story_id %d, message_id %d), want (5, -5)", it.StoryID, it.MessageID)
+			}
+		}
+	}
+	if stories != 1 {
+		t.Errorf("story rows = %d, want 1", stories)
+	}
+}
+
+// TestUpsertHistoryStart_RecordsForwardOrigin 校验转发来源随历史行写入并可回读
+func TestUpsertHistoryStart_RecordsForwardOrigin(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	rec := &HistoryRecord{ChatID: 200, MessageID: 7, MediaType: "video", FileName: "f.mp4", FilePath: "/tmp/f.mp4",
+		Status: HistoryStatusDownloading, Forwarded: true, ForwardFromID: -1001, ForwardMessageID: 42}
+	if err := s.UpsertHistoryStart(ctx, rec); err != nil {
+		t.Fatalf("UpsertHistoryStart() error = %v", err)
+	}
+	items, _, err := s.QueryHistory(ctx, &HistoryFilter{ChatID: 200})
+	if err != nil || len(items) != 1 {
+		t.Fatalf("QueryHistory() = %d items, err %v", len(items), err)
+	}
+	if got := items[0]; !got.Forwarded || got.ForwardFromID != -1001 || got.ForwardMessageID != 42 {
+		t.Errorf("forward origin = (%v, %d, %d), want (true, -1001, 42)", got.Forwarded, got.ForwardFromID, got.ForwardMessageID)
+	}
+}
+
 func TestUpdateHistoryResultNotFound(t *testing.T) {
 	s := newTestStore(t)
 	err := s.UpdateHistoryResult(context.Background(), 999, 1, HistoryStatusFailed, "未知错误", "")
@@ -509,3 +568,72 @@ func TestHistoryStats(t *testing.T) {
 		t.Fatalf("HistoryStats(chat=2) total count = %d, want 2", totalCount)
 	}
 }
+
+func TestMonitorPendingLifecycle(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	for _, id := range []int64{30, 10, 20, 10} {
+		if err := s.AddMonitorPending(ctx, "mon-1", 7, id); err != nil {
+			t.Fatalf("AddMonitorPending(%d) error = %v", id, err)
+		}
+	}
+	if err := s.AddMonitorPending(ctx, "mon-2", 8, 10); err != nil {
+		t.Fatalf("AddMonitorPending(mon-2) error = %v", err)
+	}
+
+	ids, err := s.ListMonitorPending(ctx, "mon-1")
+	if err != nil {
+		t.Fatalf("ListMonitorPending() error = %v", err)
+	}
+	if len(ids) != 3 || ids[0] != 10 || ids[1] != 20 || ids[2] != 30 {
+		t.Fatalf("ListMonitorPending() = %v, want [10 20 30]（去重且按消息升序）", ids)
+	}
+
+	if err := s.DeleteMonitorPending(ctx, "mon-1", 20); err != nil {
+		t.Fatalf("DeleteMonitorPending() error = %v", err)
+	}
+	if ids, _ = s.ListMonitorPending(ctx, "mon-1"); len(ids) != 2 {
+		t.Fatalf("删除后 ListMonitorPending() = %v, want 2 项", ids)
+	}
+
+	if err := s.ClearMonitorPending(ctx, "mon-1"); err != nil {
+		t.Fatalf("ClearMonitorPending() error = %v", err)
+	}
+	if ids, _ = s.ListMonitorPending(ctx, "mon-1"); len(ids) != 0 {
+		t.Fatalf("清空后 ListMonitorPending() = %v, want 空", ids)
+	}
+	if ids, _ = s.ListMonitorPending(ctx, "mon-2"); len(ids) != 1 {
+		t.Fatalf("清空 mon-1 不应影响 mon-2, got %v", ids)
+	}
+}
+
+func TestChatWatermarkOnlyAdvances(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	if got, err := s.GetChatWatermark(ctx, 5, ""); err != nil || got != 0 {
+		t.Fatalf("未同步聊天 GetChatWatermark() = %d, %v, want 0, nil", got, err)
+	}
+	for _, id := range []int64{100, 80, 150} {
+		if err := s.AdvanceChatWatermark(ctx, 5, "", id); err != nil {
+			t.Fatalf("AdvanceChatWatermark(%d) error = %v", id, err)
+		}
+	}
+	if got, _ := s.GetChatWatermark(ctx, 5, ""); got != 150 {
+		t.Fatalf("GetChatWatermark() = %d, want 150（只增不减）", got)
+	}
+	if got, _ := s.GetChatWatermark(ctx, 6, ""); got != 0 {
+		t.Fatalf("其它聊天水位应为 0, got %d", got)
+	}
+	// 不同过滤条件的水位互相独立
+	if err := s.AdvanceChatWatermark(ctx, 5, `{"media_types":["photo"]}`, 90); err != nil {
+		t.Fatalf("AdvanceChatWatermark(filtered) error = %v", err)
+	}
+	if got, _ := s.GetChatWatermark(ctx, 5, `{"media_types":["photo"]}`); got != 90 {
+		t.Fatalf("带过滤条件的水位 = %d, want 90", got)
+	}
+	if got, _ := s.GetChatWatermark(ctx, 5, ""); got != 150 {
+		t.Fatalf("带过滤条件的水位不应影响无过滤水位, got %d", got)
+	}
+}
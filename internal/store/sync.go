@@ -0,0 +1,39 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// GetChatWatermark 返回聊天在过滤条件 filterKey（downloader.HistoryFilters.SyncKey，空串 = 不过滤）下的
+// 增量同步水位（已完整处理过的最新消息 id），从未同步过时返回 0
+func (s *Store) GetChatWatermark(ctx context.Context, chatID int64, filterKey string) (int64, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT last_message_id FROM chat_sync WHERE chat_id = ? AND filter_key = ?`, chatID, filterKey).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("查询聊天同步水位失败: %w", err)
+	}
+	return id, nil
+}
+
+// AdvanceChatWatermark 将聊天在过滤条件 filterKey 下的增量同步水位推进到 messageID；只增不减，较旧的值为无操作
+func (s *Store) AdvanceChatWatermark(ctx context.Context, chatID int64, filterKey string, messageID int64) error {
+	const q = `
+INSERT INTO chat_sync (chat_id, filter_key, last_message_id, updated_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(chat_id, filter_key) DO UPDATE SET
+  last_message_id = excluded.last_message_id,
+  updated_at      = excluded.updated_at
+WHERE excluded.last_message_id > chat_sync.last_message_id`
+
+	if _, err := s.execContext(ctx, q, chatID, filterKey, messageID, time.Now().Unix()); err != nil {
+		return fmt.Errorf("更新聊天同步水位失败: %w", err)
+	}
+	return nil
+}
@@ -19,14 +19,19 @@ func (s *Store) CreateTask(ctx context.Context, t *TaskRow) error {
 	const q = `
 INSERT INTO tasks (id, kind, chat_id, chat_title, status, created_at, started_at, finished_at,
                     error, total, downloaded, failed, skipped, total_size, downloaded_size, expected_total,
-                    scan_cursor, attempts, filters, message_id)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+                    scan_cursor, attempts, filters, message_id, last_message_id, incremental, scan_segments,
+                    scan_segment_state, segment_by_date, oldest_first, range_start, range_end, comments,
+                    leave_after, members, search_messages, search_query)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?,
+        ?, ?, ?)`
 
 	_, err := s.execContext(ctx, q,
 		t.ID, t.Kind, t.ChatID, t.ChatTitle, t.Status, timeToUnix(t.CreatedAt),
 		timePtrToUnix(t.StartedAt), timePtrToUnix(t.FinishedAt), nullString(t.Error),
 		t.Total, t.Downloaded, t.Failed, t.Skipped, t.TotalSize, t.DownloadedSize, t.ExpectedTotal,
-		t.ScanCursor, t.Attempts, nullString(t.Filters), t.MessageID,
+		t.ScanCursor, t.Attempts, nullString(t.Filters), t.MessageID, t.LastMessageID, t.Incremental,
+		t.ScanSegments, nullString(t.SegmentState), t.SegmentByDate, t.OldestFirst, t.RangeStart, t.RangeEnd,
+		t.Comments, t.LeaveAfter, t.Members, nullString(t.SearchMessages), nullString(t.SearchQuery),
 	)
 	if err != nil {
 		return fmt.Errorf("创建任务失败: %w", err)
@@ -62,23 +67,35 @@ type TaskProgress struct {
 	TotalSize, DownloadedSize          int64
 	ExpectedTotal, ScanCursor          int64
 	Attempts                           int
+	LastMessageID                      int64
+	SegmentState                       string // 分段扫描各段游标 JSON，空串落库为 NULL
 }
 
-// UpdateTaskProgress 更新任务的进度统计与扫描游标
+// UpdateTaskProgress 更新任务的进度统计、扫描游标（含分段游标）与监控水位
 func (s *Store) UpdateTaskProgress(ctx context.Context, id string, p TaskProgress) error {
 	const q = `
 UPDATE tasks SET total = ?, downloaded = ?, failed = ?, skipped = ?, total_size = ?, downloaded_size = ?,
-  expected_total = ?, scan_cursor = ?, attempts = ?
+  expected_total = ?, scan_cursor = ?, attempts = ?, last_message_id = ?, scan_segment_state = ?
 WHERE id = ?`
 
 	res, err := s.execContext(ctx, q, p.Total, p.Downloaded, p.Failed, p.Skipped, p.TotalSize,
-		p.DownloadedSize, p.ExpectedTotal, p.ScanCursor, p.Attempts, id)
+		p.DownloadedSize, p.ExpectedTotal, p.ScanCursor, p.Attempts, p.LastMessageID,
+		nullString(p.SegmentState), id)
 	if err != nil {
 		return fmt.Errorf("更新任务进度失败: %w", err)
 	}
 	return checkRowsAffected(res, "任务", id)
 }
 
+// UpdateTaskFilters 更新任务的过滤条件（JSON；空串落库为 NULL，即不过滤）
+func (s *Store) UpdateTaskFilters(ctx context.Context, id, filters string) error {
+	res, err := s.execContext(ctx, `UPDATE tasks SET filters = ? WHERE id = ?`, nullString(filters), id)
+	if err != nil {
+		return fmt.Errorf("更新任务过滤条件失败: %w", err)
+	}
+	return checkRowsAffected(res, "任务", id)
+}
+
 // ListTasks 返回全部任务，按创建时间倒序排列
 //
 //nolint:dupl // 与 ListSchedules 结构同形但行类型/扫描器不同，泛型化收益低于可读性损失
@@ -86,7 +103,9 @@ func (s *Store) ListTasks(ctx context.Context) ([]*TaskRow, error) {
 	const q = `
 SELECT id, kind, chat_id, chat_title, status, created_at, started_at, finished_at,
        error, total, downloaded, failed, skipped, total_size, downloaded_size, expected_total,
-       scan_cursor, attempts, filters, message_id
+       scan_cursor, attempts, filters, message_id, last_message_id, incremental, scan_segments,
+       scan_segment_state, segment_by_date, oldest_first, range_start, range_end, comments,
+       leave_after, members, search_messages, search_query
 FROM tasks ORDER BY created_at DESC`
 
 	rows, err := s.db.QueryContext(ctx, q)
@@ -114,7 +133,9 @@ func (s *Store) GetTask(ctx context.Context, id string) (*TaskRow, error) {
 	const q = `
 SELECT id, kind, chat_id, chat_title, status, created_at, started_at, finished_at,
        error, total, downloaded, failed, skipped, total_size, downloaded_size, expected_total,
-       scan_cursor, attempts, filters, message_id
+       scan_cursor, attempts, filters, message_id, last_message_id, incremental, scan_segments,
+       scan_segment_state, segment_by_date, oldest_first, range_start, range_end, comments,
+       leave_after, members, search_messages, search_query
 FROM tasks WHERE id = ?`
 
 	row := s.db.QueryRowContext(ctx, q, id)
@@ -135,12 +156,16 @@ func scanTaskRow(row scanner) (*TaskRow, error) {
 		createdAt                  int64
 		startedAt, finishedAt      sql.NullInt64
 		errMsg, chatTitle, filters sql.NullString
+		segmentState, searchMsgs   sql.NullString
+		searchQuery                sql.NullString
 	)
 
 	if err := row.Scan(
 		&t.ID, &t.Kind, &t.ChatID, &chatTitle, &t.Status, &createdAt, &startedAt, &finishedAt,
 		&errMsg, &t.Total, &t.Downloaded, &t.Failed, &t.Skipped, &t.TotalSize, &t.DownloadedSize,
-		&t.ExpectedTotal, &t.ScanCursor, &t.Attempts, &filters, &t.MessageID,
+		&t.ExpectedTotal, &t.ScanCursor, &t.Attempts, &filters, &t.MessageID, &t.LastMessageID, &t.Incremental,
+		&t.ScanSegments, &segmentState, &t.SegmentByDate, &t.OldestFirst, &t.RangeStart, &t.RangeEnd,
+		&t.Comments, &t.LeaveAfter, &t.Members, &searchMsgs, &searchQuery,
 	); err != nil {
 		return nil, err
 	}
@@ -148,6 +173,9 @@ func scanTaskRow(row scanner) (*TaskRow, error) {
 	t.ChatTitle = chatTitle.String
 	t.Error = errMsg.String
 	t.Filters = filters.String
+	t.SearchMessages = searchMsgs.String
+	t.SearchQuery = searchQuery.String
+	t.SegmentState = segmentState.String
 	t.CreatedAt = unixToTime(createdAt)
 	t.StartedAt = nullInt64ToTimePtr(startedAt)
 	t.FinishedAt = nullInt64ToTimePtr(finishedAt)
@@ -24,6 +24,19 @@ type TaskRow struct {
 	Attempts       int    // 自动重试已消耗的次数
 	Filters        string // 任务级过滤器 JSON（downloader.HistoryFilters），空 = 不过滤
 	MessageID      int64  // 单消息下载任务的目标消息 id，0 = 整聊天历史任务
+	LastMessageID  int64  // monitor：已见到的最新消息 id（离线补扫水位）；history：开扫时聊天最新消息 id（完成后记为聊天水位）；0 = 未知
+	Incremental    bool   // 是否为增量模式（history 任务扫描到聊天水位即停止）
+	ScanSegments   int    // 分段并行扫描的段数（0/1 = 串行，0 时沿用配置）
+	SegmentState   string // 分段扫描各段的区间与续扫游标 JSON（[]downloader.ScanSegment），空 = 未分段
+	SegmentByDate  bool   // 分段按日期区间等分（需设置 date_from），否则按消息 id 等分
+	OldestFirst    bool   // 正序模式：由旧到新翻页下载
+	RangeStart     int64  // 消息区间任务的起点消息 id（0 = 不限/整聊天）
+	RangeEnd       int64  // 消息区间任务的终点消息 id（0 = 不限/整聊天）
+	Comments       bool   // 频道评论：同时下载各帖子评论区（讨论组回复）的媒体
+	LeaveAfter     bool   // 任务完成后退出该聊天（经邀请链接加入时设置）
+	Members        bool   // 头像任务同时下载成员的个人头像
+	SearchMessages string // 跨聊天搜索任务所选消息 JSON，空串落库为 NULL
+	SearchQuery    string // 聊天内关键词/话题标签搜索（history），空串落库为 NULL
 }
 
 // 任务状态常量，取值与 internal/queue 的 Status 保持一致（queue 为唯一词汇源）
@@ -53,6 +66,12 @@ type HistoryRecord struct {
 	FinishedAt *time.Time
 	UniqueID   string // TDLib remote file unique_id，跨聊天稳定，用于内容级去重
 	AlbumID    int64  // Telegram 相册 id（media_album_id），0 = 不属于相册
+	StoryID    int64  // 快拍 id，0 = 非快拍；快拍行的 MessageID 为其相反数（见 downloader.StoryMessageID）
+
+	// 转发来源（见 downloader.MediaInfo 同名字段）
+	Forwarded        bool
+	ForwardFromID    int64
+	ForwardMessageID int64
 }
 
 // 下载历史状态常量，取值与 downloader.RecordStatus 保持一致
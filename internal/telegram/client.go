@@ -10,10 +10,14 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode"
 
 	tdclient "github.com/zelenin/go-tdlib/client"
 
@@ -43,6 +47,14 @@ const (
 	chatLoadBatch = 100
 	// maxChatLimit is the upper bound passed to getChats (returns all cached chats).
 	maxChatLimit = 1 << 20
+	// maxForumTopicPage is the per-call getForumTopics page size (TDLib caps it at 100).
+	maxForumTopicPage = 100
+	// maxForumTopics bounds how many topics ForumTopics pages through.
+	maxForumTopics = 5000
+	// maxProfilePhotoPage is the per-call getUserProfilePhotos page size (TDLib caps it at 100).
+	maxProfilePhotoPage = 100
+	// maxMemberPage is the per-call getSupergroupMembers page size (TDLib caps it at 200).
+	maxMemberPage = 200
 	// tdlibLogVerbosity keeps TDLib's own logging quiet (1 = errors only).
 	tdlibLogVerbosity = 1
 
@@ -61,6 +73,9 @@ const (
 	emptyHistorySleep = 1 * time.Second
 	// maxEmptyHistorySleep caps the progressive empty-page backoff.
 	maxEmptyHistorySleep = 5 * time.Second
+	// emptyThreadRetries is how many empty first pages a comment thread may return
+	// before it is treated as empty. Kept small: it is paid once per commented post.
+	emptyThreadRetries = 2
 	// scanLogInterval spaces out history-scan progress log lines so long
 	// media-sparse stretches still show visible activity without log spam.
 	scanLogInterval = 15 * time.Second
@@ -71,6 +86,9 @@ const (
 	mediaTypeAnimation = "animation"
 	mediaTypeAudio     = "audio"
 	mediaTypeVoice     = "voice"
+	mediaTypeVideoNote = "video_note"
+	mediaTypeSticker   = "sticker"
+	mediaTypeAvatar    = "avatar"
 
 	copyBufferSize = 1 << 20 // 1MB copy buffer for cross-device fallback
 
@@ -103,6 +121,16 @@ type ChatInfo struct {
 	ID    int64  `json:"id"`
 	Title string `json:"title"`
 	Type  string `json:"type"`
+	// IsForum 为 true 表示启用了话题的论坛超级群组，可经 ForumTopics 列出话题
+	IsForum bool `json:"is_forum,omitempty"`
+}
+
+// ForumTopic 是论坛超级群组中的一个话题
+type ForumTopic struct {
+	ID        int64  `json:"id"` // forum_topic_id，作为任务过滤器的 topic_id
+	Name      string `json:"name"`
+	IsGeneral bool   `json:"is_general,omitempty"`
+	IsClosed  bool   `json:"is_closed,omitempty"`
 }
 
 // Client 是基于 TDLib 的 Telegram 客户端包装器
@@ -115,8 +143,8 @@ type Client struct {
 	dbDir    string // TDLib 数据库/会话目录
 	filesDir string // TDLib 文件缓存目录（与下载目录同盘，便于 rename）
 
-	targetChatID  atomic.Int64 // 实时监控目标（0 = 不监控）
-	monitorTaskID atomic.Value // 实时监控关联的任务ID（string，""=无关联任务）
+	monitorMu sync.RWMutex
+	monitors  map[string]monitorEntry // 实时监控任务ID -> 目标聊天与过滤条件（任务ID "" 表示无关联任务的 CLI 监控）
 
 	mu       sync.Mutex
 	td       *tdclient.Client // Connect 后才有值
@@ -127,7 +155,25 @@ type Client struct {
 	trackMu   sync.Mutex
 	fileTrack map[int32]*fileProgress // TDLib file id -> 进度信息（用于日志）
 
+	topicMu    sync.Mutex
+	topicNames map[topicKey]string // (聊天, 话题) -> 话题名称缓存（元数据 sidecar 用）
+
 	scanProgressFunc func(taskID string, scannedMessages, foundMedia, scanCursor int64) // 历史扫描进度回调（启动时注册，无并发写）
+	monitorMediaFunc func(taskID string, chatID, messageID int64)                       // 监控新媒体投递回调（启动时注册，无并发写）
+	connReadyFunc    func()                                                             // 连接就绪回调（启动时注册，无并发写）
+	segmentFunc      func(taskID string, segments []downloader.ScanSegment)             // 分段扫描状态回调（启动时注册，无并发写）
+}
+
+// monitorEntry 是一个实时监控任务在客户端侧的登记项
+type monitorEntry struct {
+	chatID int64
+	match  *downloader.MediaMatcher // 任务过滤器的预编译形式，登记时编译一次；nil = 不过滤
+}
+
+// topicKey 标识一个论坛话题
+type topicKey struct {
+	chatID  int64
+	topicID int64
 }
 
 // fileProgress 跟踪单个文件的下载进度（仅用于日志输出）
@@ -149,23 +195,28 @@ func NewWithUpdates(cfg *config.Config, log *logger.Logger, chatID int64) *Clien
 
 func newClient(cfg *config.Config, log *logger.Logger, chatID int64) *Client {
 	c := &Client{
-		config:    cfg,
-		logger:    log,
-		dbDir:     filepath.Join(cfg.Session.Dir, "tdlib"),
-		filesDir:  filepath.Join(cfg.Download.Path, ".tdlib-files"),
-		fileTrack: make(map[int32]*fileProgress),
+		config:     cfg,
+		logger:     log,
+		dbDir:      filepath.Join(cfg.Session.Dir, "tdlib"),
+		filesDir:   filepath.Join(cfg.Download.Path, ".tdlib-files"),
+		fileTrack:  make(map[int32]*fileProgress),
+		monitors:   make(map[string]monitorEntry),
+		topicNames: make(map[topicKey]string),
 		retrier: retry.NewDefault(log).
 			WithMaxRetries(cfg.Retry.MaxRetries).
 			WithBaseDelay(time.Duration(cfg.Retry.BaseDelay) * time.Second).
 			WithMaxDelay(time.Duration(cfg.Retry.MaxDelay) * time.Second),
 	}
-	c.targetChatID.Store(chatID)
-	c.monitorTaskID.Store("")
+	if chatID != 0 {
+		c.monitors[""] = monitorEntry{chatID: chatID}
+	}
 	c.downloader = downloader.New(cfg.Download.Path, cfg.Download.MaxConcurrent, log)
 	c.downloader.SetDownloadFunc(c.DownloadFile)
 	c.downloader.SetPauseFunc(c.pauseDownloadFile)
 	c.downloader.SetClassifyByType(!cfg.Download.DisableClassifyByType)
 	c.downloader.SetSaveMetadata(cfg.Download.SaveMetadata)
+	c.downloader.SetTopicDirs(cfg.Download.TopicDirs)
+	c.downloader.SetTopicNameFunc(c.topicName)
 	return c
 }
 
@@ -417,22 +468,58 @@ func scanlnPassword(_ context.Context) (string, error) {
 
 // --- 监控目标 / 统计 / 会话 ---
 
-// SetTargetChat 设置实时监控目标（0 表示停止监控）
-func (c *Client) SetTargetChat(chatID int64) { c.targetChatID.Store(chatID) }
+// MonitorTarget 是一个实时监控任务与其目标聊天的关联
+type MonitorTarget struct {
+	TaskID string `json:"task_id"`
+	ChatID int64  `json:"chat_id"`
+}
+
+// AddMonitorTask 登记一个实时监控任务及其过滤条件；同一 taskID 重复登记时覆盖原有登记（用于运行中修改过滤器）
+func (c *Client) AddMonitorTask(taskID string, chatID int64, filters downloader.HistoryFilters) {
+	c.monitorMu.Lock()
+	c.monitors[taskID] = monitorEntry{chatID: chatID, match: filters.Matcher()}
+	c.monitorMu.Unlock()
+}
 
-// TargetChat 返回当前监控目标聊天ID
-func (c *Client) TargetChat() int64 { return c.targetChatID.Load() }
+// RemoveMonitorTask 注销一个实时监控任务（未登记时为无操作）
+func (c *Client) RemoveMonitorTask(taskID string) {
+	c.monitorMu.Lock()
+	delete(c.monitors, taskID)
+	c.monitorMu.Unlock()
+}
 
-// SetMonitorTask 设置当前监控任务ID并切换监控目标会话；taskID 为空字符串表示当前无关联任务
-func (c *Client) SetMonitorTask(taskID string, chatID int64) {
-	c.monitorTaskID.Store(taskID)
-	c.SetTargetChat(chatID)
+// Monitors 返回当前全部实时监控关联，按聊天ID、任务ID排序
+func (c *Client) Monitors() []MonitorTarget {
+	c.monitorMu.RLock()
+	out := make([]MonitorTarget, 0, len(c.monitors))
+	for taskID, e := range c.monitors {
+		out = append(out, MonitorTarget{TaskID: taskID, ChatID: e.chatID})
+	}
+	c.monitorMu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ChatID != out[j].ChatID {
+			return out[i].ChatID < out[j].ChatID
+		}
+		return out[i].TaskID < out[j].TaskID
+	})
+	return out
 }
 
-// monitorTask 返回当前监控关联的任务ID
-func (c *Client) monitorTask() string {
-	taskID, _ := c.monitorTaskID.Load().(string)
-	return taskID
+// monitorTasksFor 返回监控指定聊天的全部任务ID及其过滤匹配器
+func (c *Client) monitorTasksFor(chatID int64) map[string]*downloader.MediaMatcher {
+	c.monitorMu.RLock()
+	defer c.monitorMu.RUnlock()
+	var out map[string]*downloader.MediaMatcher
+	for taskID, e := range c.monitors {
+		if e.chatID != chatID {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]*downloader.MediaMatcher)
+		}
+		out[taskID] = e.match
+	}
+	return out
 }
 
 // Stats 返回下载统计快照
@@ -463,6 +550,11 @@ func (c *Client) AllMediaPaused() bool { return c.downloader.AllPaused() }
 // DownloadSpeed 返回当前聚合下载速度（字节/秒）。
 func (c *Client) DownloadSpeed() int64 { return c.downloader.SpeedBps() }
 
+// TaskTransfer 返回任务的实时下载速度与在途媒体的剩余字节数。
+func (c *Client) TaskTransfer(taskID string) downloader.TaskTransfer {
+	return c.downloader.TaskTransfer(taskID)
+}
+
 // DownloadConcurrency 返回当前媒体文件并发下载数量。
 func (c *Client) DownloadConcurrency() int { return c.downloader.MaxConcurrent() }
 
@@ -501,6 +593,24 @@ func (c *Client) SetScanProgressFunc(fn func(taskID string, scannedMessages, fou
 	c.scanProgressFunc = fn
 }
 
+// SetMonitorMediaFunc 设置监控新媒体投递回调；须在 Connect/任务运行前注册。
+// 注册后监控命中的媒体不再直接下载，而是以 (任务ID, 聊天ID, 消息ID) 交由回调方排队
+func (c *Client) SetMonitorMediaFunc(fn func(taskID string, chatID, messageID int64)) {
+	c.monitorMediaFunc = fn
+}
+
+// SetSegmentProgressFunc 设置分段扫描状态回调：分段规划完成及任一段游标推进/扫完时上报全部分段快照，
+// 供任务持久化后按段续扫；须在任务运行前注册
+func (c *Client) SetSegmentProgressFunc(fn func(taskID string, segments []downloader.ScanSegment)) {
+	c.segmentFunc = fn
+}
+
+// SetConnectionReadyFunc 设置 TDLib 连接就绪（含断线重连）回调；须在 Connect 前注册。
+// 回调在独立 goroutine 中执行，不阻塞 TDLib 更新接收
+func (c *Client) SetConnectionReadyFunc(fn func()) {
+	c.connReadyFunc = fn
+}
+
 // SetRecordFunc 设置下载记录回调，用于持久化下载历史
 func (c *Client) SetRecordFunc(fn func(context.Context, downloader.RecordEvent)) {
 	c.downloader.SetRecordFunc(fn)
@@ -598,7 +708,9 @@ func (c *Client) Logout(ctx context.Context) error {
 	c.mu.Unlock()
 
 	_ = os.RemoveAll(c.dbDir)
-	c.SetTargetChat(0)
+	c.monitorMu.Lock()
+	clear(c.monitors)
+	c.monitorMu.Unlock()
 	c.logger.Info("已退出登录，会话已销毁")
 	return c.ClearPhone()
 }
@@ -642,6 +754,7 @@ func (c *Client) GetChats(ctx context.Context) ([]ChatInfo, error) {
 			continue
 		}
 		if info := chatInfoOf(chat); info != nil {
+			info.IsForum = c.isForum(ctx, td, chat)
 			result = append(result, *info)
 		}
 	}
@@ -701,6 +814,106 @@ func chatInfoOf(chat *tdclient.Chat) *ChatInfo {
 	}
 }
 
+// isForum 报告聊天是否为启用了话题的论坛超级群组；查询失败按非论坛处理
+func (c *Client) isForum(ctx context.Context, td *tdclient.Client, chat *tdclient.Chat) bool {
+	sg, ok := chat.Type.(*tdclient.ChatTypeSupergroup)
+	if !ok || sg.IsChannel {
+		return false
+	}
+	info, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.Supergroup, error) {
+		return td.GetSupergroup(cc, &tdclient.GetSupergroupRequest{SupergroupId: sg.SupergroupId})
+	})
+	return err == nil && info.IsForum
+}
+
+// ForumTopics 列出论坛超级群组的全部话题（按 TDLib 话题列表顺序）
+func (c *Client) ForumTopics(ctx context.Context, chatID int64) ([]ForumTopic, error) {
+	td := c.client()
+	if td == nil {
+		return nil, errors.New("TDLib 未连接")
+	}
+	var (
+		out     []ForumTopic
+		req     = &tdclient.GetForumTopicsRequest{ChatId: chatID, Limit: maxForumTopicPage}
+		seen    = make(map[int64]bool)
+		fetched int
+	)
+	for fetched < maxForumTopics {
+		page, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.ForumTopics, error) {
+			return td.GetForumTopics(cc, req)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("获取聊天 %d 的话题列表失败: %w", chatID, err)
+		}
+		added := 0
+		for _, t := range page.Topics {
+			if t.Info == nil || seen[int64(t.Info.ForumTopicId)] {
+				continue
+			}
+			id := int64(t.Info.ForumTopicId)
+			seen[id] = true
+			added++
+			out = append(out, ForumTopic{ID: id, Name: t.Info.Name, IsGeneral: t.Info.IsGeneral, IsClosed: t.Info.IsClosed})
+			c.cacheTopicName(chatID, id, t.Info.Name)
+		}
+		fetched += len(page.Topics)
+		if added == 0 || page.NextOffsetForumTopicId == 0 {
+			break
+		}
+		req.OffsetDate = page.NextOffsetDate
+		req.OffsetMessageId = page.NextOffsetMessageId
+		req.OffsetForumTopicId = page.NextOffsetForumTopicId
+	}
+	return out, nil
+}
+
+// topicName 返回话题名称（供下载器写入元数据 sidecar），优先读缓存；查询失败返回空串
+func (c *Client) topicName(chatID, topicID int64) string {
+	key := topicKey{chatID: chatID, topicID: topicID}
+	c.topicMu.Lock()
+	name, ok := c.topicNames[key]
+	c.topicMu.Unlock()
+	if ok {
+		return name
+	}
+	td := c.client()
+	if td == nil {
+		return ""
+	}
+	topic, err := tdCall(context.Background(), metadataTimeout, func(cc context.Context) (*tdclient.ForumTopic, error) {
+		return td.GetForumTopic(cc, &tdclient.GetForumTopicRequest{ChatId: chatID, ForumTopicId: int32(topicID)})
+	})
+	if err != nil || topic.Info == nil {
+		c.logger.Warn("获取话题 %d 名称失败: %v", topicID, err)
+		return ""
+	}
+	c.cacheTopicName(chatID, topicID, topic.Info.Name)
+	return topic.Info.Name
+}
+
+// cacheTopicName 记录话题名称；话题改名后新文件的 sidecar 随之记录新名称
+func (c *Client) cacheTopicName(chatID, topicID int64, name string) {
+	c.topicMu.Lock()
+	c.topicNames[topicKey{chatID: chatID, topicID: topicID}] = name
+	c.topicMu.Unlock()
+}
+
+// forumTopicOf 返回消息所属的论坛话题 id（非论坛消息为 0）
+func forumTopicOf(m *tdclient.Message) int64 {
+	if t, ok := m.TopicId.(*tdclient.MessageTopicForum); ok {
+		return int64(t.ForumTopicId)
+	}
+	return 0
+}
+
+// topicFilter 返回话题限定对应的 TDLib MessageTopic（0 = 不限定，返回 nil）
+func topicFilter(topicID int64) tdclient.MessageTopic {
+	if topicID == 0 {
+		return nil
+	}
+	return &tdclient.MessageTopicForum{ForumTopicId: int32(topicID)}
+}
+
 // isNoMoreChats 判断 LoadChats 是否因列表已耗尽返回 404
 func isNoMoreChats(err error) bool {
 	var re tdclient.ResponseError
@@ -721,9 +934,46 @@ func (c *Client) extractMediaInfo(m *tdclient.Message) *downloader.MediaInfo {
 	mi.AlbumID = int64(m.MediaAlbumId)
 	mi.Caption = captionText(m.Content)
 	mi.SenderID = senderID(m.SenderId)
+	mi.TopicID = forumTopicOf(m)
+	if m.ForwardInfo != nil {
+		mi.Forwarded = true
+		mi.ForwardFromID, mi.ForwardMessageID = forwardOrigin(m.ForwardInfo.Origin)
+	}
+	if info := m.InteractionInfo; info != nil {
+		mi.ViewCount = info.ViewCount
+		mi.ForwardCount = info.ForwardCount
+		mi.ReactionCount = reactionCount(info.Reactions)
+	}
 	return mi
 }
 
+// reactionCount 汇总消息各回应的次数；收藏夹中的标签（are_tags）不算回应
+func reactionCount(r *tdclient.MessageReactions) int32 {
+	if r == nil || r.AreTags {
+		return 0
+	}
+	var n int32
+	for _, reaction := range r.Reactions {
+		n += reaction.TotalCount
+	}
+	return n
+}
+
+// forwardOrigin 返回转发消息的原始来源 id（用户为 user id，频道/群组为 chat id，隐藏身份的用户为 0）
+// 与原频道消息 id（仅频道来源可知）
+func forwardOrigin(origin tdclient.MessageOrigin) (fromID, messageID int64) {
+	switch o := origin.(type) {
+	case *tdclient.MessageOriginUser:
+		return o.SenderUserId, 0
+	case *tdclient.MessageOriginChat:
+		return o.SenderChatId, 0
+	case *tdclient.MessageOriginChannel:
+		return o.ChatId, o.MessageId
+	default:
+		return 0, 0
+	}
+}
+
 // captionText 提取消息内容的 caption 文本（无 caption 的类型返回空串）
 func captionText(content tdclient.MessageContent) string {
 	var ft *tdclient.FormattedText
@@ -740,6 +990,10 @@ func captionText(content tdclient.MessageContent) string {
 		ft = c.Caption
 	case *tdclient.MessageVoiceNote:
 		ft = c.Caption
+	case *tdclient.MessagePaidMedia:
+		ft = c.Caption
+	case *tdclient.MessageText:
+		ft = c.Text // 网页预览媒体以消息正文为说明
 	}
 	if ft == nil {
 		return ""
@@ -759,50 +1013,171 @@ func senderID(sender tdclient.MessageSender) int64 {
 	}
 }
 
-// extractMediaFile 按内容类型提取媒体文件信息（不含相册/caption/发送者等消息级字段）
+// extractMediaFile 按内容类型提取媒体文件信息（不含相册/caption/发送者等消息级字段）。
+// 付费媒体取首个已解锁的内容：下载记录按 (聊天, 消息) 一条，多项付费帖子的其余已解锁内容无法记录，
+// 跳过并记录警告；文本消息取其网页预览中的媒体
 func (c *Client) extractMediaFile(m *tdclient.Message) *downloader.MediaInfo {
 	if m == nil || m.Content == nil {
 		return nil
 	}
 	switch content := m.Content.(type) {
 	case *tdclient.MessagePhoto:
-		return mediaFromFile(m, largestPhotoFile(content.Photo), mediaTypePhoto,
-			fmt.Sprintf("photo_%d_%d.jpg", m.ChatId, m.Id), "image/jpeg")
+		return photoMedia(m, content.Photo)
 	case *tdclient.MessageDocument:
-		if content.Document == nil {
-			return nil
-		}
-		return mediaFromFile(m, content.Document.Document, mediaTypeDocument,
-			docName(content.Document.FileName, m.Id), content.Document.MimeType)
+		return documentMedia(m, content.Document)
 	case *tdclient.MessageVideo:
-		if content.Video == nil {
-			return nil
-		}
-		return mediaFromFile(m, content.Video.Video, mediaTypeVideo,
-			docName(content.Video.FileName, m.Id), content.Video.MimeType)
+		return videoMedia(m, content.Video)
 	case *tdclient.MessageAnimation:
-		if content.Animation == nil {
-			return nil
-		}
-		return mediaFromFile(m, content.Animation.Animation, mediaTypeAnimation,
-			docName(content.Animation.FileName, m.Id), content.Animation.MimeType)
+		return animationMedia(m, content.Animation)
 	case *tdclient.MessageAudio:
-		if content.Audio == nil {
-			return nil
-		}
-		return mediaFromFile(m, content.Audio.Audio, mediaTypeAudio,
-			docName(content.Audio.FileName, m.Id), content.Audio.MimeType)
+		return audioMedia(m, content.Audio)
 	case *tdclient.MessageVoiceNote:
-		if content.VoiceNote == nil {
+		return voiceMedia(m, content.VoiceNote)
+	case *tdclient.MessageVideoNote:
+		return videoNoteMedia(m, content.VideoNote)
+	case *tdclient.MessageSticker:
+		return stickerMedia(m, content.Sticker)
+	case *tdclient.MessagePaidMedia:
+		var first *downloader.MediaInfo
+		extra := 0
+		for _, pm := range content.Media {
+			switch mi := paidMediaFile(m, pm); {
+			case mi == nil:
+			case first == nil:
+				first = mi
+			default:
+				extra++
+			}
+		}
+		if extra > 0 {
+			c.logger.Warn("聊天 %d 消息 %d 为多项付费媒体：仅下载第一项已解锁内容，跳过其余 %d 项", m.ChatId, m.Id, extra)
+		}
+		return first
+	case *tdclient.MessageText:
+		if content.LinkPreview == nil {
 			return nil
 		}
-		return mediaFromFile(m, content.VoiceNote.Voice, mediaTypeVoice,
-			fmt.Sprintf("voice_%d_%d.ogg", m.ChatId, m.Id), content.VoiceNote.MimeType)
+		return linkPreviewMedia(m, content.LinkPreview.Type)
+	default:
+		return nil
+	}
+}
+
+// paidMediaFile 提取付费媒体中的单项内容；未购买（仅预览）或不支持的类型返回 nil
+func paidMediaFile(m *tdclient.Message, pm tdclient.PaidMedia) *downloader.MediaInfo {
+	switch p := pm.(type) {
+	case *tdclient.PaidMediaPhoto:
+		return photoMedia(m, p.Photo)
+	case *tdclient.PaidMediaVideo:
+		return videoMedia(m, p.Video)
+	default:
+		return nil
+	}
+}
+
+// linkPreviewMedia 提取网页预览中的媒体（如 t.me 帖子链接预览的图片/视频）；
+// 文章/应用等预览的配图只是装饰性缩略图，不予下载
+func linkPreviewMedia(m *tdclient.Message, preview tdclient.LinkPreviewType) *downloader.MediaInfo {
+	switch p := preview.(type) {
+	case *tdclient.LinkPreviewTypePhoto:
+		return photoMedia(m, p.Photo)
+	case *tdclient.LinkPreviewTypeVideo:
+		return videoMedia(m, p.Video)
+	case *tdclient.LinkPreviewTypeEmbeddedVideoPlayer:
+		return videoMedia(m, p.Video)
+	case *tdclient.LinkPreviewTypeAnimation:
+		return animationMedia(m, p.Animation)
+	case *tdclient.LinkPreviewTypeEmbeddedAnimationPlayer:
+		return animationMedia(m, p.Animation)
+	case *tdclient.LinkPreviewTypeAudio:
+		return audioMedia(m, p.Audio)
+	case *tdclient.LinkPreviewTypeEmbeddedAudioPlayer:
+		return audioMedia(m, p.Audio)
+	case *tdclient.LinkPreviewTypeDocument:
+		return documentMedia(m, p.Document)
+	case *tdclient.LinkPreviewTypeVoiceNote:
+		return voiceMedia(m, p.VoiceNote)
+	case *tdclient.LinkPreviewTypeVideoNote:
+		return videoNoteMedia(m, p.VideoNote)
+	case *tdclient.LinkPreviewTypeSticker:
+		return stickerMedia(m, p.Sticker)
 	default:
 		return nil
 	}
 }
 
+func photoMedia(m *tdclient.Message, photo *tdclient.Photo) *downloader.MediaInfo {
+	return mediaFromFile(m, largestPhotoFile(photo), mediaTypePhoto,
+		fmt.Sprintf("photo_%d_%d.jpg", m.ChatId, m.Id), "image/jpeg")
+}
+
+func documentMedia(m *tdclient.Message, doc *tdclient.Document) *downloader.MediaInfo {
+	if doc == nil {
+		return nil
+	}
+	return mediaFromFile(m, doc.Document, mediaTypeDocument, docName(doc.FileName, m.Id), doc.MimeType)
+}
+
+func videoMedia(m *tdclient.Message, video *tdclient.Video) *downloader.MediaInfo {
+	if video == nil {
+		return nil
+	}
+	return mediaFromFile(m, video.Video, mediaTypeVideo, docName(video.FileName, m.Id), video.MimeType)
+}
+
+func animationMedia(m *tdclient.Message, anim *tdclient.Animation) *downloader.MediaInfo {
+	if anim == nil {
+		return nil
+	}
+	return mediaFromFile(m, anim.Animation, mediaTypeAnimation, docName(anim.FileName, m.Id), anim.MimeType)
+}
+
+func audioMedia(m *tdclient.Message, audio *tdclient.Audio) *downloader.MediaInfo {
+	if audio == nil {
+		return nil
+	}
+	return mediaFromFile(m, audio.Audio, mediaTypeAudio, docName(audio.FileName, m.Id), audio.MimeType)
+}
+
+func voiceMedia(m *tdclient.Message, voice *tdclient.VoiceNote) *downloader.MediaInfo {
+	if voice == nil {
+		return nil
+	}
+	return mediaFromFile(m, voice.Voice, mediaTypeVoice,
+		fmt.Sprintf("voice_%d_%d.ogg", m.ChatId, m.Id), voice.MimeType)
+}
+
+// videoNoteMedia 提取圆形视频消息（TDLib 不提供 MIME，固定为 mp4）
+func videoNoteMedia(m *tdclient.Message, note *tdclient.VideoNote) *downloader.MediaInfo {
+	if note == nil {
+		return nil
+	}
+	return mediaFromFile(m, note.Video, mediaTypeVideoNote,
+		fmt.Sprintf("video_note_%d_%d.mp4", m.ChatId, m.Id), "video/mp4")
+}
+
+// stickerMedia 提取贴纸，扩展名与 MIME 按格式区分：静态 webp、动态 tgs（Lottie）、视频 webm
+func stickerMedia(m *tdclient.Message, sticker *tdclient.Sticker) *downloader.MediaInfo {
+	if sticker == nil {
+		return nil
+	}
+	ext, mime := stickerFormat(sticker)
+	return mediaFromFile(m, sticker.Sticker, mediaTypeSticker,
+		fmt.Sprintf("sticker_%d_%d.%s", m.ChatId, m.Id, ext), mime)
+}
+
+// stickerFormat 返回贴纸文件的扩展名与 MIME
+func stickerFormat(sticker *tdclient.Sticker) (ext, mime string) {
+	switch sticker.Format.(type) {
+	case *tdclient.StickerFormatTgs:
+		return "tgs", "application/x-tgsticker"
+	case *tdclient.StickerFormatWebm:
+		return "webm", "video/webm"
+	default:
+		return "webp", "image/webp"
+	}
+}
+
 // mediaFromFile 由 TDLib 文件构建 MediaInfo；file 为 nil 时返回 nil
 func mediaFromFile(m *tdclient.Message, f *tdclient.File, mediaType, fileName, mime string) *downloader.MediaInfo {
 	if f == nil {
@@ -974,7 +1349,8 @@ func (c *Client) unregisterProgress(fileID int32) {
 	c.trackMu.Unlock()
 }
 
-// historyCountFilters 将媒体类型映射到服务端计数过滤器，与 extractMediaInfo 支持的类型一一对应
+// historyCountFilters 将媒体类型映射到服务端计数/搜索过滤器；贴纸没有对应的服务端过滤器，
+// 不在表中（计数不含贴纸，选中贴纸或不限类型时历史扫描回退为逐页翻阅）
 var historyCountFilters = map[string]tdclient.SearchMessagesFilter{
 	mediaTypePhoto:     &tdclient.SearchMessagesFilterPhoto{},
 	mediaTypeVideo:     &tdclient.SearchMessagesFilterVideo{},
@@ -982,6 +1358,7 @@ var historyCountFilters = map[string]tdclient.SearchMessagesFilter{
 	mediaTypeAudio:     &tdclient.SearchMessagesFilterAudio{},
 	mediaTypeVoice:     &tdclient.SearchMessagesFilterVoiceNote{},
 	mediaTypeAnimation: &tdclient.SearchMessagesFilterAnimation{},
+	mediaTypeVideoNote: &tdclient.SearchMessagesFilterVideoNote{},
 }
 
 // SendSelfMessage 向自己的 Saved Messages 发送一条文本消息（用于任务完成通知）
@@ -1016,15 +1393,31 @@ func (c *Client) SendSelfMessage(ctx context.Context, text string) error {
 	return nil
 }
 
-// ResolvedTarget 是 t.me 链接/公开用户名的解析结果；MessageID 非 0 表示指向单条消息
+// ResolvedTarget 是 t.me 链接/公开用户名的解析结果；MessageID 非 0 表示指向单条消息，
+// RangeStart/RangeEnd 非 0 表示两条消息链接界定的消息区间。
+// InviteLink 非空表示邀请链接：NeedsJoin 时当前账号尚未加入（ChatID 为 0），须经 JoinInviteLink 加入后才能下载
 type ResolvedTarget struct {
-	ChatID    int64  `json:"chat_id"`
-	Title     string `json:"chat_title"`
-	MessageID int64  `json:"message_id,omitempty"`
+	ChatID     int64  `json:"chat_id"`
+	Title      string `json:"chat_title"`
+	MessageID  int64  `json:"message_id,omitempty"`
+	RangeStart int64  `json:"range_start,omitempty"`
+	RangeEnd   int64  `json:"range_end,omitempty"`
+
+	InviteLink  string `json:"invite_link,omitempty"`
+	MemberCount int32  `json:"member_count,omitempty"`
+	NeedsJoin   bool   `json:"needs_join,omitempty"`
+	JoinRequest bool   `json:"join_request,omitempty"` // 加入需管理员审批，提交后无法立即下载
+
+	// StickerSet 非空时目标为贴纸包（t.me/addstickers/<name>）：ChatID 为贴纸包 id，Title 为包标题
+	StickerSet   string `json:"sticker_set,omitempty"`
+	StickerCount int    `json:"sticker_count,omitempty"`
 }
 
 // ResolveTarget 解析下载目标：支持 @用户名、t.me/<name>、t.me/<name>/<msg>、
-// t.me/c/<id>/<msg> 及带 https:// 前缀的等价形式。私有链接要求当前账号可访问该聊天。
+// t.me/c/<id>/<msg>、邀请链接（t.me/+<hash>、t.me/joinchat/<hash>）、贴纸包（t.me/addstickers/<name>）
+// 及带 https:// 前缀的等价形式。
+// 私有消息链接要求当前账号可访问该聊天；邀请链接只预览不加入。
+// 以空白或逗号分隔的两条消息链接（第二条可简写为消息序号）解析为二者之间的消息区间
 func (c *Client) ResolveTarget(ctx context.Context, input string) (ResolvedTarget, error) {
 	td := c.client()
 	if td == nil {
@@ -1034,11 +1427,21 @@ func (c *Client) ResolveTarget(ctx context.Context, input string) (ResolvedTarge
 	if input == "" {
 		return ResolvedTarget{}, errors.New("目标不能为空")
 	}
+	parts := strings.FieldsFunc(input, func(r rune) bool { return unicode.IsSpace(r) || r == ',' || r == '，' })
+	switch {
+	case len(parts) == 2:
+		return c.resolveMessageRange(ctx, parts[0], parts[1])
+	case len(parts) > 2:
+		return ResolvedTarget{}, errors.New("最多只能输入两条消息链接")
+	}
 
 	normalized := strings.TrimPrefix(strings.TrimPrefix(input, "https://"), "http://")
 	if path, ok := strings.CutPrefix(normalized, "t.me/"); ok {
 		if strings.HasPrefix(path, "+") || strings.HasPrefix(path, "joinchat/") {
-			return ResolvedTarget{}, errors.New("暂不支持邀请链接，请先加入该聊天后从列表选择")
+			return c.resolveInviteLink(ctx, td, "https://t.me/"+path)
+		}
+		if name, ok := strings.CutPrefix(path, "addstickers/"); ok {
+			return c.resolveStickerSet(ctx, td, name)
 		}
 		// 含消息序号（t.me/<name>/<msg> 或 t.me/c/<id>/<msg>）走消息链接解析
 		if strings.Contains(path, "/") {
@@ -1049,6 +1452,73 @@ func (c *Client) ResolveTarget(ctx context.Context, input string) (ResolvedTarge
 	return c.resolvePublicChat(ctx, td, strings.TrimPrefix(input, "@"))
 }
 
+// ResolveFilterSources 把发送者与转发来源输入（数字 id 或 @用户名）解析为 id 并入过滤器（去重）：
+// senders/excludeSenders 并入 SenderIDs/ExcludeSenderIDs，forwardFrom 并入 ForwardFromIDs。
+// 用户名经 ResolveTarget 解析；返回的错误描述首个无法解析的输入
+func (c *Client) ResolveFilterSources(
+	ctx context.Context, f *downloader.HistoryFilters, senders, excludeSenders, forwardFrom []string,
+) error {
+	for _, src := range []struct {
+		what  string
+		ids   *[]int64
+		names []string
+	}{
+		{"发送者", &f.SenderIDs, senders},
+		{"发送者", &f.ExcludeSenderIDs, excludeSenders},
+		{"转发来源", &f.ForwardFromIDs, forwardFrom},
+	} {
+		for _, raw := range src.names {
+			raw = strings.TrimSpace(raw)
+			if raw == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				if !strings.HasPrefix(raw, "@") {
+					return fmt.Errorf("%s须为 id 或 @用户名: %s", src.what, raw)
+				}
+				target, resolveErr := c.ResolveTarget(ctx, raw)
+				if resolveErr != nil {
+					return fmt.Errorf("无法解析%s %s: %w", src.what, raw, resolveErr)
+				}
+				id = target.ChatID // 私聊 chat id 即用户 id，频道/群组为其聊天 id，与消息发送者及转发来源一致
+			}
+			if !slices.Contains(*src.ids, id) {
+				*src.ids = append(*src.ids, id)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveMessageRange 解析两条同一聊天的消息链接为消息区间（顺序不限）；
+// 第二条为纯数字时视为与第一条同聊天的消息序号
+func (c *Client) resolveMessageRange(ctx context.Context, first, second string) (ResolvedTarget, error) {
+	if _, err := strconv.ParseInt(second, 10, 64); err == nil {
+		if i := strings.LastIndex(first, "/"); i >= 0 {
+			second = first[:i+1] + second
+		}
+	}
+	a, err := c.ResolveTarget(ctx, first)
+	if err != nil {
+		return ResolvedTarget{}, err
+	}
+	b, err := c.ResolveTarget(ctx, second)
+	if err != nil {
+		return ResolvedTarget{}, err
+	}
+	if a.MessageID == 0 || b.MessageID == 0 {
+		return ResolvedTarget{}, errors.New("消息区间需要两条消息链接")
+	}
+	if a.ChatID != b.ChatID {
+		return ResolvedTarget{}, errors.New("两条消息链接须属于同一聊天")
+	}
+	return ResolvedTarget{
+		ChatID: a.ChatID, Title: a.Title,
+		RangeStart: min(a.MessageID, b.MessageID), RangeEnd: max(a.MessageID, b.MessageID),
+	}, nil
+}
+
 // resolveMessageLink 经 GetMessageLinkInfo 解析消息链接
 func (c *Client) resolveMessageLink(ctx context.Context, td *tdclient.Client, url string) (ResolvedTarget, error) {
 	info, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.MessageLinkInfo, error) {
@@ -1072,6 +1542,85 @@ func (c *Client) resolveMessageLink(ctx context.Context, td *tdclient.Client, ur
 	return target, nil
 }
 
+// resolveInviteLink 经 CheckChatInviteLink 预览邀请链接指向的聊天（标题、成员数），不加入
+func (c *Client) resolveInviteLink(ctx context.Context, td *tdclient.Client, link string) (ResolvedTarget, error) {
+	info, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.ChatInviteLinkInfo, error) {
+		return td.CheckChatInviteLink(cc, &tdclient.CheckChatInviteLinkRequest{InviteLink: link})
+	})
+	if err != nil {
+		return ResolvedTarget{}, fmt.Errorf("邀请链接无效或已过期: %w", err)
+	}
+	return ResolvedTarget{
+		ChatID:      info.ChatId,
+		Title:       info.Title,
+		InviteLink:  link,
+		MemberCount: info.MemberCount,
+		NeedsJoin:   info.ChatId == 0,
+		JoinRequest: info.ChatId == 0 && info.CreatesJoinRequest,
+	}, nil
+}
+
+// JoinInviteLink 经邀请链接加入聊天；已是成员时直接返回该聊天（joined=false，调用方据此判断可否在完成后退出）
+func (c *Client) JoinInviteLink(ctx context.Context, link string) (target ResolvedTarget, joined bool, err error) {
+	td := c.client()
+	if td == nil {
+		return ResolvedTarget{}, false, errors.New("TDLib 未连接")
+	}
+	target, err = c.resolveInviteLink(ctx, td, link)
+	if err != nil {
+		return ResolvedTarget{}, false, err
+	}
+	if !target.NeedsJoin {
+		return target, false, nil
+	}
+	if target.JoinRequest {
+		return ResolvedTarget{}, false, errors.New("该邀请链接需管理员审批才能加入，请在审批通过后再下载")
+	}
+	chat, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.Chat, error) {
+		return td.JoinChatByInviteLink(cc, &tdclient.JoinChatByInviteLinkRequest{InviteLink: link})
+	})
+	if err != nil {
+		return ResolvedTarget{}, false, fmt.Errorf("加入聊天失败: %w", err)
+	}
+	c.logger.Info("已通过邀请链接加入聊天: %s (%d)", chat.Title, chat.Id)
+	target.ChatID, target.Title, target.NeedsJoin = chat.Id, chat.Title, false
+	return target, true, nil
+}
+
+// LeaveChat 退出聊天（经邀请链接临时加入的聊天在下载完成后退出）
+func (c *Client) LeaveChat(ctx context.Context, chatID int64) error {
+	td := c.client()
+	if td == nil {
+		return errors.New("TDLib 未连接")
+	}
+	if _, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.Ok, error) {
+		return td.LeaveChat(cc, &tdclient.LeaveChatRequest{ChatId: chatID})
+	}); err != nil {
+		return fmt.Errorf("退出聊天 %d 失败: %w", chatID, err)
+	}
+	return nil
+}
+
+// resolveStickerSet 按短名称解析贴纸包（标题与贴纸数）
+func (c *Client) resolveStickerSet(ctx context.Context, td *tdclient.Client, name string) (ResolvedTarget, error) {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return ResolvedTarget{}, errors.New("贴纸包名称不能为空")
+	}
+	set, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.StickerSet, error) {
+		return td.SearchStickerSet(cc, &tdclient.SearchStickerSetRequest{Name: name})
+	})
+	if err != nil {
+		return ResolvedTarget{}, fmt.Errorf("找不到贴纸包 %s: %w", name, err)
+	}
+	return ResolvedTarget{
+		ChatID:       int64(set.Id),
+		Title:        set.Title,
+		StickerSet:   set.Name,
+		StickerCount: len(set.Stickers),
+	}, nil
+}
+
 // resolvePublicChat 按公开用户名解析聊天
 func (c *Client) resolvePublicChat(ctx context.Context, td *tdclient.Client, username string) (ResolvedTarget, error) {
 	if username == "" {
@@ -1087,13 +1636,15 @@ func (c *Client) resolvePublicChat(ctx context.Context, td *tdclient.Client, use
 }
 
 // CountHistoryMedia 统计聊天历史中可下载媒体的总数（服务端近似值）。
-// mediaTypes 非空时只统计选中的类型；日期/大小过滤无法在服务端预估，结果为上估。
+// filters.MediaTypes 非空时只统计选中的类型，TopicID 非 0 时只统计该话题；
+// 日期/大小过滤无法在服务端预估，结果为上估。
 // 单个过滤器失败仅跳过；全部失败返回错误，调用方回退为未知总数。
-func (c *Client) CountHistoryMedia(ctx context.Context, chatID int64, mediaTypes []string) (int64, error) {
+func (c *Client) CountHistoryMedia(ctx context.Context, chatID int64, filters downloader.HistoryFilters) (int64, error) {
 	td := c.client()
 	if td == nil {
 		return 0, errors.New("TDLib 未连接")
 	}
+	mediaTypes := filters.MediaTypes
 	selected := make([]tdclient.SearchMessagesFilter, 0, len(historyCountFilters))
 	if len(mediaTypes) == 0 {
 		for _, f := range historyCountFilters {
@@ -1113,6 +1664,7 @@ func (c *Client) CountHistoryMedia(ctx context.Context, chatID int64, mediaTypes
 		cnt, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.Count, error) {
 			return td.GetChatMessageCount(cc, &tdclient.GetChatMessageCountRequest{
 				ChatId:      chatID,
+				TopicId:     topicFilter(filters.TopicID),
 				Filter:      f,
 				ReturnLocal: false,
 			})
@@ -1166,13 +1718,15 @@ func (c *Client) DownloadHistoryMedia(ctx context.Context, spec *downloader.Hist
 	var wg sync.WaitGroup
 	sem := make(chan struct{}, partitionSize)
 
-	// dispatch 将单个媒体投入下载流水线（受 sem 在途上限约束）
+	// dispatch 将单个媒体计入统计并投入下载流水线（受 sem 在途上限约束）；
+	// 扫描各环节只经 dispatch 交出媒体，试运行（PreviewHistory）以汇总代替
 	dispatch := func(mi *downloader.MediaInfo) error {
 		select {
 		case sem <- struct{}{}:
 		case <-ctx.Done():
 			return ctx.Err()
 		}
+		c.downloader.PlanBatch([]*downloader.MediaInfo{mi})
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -1203,7 +1757,14 @@ func (c *Client) DownloadHistoryMedia(ctx context.Context, spec *downloader.Hist
 		}
 	}
 
-	scannedMessages, foundMedia, scanErr := c.scanHistoryPages(ctx, td, spec, dispatch)
+	var scannedMessages, foundMedia int64
+	var scanErr error
+	if n := c.scanSegmentCount(spec); n > 1 || (len(spec.Segments) > 0 && !spec.OldestFirst) {
+		scannedMessages, foundMedia, scanErr = c.scanSegmented(ctx, td, spec, n, dispatch)
+	} else {
+		scannedMessages, foundMedia, scanErr = c.scanHistoryPages(ctx, td, spec, dispatch,
+			func(scanned, found, cursor int64) { c.reportScanProgress(spec.TaskID, scanned, found, cursor) })
+	}
 	if scanErr == nil {
 		c.logger.Info("历史扫描完成: 共扫描 %d 条消息，发现 %d 个媒体", scannedMessages, foundMedia)
 	}
@@ -1218,6 +1779,52 @@ func (c *Client) DownloadHistoryMedia(ctx context.Context, spec *downloader.Hist
 	return nil
 }
 
+// PreviewHistory 试运行 spec：以与 DownloadHistoryMedia 相同的翻页与过滤流程扫描（含单消息、区间、增量下界、
+// 聊天内搜索与评论区），但不下载任何文件，只汇总将下载的媒体及其中会因已存在或 unique_id 去重而跳过的部分。
+// 分段只是并行策略、不改变扫描结果，试运行统一顺序扫描；maxMessages > 0 时扫描满该消息数即停止并标记 Truncated
+func (c *Client) PreviewHistory(ctx context.Context, spec *downloader.HistorySpec, maxMessages int64) (*downloader.Preview, error) {
+	td := c.client()
+	if td == nil {
+		return nil, errors.New("TDLib 未连接")
+	}
+	closeChat, err := c.openChatForHistory(ctx, td, spec.ChatID)
+	if err != nil {
+		return nil, err
+	}
+	if closeChat != nil {
+		defer closeChat()
+	}
+
+	collector := c.downloader.NewPreviewCollector()
+	collect := func(mi *downloader.MediaInfo) error {
+		collector.Add(ctx, mi)
+		return nil
+	}
+	if spec.MessageID != 0 {
+		if err := c.downloadSingleHistoryMessage(ctx, td, spec, collect); err != nil {
+			return nil, err
+		}
+		return collector.Result(1, false), nil
+	}
+
+	scanCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var scanned int64
+	truncated := false
+	_, _, err = c.scanHistoryPages(scanCtx, td, spec, collect, func(n, _, _ int64) {
+		scanned = n
+		if maxMessages > 0 && n >= maxMessages {
+			truncated = true
+			cancel()
+		}
+	})
+	if err != nil && !(truncated && ctx.Err() == nil && errors.Is(err, context.Canceled)) {
+		return nil, err
+	}
+	c.logger.Info("试运行完成: 扫描 %d 条消息", scanned)
+	return collector.Result(scanned, truncated), nil
+}
+
 // openChatForHistory 校验聊天可访问并打开聊天，促使 TDLib 主动从服务器同步历史；
 // 冷缓存时首批 GetChatHistory 常为空，否则可能在历史尚未拉取就误判"已完成"。
 // 返回的 closeFn（可为 nil）应在拉取结束后调用以释放 TDLib 资源。
@@ -1240,10 +1847,15 @@ func (c *Client) openChatForHistory(ctx context.Context, td *tdclient.Client, ch
 }
 
 // scanHistoryPages 从 spec.FromMessageID 起向更旧方向翻页扫描，按任务过滤器筛选并分发下载；
-// 游标随页推进经 reportScanProgress 上报持久化
+// 每页经 onPage 上报累计进度与游标（本页最旧消息）；到达 scanBounds 的下界（增量水位/分段下界/区间起点）即结束。
+// spec.OldestFirst 时改为由旧到新翻页，游标为本页最新消息，翻到最新消息即结束。
+// 任务媒体类型均有服务端搜索过滤器时改用 SearchChatMessages 只翻媒体消息，否则逐页翻阅全部历史；
+// spec.SearchQuery 非空或过滤器只限定单个发送者（非评论模式）时总是走 SearchChatMessages，
+// 由服务端按关键词/发送者筛选（无对应过滤器的类型以不限类型的单路搜索兜底）。
+// spec.Comments 时每页帖子的评论区随页翻阅（scanPostComments），评论媒体计入 foundMedia
 func (c *Client) scanHistoryPages(
 	ctx context.Context, td *tdclient.Client, spec *downloader.HistorySpec,
-	dispatch func(*downloader.MediaInfo) error,
+	dispatch func(*downloader.MediaInfo) error, onPage func(scannedMessages, foundMedia, cursor int64),
 ) (scannedMessages, foundMedia int64, err error) {
 	batchSize := c.config.Download.BatchSize
 	if batchSize <= 0 || batchSize > DefaultMessageLimit {
@@ -1251,8 +1863,42 @@ func (c *Client) scanHistoryPages(
 	}
 	limit := int32(batchSize) // 已上界钳制到 DefaultMessageLimit(100)，不会溢出
 
-	fromMsgID := spec.FromMessageID // 0 = 从最新开始；>0 = 断点续扫
-	emptyStreak := 0
+	ascending := spec.OldestFirst
+	low, high := scanBounds(spec)
+	match := spec.Filters.Matcher()
+	from := spec.FromMessageID
+	if from == 0 {
+		if ascending {
+			from = c.oldestFirstStart(ctx, td, spec)
+		} else if high > 0 {
+			from = high + 1 // 翻页起点不含自身，+1 使区间上界消息本身被扫描
+		}
+	}
+
+	var pager historyPager
+	filters, ok := searchFiltersFor(spec.Filters.MediaTypes)
+	scope := searchScope{query: spec.SearchQuery}
+	if len(spec.Filters.SenderIDs) == 1 && !spec.Comments {
+		// 多个发送者无法由一次服务端搜索表达，退回按媒体类型搜索后在本地筛选
+		scope.senderID = spec.Filters.SenderIDs[0]
+	}
+	switch {
+	case scope != searchScope{}:
+		if !ok {
+			filters = []tdclient.SearchMessagesFilter{nil} // 不限类型，媒体由 extractBatchMedia 按过滤器筛选
+		}
+		c.logger.Info("在聊天 %d 中按关键词「%s」/发送者 %d 搜索（%d 个过滤器）",
+			spec.ChatID, scope.query, scope.senderID, len(filters))
+		pager = newSearchHistoryPager(td, spec.ChatID, spec.Filters.TopicID, from, limit, ascending, scope, filters)
+	case ok && !spec.Comments:
+		// 评论模式须翻阅全部帖子：纯文字帖子的评论区同样可能有媒体
+		c.logger.Info("按媒体类型服务端搜索聊天 %d 的历史（%d 个过滤器）", spec.ChatID, len(filters))
+		pager = newSearchHistoryPager(td, spec.ChatID, spec.Filters.TopicID, from, limit, ascending, searchScope{}, filters)
+	default:
+		pager = &chatHistoryPager{
+			td: td, chatID: spec.ChatID, topicID: spec.Filters.TopicID, fromMsgID: from, limit: limit, ascending: ascending,
+		}
+	}
 	lastScanLog := time.Now()
 
 	for {
@@ -1260,37 +1906,48 @@ func (c *Client) scanHistoryPages(
 			return scannedMessages, foundMedia, err
 		}
 
-		pageMsgs, err := fetchHistoryPage(ctx, td, spec.ChatID, fromMsgID, limit)
-		if err != nil {
+		pageMsgs, err := pager.next(ctx)
+		if err != nil || len(pageMsgs) == 0 {
 			return scannedMessages, foundMedia, err
 		}
 
-		if len(pageMsgs) == 0 {
-			emptyStreak++
-			stop, err := awaitNextHistoryPage(ctx, emptyStreak)
-			if err != nil || stop {
-				return scannedMessages, foundMedia, err
+		reachedStop := false
+		for i, m := range pageMsgs {
+			if (!ascending && low > 0 && m.Id <= low) || (ascending && high > 0 && m.Id > high) {
+				pageMsgs, reachedStop = pageMsgs[:i], true
+				break
 			}
-			continue
 		}
-		emptyStreak = 0
+		if reachedStop && len(pageMsgs) == 0 {
+			c.logger.Debug("历史扫描已到达扫描区间边界，结束")
+			return scannedMessages, foundMedia, nil
+		}
 
-		media, lastMsgID, pastDateFrom := c.extractBatchMedia(pageMsgs, spec.TaskID, spec.Filters)
-		fromMsgID = lastMsgID // 推进到本页最旧消息
+		media, lastMsgID, pastDateRange := c.extractBatchMedia(pageMsgs, spec.TaskID, spec.Filters, match, ascending)
 		scannedMessages += int64(len(pageMsgs))
 		foundMedia += int64(len(media))
-		// 游标随页推进即上报持久化；本页/在途媒体若在落盘后被杀，
+		// 游标（本页末条消息）随页推进即上报持久化；本页/在途媒体若在落盘后被杀，
 		// 由启动清扫（interrupted）+ 恢复补下（RetryMessageIDs）兜底，不会漏
-		c.reportScanProgress(spec.TaskID, scannedMessages, foundMedia, fromMsgID)
+		onPage(scannedMessages, foundMedia, lastMsgID)
 
-		c.downloader.PlanBatch(media)
 		for _, m := range media {
 			if err := dispatch(m); err != nil {
 				return scannedMessages, foundMedia, err
 			}
 		}
-		if pastDateFrom {
-			c.logger.Info("历史扫描已越过起始日期，提前结束")
+		if spec.Comments {
+			found, err := c.scanPostComments(ctx, td, spec, match, pageMsgs, dispatch)
+			foundMedia += found
+			if err != nil {
+				return scannedMessages, foundMedia, err
+			}
+		}
+		if pastDateRange {
+			c.logger.Info("历史扫描已越出日期区间，提前结束")
+			return scannedMessages, foundMedia, nil
+		}
+		if reachedStop {
+			c.logger.Debug("历史扫描已到达扫描区间边界，结束")
 			return scannedMessages, foundMedia, nil
 		}
 
@@ -1301,30 +1958,220 @@ func (c *Client) scanHistoryPages(
 	}
 }
 
-// retryInterruptedMessages 逐条重取并补下恢复任务的中断消息；消息已删除或无媒体时记警告跳过
-func (c *Client) retryInterruptedMessages(
-	ctx context.Context, td *tdclient.Client, spec *downloader.HistorySpec,
-	dispatch func(*downloader.MediaInfo) error,
-) error {
-	var batch []*downloader.MediaInfo
-	for _, msgID := range spec.RetryMessageIDs {
-		if err := ctx.Err(); err != nil {
-			return err
-		}
+// scanBounds 返回扫描区间 (low, high]：low 取增量水位/分段下界与区间起点之前中较新者，
+// high 为区间终点；0 表示该端不限
+func scanBounds(spec *downloader.HistorySpec) (low, high int64) {
+	low = spec.StopAtMessageID
+	if spec.RangeStart > 0 {
+		low = max(low, spec.RangeStart-1)
+	}
+	return low, spec.RangeEnd
+}
+
+// oldestFirstStart 返回正序扫描的起点（不含）：取扫描区间下界与 DateFrom 前最后一条消息中较新者，
+// 均未设置时从聊天第一条消息开始；按日期定位失败时退回区间下界，多扫不漏
+func (c *Client) oldestFirstStart(ctx context.Context, td *tdclient.Client, spec *downloader.HistorySpec) int64 {
+	start, _ := scanBounds(spec)
+	if spec.Filters.DateFrom > 0 {
 		msg, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.Message, error) {
-			return td.GetMessage(cc, &tdclient.GetMessageRequest{ChatId: spec.ChatID, MessageId: msgID})
+			return td.GetChatMessageByDate(cc, &tdclient.GetChatMessageByDateRequest{
+				ChatId: spec.ChatID, Date: int32(spec.Filters.DateFrom - 1),
+			})
+		})
+		if err != nil {
+			c.logger.Warn("按起始日期定位正序扫描起点失败，从更早处开始: %v", err)
+		} else if msg != nil {
+			start = max(start, msg.Id)
+		}
+	}
+	return max(start, 1) // 游标 0 在 TDLib 中表示"最新消息"，正序须从 1 起
+}
+
+// scanSegmentCount 返回任务的扫描分段数：任务未指定时沿用配置，上界钳制到 MaxScanSegments；
+// 正序任务要求严格按发布顺序下载，不分段
+func (c *Client) scanSegmentCount(spec *downloader.HistorySpec) int {
+	if spec.OldestFirst {
+		return 1
+	}
+	n := spec.ScanSegments
+	if n <= 0 {
+		n = c.config.Download.ScanSegments
+	}
+	return min(n, downloader.MaxScanSegments)
+}
+
+// scanSegmented 分段并行扫描：各段独立翻页、共用下载流水线（dispatch 的在途上限），
+// 段游标推进或扫完即经 segmentFunc 上报全部分段快照；任一段出错即取消其余段。
+// spec.Segments 非空时为恢复任务，按持久化的各段游标续扫，已扫完的段跳过
+func (c *Client) scanSegmented(
+	ctx context.Context, td *tdclient.Client, spec *downloader.HistorySpec, n int,
+	dispatch func(*downloader.MediaInfo) error,
+) (scannedMessages, foundMedia int64, err error) {
+	segments := slices.Clone(spec.Segments)
+	if len(segments) == 0 {
+		if segments, err = c.planScanSegments(ctx, td, spec, n); err != nil {
+			return 0, 0, err
+		}
+		c.logger.Info("聊天 %d 分 %d 段并行扫描", spec.ChatID, len(segments))
+	} else {
+		c.logger.Info("聊天 %d 按 %d 个分段的游标续扫", spec.ChatID, len(segments))
+	}
+
+	var mu sync.Mutex
+	report := func() {
+		if c.segmentFunc == nil || spec.TaskID == "" {
+			return
+		}
+		mu.Lock()
+		snapshot := slices.Clone(segments)
+		mu.Unlock()
+		c.segmentFunc(spec.TaskID, snapshot)
+	}
+	report()
+
+	segCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg                       sync.WaitGroup
+		errOnce                  sync.Once
+		firstErr                 error
+		scannedTotal, foundTotal atomic.Int64
+	)
+	for i := range segments {
+		if segments[i].Done {
+			continue
+		}
+		segSpec := *spec
+		segSpec.Segments = nil
+		segSpec.FromMessageID = segments[i].High + 1 // 翻页起点不含自身，+1 使上界消息落入本段
+		if segments[i].Cursor != 0 {
+			segSpec.FromMessageID = segments[i].Cursor
+		}
+		segSpec.StopAtMessageID = segments[i].Low
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var lastScanned, lastFound int64
+			_, _, segErr := c.scanHistoryPages(segCtx, td, &segSpec, dispatch, func(scanned, found, cursor int64) {
+				total := scannedTotal.Add(scanned - lastScanned)
+				totalFound := foundTotal.Add(found - lastFound)
+				lastScanned, lastFound = scanned, found
+				mu.Lock()
+				segments[i].Cursor = cursor
+				mu.Unlock()
+				c.reportScanProgress(spec.TaskID, total, totalFound, 0) // 分段模式不使用单一游标
+				report()
+			})
+			if segErr != nil {
+				errOnce.Do(func() {
+					firstErr = segErr
+					cancel()
+				})
+				return
+			}
+			mu.Lock()
+			segments[i].Done = true
+			mu.Unlock()
+			report()
+		}()
+	}
+	wg.Wait()
+	return scannedTotal.Load(), foundTotal.Load(), firstErr
+}
+
+// planScanSegments 把扫描区间 (下界, 区间终点或聊天最新消息] 切成至多 n 段，由新到旧排列。
+// 默认按消息 id 等分（id 随时间单调，近似按消息量等分）；SegmentByDate 且设置了 DateFrom 时
+// 按日期区间等分，边界经 GetChatMessageByDate 换算为消息 id
+func (c *Client) planScanSegments(
+	ctx context.Context, td *tdclient.Client, spec *downloader.HistorySpec, n int,
+) ([]downloader.ScanSegment, error) {
+	top, err := c.LatestMessageID(ctx, spec.ChatID)
+	if err != nil {
+		return nil, err
+	}
+	bottom, high := scanBounds(spec)
+	if high > 0 {
+		top = min(top, high)
+	}
+	bounds := make([]int64, 0, n+1) // 由新到旧的 n+1 个边界
+	if spec.SegmentByDate && spec.Filters.DateFrom > 0 {
+		bounds = c.dateSegmentBounds(ctx, td, spec, n, top, bottom)
+	} else {
+		for i := 0; i <= n; i++ {
+			bounds = append(bounds, top-(top-bottom)*int64(i)/int64(n))
+		}
+	}
+
+	var segments []downloader.ScanSegment
+	for i := 0; i+1 < len(bounds); i++ {
+		if bounds[i] > bounds[i+1] {
+			segments = append(segments, downloader.ScanSegment{High: bounds[i], Low: bounds[i+1]})
+		}
+	}
+	if len(segments) == 0 { // 聊天为空或已无新消息：保留一个空段，使任务照常走完并上报
+		segments = append(segments, downloader.ScanSegment{High: top, Low: bottom})
+	}
+	return segments, nil
+}
+
+// dateSegmentBounds 把 [DateFrom, DateTo（缺省为当前）] 等分为 n 个日期区间，各边界换算为该时刻及之前
+// 最新一条消息的 id。边界钳制到 [bottom, top] 且保持单调；换算失败的边界取 bottom，使该段覆盖其后全部消息（宁可多扫不漏扫）
+func (c *Client) dateSegmentBounds(
+	ctx context.Context, td *tdclient.Client, spec *downloader.HistorySpec, n int, top, bottom int64,
+) []int64 {
+	from, to := spec.Filters.DateFrom-1, spec.Filters.DateTo // from-1：DateFrom 当天的消息须落在下界之上
+	if to == 0 {
+		to = time.Now().Unix()
+	}
+	bounds := make([]int64, 0, n+1)
+	prev := top
+	for i := 0; i <= n; i++ {
+		if i == 0 && spec.Filters.DateTo == 0 {
+			bounds = append(bounds, top)
+			continue
+		}
+		date := to - (to-from)*int64(i)/int64(n)
+		bound := bottom
+		msg, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.Message, error) {
+			return td.GetChatMessageByDate(cc, &tdclient.GetChatMessageByDateRequest{
+				ChatId: spec.ChatID, Date: int32(date),
+			})
+		})
+		if err != nil {
+			c.logger.Warn("按日期定位分段边界失败，该段扩展到更早的全部消息: %v", err)
+		} else if msg != nil {
+			bound = msg.Id
+		}
+		bound = max(min(bound, prev), bottom)
+		bounds = append(bounds, bound)
+		prev = bound
+	}
+	return bounds
+}
+
+// retryInterruptedMessages 逐条重取并补下恢复任务的中断消息；消息已删除或无媒体时记警告跳过
+func (c *Client) retryInterruptedMessages(
+	ctx context.Context, td *tdclient.Client, spec *downloader.HistorySpec,
+	dispatch func(*downloader.MediaInfo) error,
+) error {
+	var batch []*downloader.MediaInfo
+	match := spec.Filters.Matcher()
+	for _, msgID := range spec.RetryMessageIDs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		msg, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.Message, error) {
+			return td.GetMessage(cc, &tdclient.GetMessageRequest{ChatId: spec.ChatID, MessageId: msgID})
 		})
 		if err != nil {
 			c.logger.Warn("补下中断媒体失败（消息 %d 可能已删除）: %v", msgID, err)
 			continue
 		}
-		if media := c.extractMediaInfo(msg); media != nil &&
-			spec.Filters.Match(media.MediaType, int64(msg.Date), media.FileSize) {
+		if media := c.extractMediaInfo(msg); media != nil && match.Match(media) {
 			media.TaskID = spec.TaskID
 			batch = append(batch, media)
 		}
 	}
-	c.downloader.PlanBatch(batch)
 	for _, m := range batch {
 		if err := dispatch(m); err != nil {
 			return err
@@ -1345,15 +2192,715 @@ func (c *Client) downloadSingleHistoryMessage(
 		return fmt.Errorf("获取消息 %d 失败: %w", spec.MessageID, err)
 	}
 	media := c.extractMediaInfo(msg)
-	if media == nil {
+	match := spec.Filters.Matcher()
+	switch {
+	case media != nil && match.Match(media):
+		media.TaskID = spec.TaskID
+		if err := dispatch(media); err != nil {
+			return err
+		}
+	case spec.Comments:
+		// 评论模式下帖子本身没有可下载的媒体不算错误，仍下载其评论区
+	case media == nil:
 		return fmt.Errorf("消息 %d 不包含可下载的媒体", spec.MessageID)
-	}
-	if !spec.Filters.Match(media.MediaType, int64(msg.Date), media.FileSize) {
+	default:
 		return fmt.Errorf("消息 %d 的媒体被任务过滤器排除", spec.MessageID)
 	}
-	media.TaskID = spec.TaskID
-	c.downloader.PlanBatch([]*downloader.MediaInfo{media})
-	return dispatch(media)
+	if !spec.Comments || commentCount(msg) == 0 {
+		return nil
+	}
+	_, err = c.scanCommentThread(ctx, td, spec, match, msg, dispatch)
+	return err
+}
+
+// scanPostComments 依次翻阅一页帖子中有评论的帖子的评论区，返回发现的评论媒体数；
+// 单个帖子的评论区拉取失败只记警告（帖子可能已关闭评论或讨论组不可访问），不中断扫描
+func (c *Client) scanPostComments(
+	ctx context.Context, td *tdclient.Client, spec *downloader.HistorySpec, match *downloader.MediaMatcher,
+	posts []*tdclient.Message, dispatch func(*downloader.MediaInfo) error,
+) (found int64, err error) {
+	for _, post := range posts {
+		if commentCount(post) == 0 {
+			continue
+		}
+		n, err := c.scanCommentThread(ctx, td, spec, match, post, dispatch)
+		found += n
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return found, ctxErr
+		}
+		if err != nil {
+			c.logger.Warn("%v", err)
+		}
+	}
+	return found, nil
+}
+
+// scanCommentThread 由新到旧翻阅频道帖子 post 的评论区（关联讨论组中的回复线程），
+// 按任务过滤器（match）筛选并分发下载；评论媒体记录所属帖子（PostChatID/PostID），下载时归入该帖子目录
+func (c *Client) scanCommentThread(
+	ctx context.Context, td *tdclient.Client, spec *downloader.HistorySpec, match *downloader.MediaMatcher,
+	post *tdclient.Message, dispatch func(*downloader.MediaInfo) error,
+) (found int64, err error) {
+	var fromMsgID int64
+	emptyStreak := 0
+	for {
+		msgs, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.Messages, error) {
+			return td.GetMessageThreadHistory(cc, &tdclient.GetMessageThreadHistoryRequest{
+				ChatId:        spec.ChatID,
+				MessageId:     post.Id,
+				FromMessageId: fromMsgID,
+				Limit:         DefaultMessageLimit,
+			})
+		})
+		if err != nil {
+			return found, fmt.Errorf("获取帖子 %d 的评论失败: %w", post.Id, err)
+		}
+		pageMsgs := msgs.Messages
+		if fromMsgID != 0 && len(pageMsgs) > 0 && pageMsgs[0].Id == fromMsgID {
+			pageMsgs = pageMsgs[1:]
+		}
+		if len(pageMsgs) == 0 {
+			// 首页为空多半是 TDLib 尚未从服务器拉取该线程，短暂退避重试；翻页后的空页即已到底
+			if fromMsgID != 0 || emptyStreak >= emptyThreadRetries {
+				return found, nil
+			}
+			emptyStreak++
+			select {
+			case <-ctx.Done():
+				return found, ctx.Err()
+			case <-time.After(emptyHistorySleep):
+			}
+			continue
+		}
+
+		var media []*downloader.MediaInfo
+		for _, m := range pageMsgs {
+			if isCommentThreadRoot(m, spec.ChatID, post.Id) {
+				continue
+			}
+			if mi := c.extractMediaInfo(m); mi != nil && match.Match(mi) {
+				mi.TaskID = spec.TaskID
+				mi.PostChatID, mi.PostID = spec.ChatID, post.Id
+				media = append(media, mi)
+			}
+		}
+		for _, m := range media {
+			if err := dispatch(m); err != nil {
+				return found, err
+			}
+		}
+		found += int64(len(media))
+		fromMsgID = pageMsgs[len(pageMsgs)-1].Id
+	}
+}
+
+// commentCount 返回频道帖子评论区的回复数；非频道帖子或频道未关联讨论组时为 0
+func commentCount(m *tdclient.Message) int32 {
+	if !m.IsChannelPost || m.InteractionInfo == nil || m.InteractionInfo.ReplyInfo == nil {
+		return 0
+	}
+	return m.InteractionInfo.ReplyInfo.ReplyCount
+}
+
+// isCommentThreadRoot 报告讨论组消息是否为帖子自动转发进讨论组的线程起点：
+// 其媒体就是帖子本身的媒体，已随帖子下载，不应再作为评论重复下载
+func isCommentThreadRoot(m *tdclient.Message, channelID, postID int64) bool {
+	if m.ForwardInfo == nil {
+		return false
+	}
+	if src := m.ForwardInfo.Source; src != nil && src.ChatId == channelID && src.MessageId == postID {
+		return true
+	}
+	origin, ok := m.ForwardInfo.Origin.(*tdclient.MessageOriginChannel)
+	return ok && origin.ChatId == channelID && origin.MessageId == postID
+}
+
+// DownloadStories 下载聊天（用户或频道）当前账号可见的快拍媒体：活跃快拍、主页置顶/保存的快拍，
+// 以及归档快拍（需管理快拍的权限，无权限时跳过）。快拍按 story id 合并去重后逐条获取完整内容，
+// 经任务过滤器筛选后下载到 chat_<id>/stories；下载记录以 downloader.StoryMessageID 作为消息 id
+func (c *Client) DownloadStories(ctx context.Context, spec *downloader.HistorySpec) error {
+	td := c.client()
+	if td == nil {
+		return errors.New("TDLib 未连接")
+	}
+	c.logger.Info("开始下载聊天 %d 的快拍", spec.ChatID)
+
+	ids, err := c.listStoryIDs(ctx, td, spec.ChatID)
+	if err != nil {
+		return err
+	}
+
+	var media []*downloader.MediaInfo
+	match := spec.Filters.Matcher()
+	for i, id := range ids {
+		story, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.Story, error) {
+			return td.GetStory(cc, &tdclient.GetStoryRequest{StoryPosterChatId: spec.ChatID, StoryId: id})
+		})
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			c.logger.Warn("获取快拍 %d 失败: %v", id, err)
+			continue
+		}
+		if mi := storyMedia(story); mi != nil && match.Match(mi) {
+			mi.TaskID = spec.TaskID
+			media = append(media, mi)
+		}
+		c.reportScanProgress(spec.TaskID, int64(i+1), int64(len(media)), 0)
+	}
+	c.logger.Info("快拍扫描完成: 共 %d 个快拍，发现 %d 个媒体", len(ids), len(media))
+	return c.downloadAll(ctx, media)
+}
+
+// downloadAll 并发下载一批已收集好的媒体（并发度由 downloader 限流），全部结束后返回；
+// 单个文件失败只记日志（已写入下载历史），任务被取消时返回 ctx 错误
+func (c *Client) downloadAll(ctx context.Context, media []*downloader.MediaInfo) error {
+	c.downloader.PlanBatch(media)
+	var wg sync.WaitGroup
+	for _, mi := range media {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.downloader.DownloadMedia(ctx, mi); err != nil {
+				c.logger.Error("下载媒体文件失败: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.downloader.PrintStats()
+	return nil
+}
+
+// listStoryIDs 汇总聊天的活跃、主页与归档快拍 id（去重，按 id 升序）。
+// 活跃快拍列表失败视为聊天不可访问直接返回错误；归档快拍仅对有管理权限的聊天可用，失败只记调试日志
+func (c *Client) listStoryIDs(ctx context.Context, td *tdclient.Client, chatID int64) ([]int32, error) {
+	seen := make(map[int32]struct{})
+	active, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.ChatActiveStories, error) {
+		return td.GetChatActiveStories(cc, &tdclient.GetChatActiveStoriesRequest{ChatId: chatID})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("获取聊天 %d 的快拍失败: %w", chatID, err)
+	}
+	for _, info := range active.Stories {
+		if info != nil && !info.IsLive {
+			seen[info.StoryId] = struct{}{}
+		}
+	}
+
+	pages := []struct {
+		name string
+		page func(cc context.Context, fromID int32) (*tdclient.Stories, error)
+	}{
+		{"主页", func(cc context.Context, fromID int32) (*tdclient.Stories, error) {
+			return td.GetChatPostedToChatPageStories(cc, &tdclient.GetChatPostedToChatPageStoriesRequest{
+				ChatId: chatID, FromStoryId: fromID, Limit: DefaultMessageLimit,
+			})
+		}},
+		{"归档", func(cc context.Context, fromID int32) (*tdclient.Stories, error) {
+			return td.GetChatArchivedStories(cc, &tdclient.GetChatArchivedStoriesRequest{
+				ChatId: chatID, FromStoryId: fromID, Limit: DefaultMessageLimit,
+			})
+		}},
+	}
+	for _, p := range pages {
+		if err := collectStoryPages(ctx, p.page, seen); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			c.logger.Debug("获取聊天 %d 的%s快拍失败: %v", chatID, p.name, err)
+		}
+	}
+
+	ids := make([]int32, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// collectStoryPages 由新到旧翻页收集快拍 id 到 seen；首页可能先返回置顶快拍，
+// 故下一页起点取本页最小 id，且不再返回新 id 时停止
+func collectStoryPages(
+	ctx context.Context, page func(cc context.Context, fromID int32) (*tdclient.Stories, error), seen map[int32]struct{},
+) error {
+	var fromID int32
+	for {
+		stories, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.Stories, error) {
+			return page(cc, fromID)
+		})
+		if err != nil {
+			return err
+		}
+		added := false
+		next := fromID
+		for _, st := range stories.Stories {
+			if st == nil {
+				continue
+			}
+			if _, ok := seen[st.Id]; !ok {
+				seen[st.Id] = struct{}{}
+				added = true
+			}
+			if next == 0 || st.Id < next {
+				next = st.Id
+			}
+		}
+		if !added || next == fromID {
+			return nil
+		}
+		fromID = next
+	}
+}
+
+// storyMedia 提取快拍的照片/视频；直播快拍与不支持的内容返回 nil
+func storyMedia(st *tdclient.Story) *downloader.MediaInfo {
+	var (
+		f              *tdclient.File
+		mediaType, ext string
+		mime           string
+	)
+	switch content := st.Content.(type) {
+	case *tdclient.StoryContentPhoto:
+		f, mediaType, ext, mime = largestPhotoFile(content.Photo), mediaTypePhoto, "jpg", "image/jpeg"
+	case *tdclient.StoryContentVideo:
+		if content.Video == nil {
+			return nil
+		}
+		f, mediaType, ext, mime = content.Video.Video, mediaTypeVideo, "mp4", "video/mp4"
+	default:
+		return nil
+	}
+	if f == nil {
+		return nil
+	}
+	var uniqueID, caption string
+	if f.Remote != nil {
+		uniqueID = f.Remote.UniqueId
+	}
+	if st.Caption != nil {
+		caption = st.Caption.Text
+	}
+	storyID := int64(st.Id)
+	return &downloader.MediaInfo{
+		MessageID: downloader.StoryMessageID(storyID),
+		StoryID:   storyID,
+		TDFileID:  f.Id,
+		UniqueID:  uniqueID,
+		MediaType: mediaType,
+		FileName:  fmt.Sprintf("story_%d_%d.%s", st.PosterChatId, storyID, ext),
+		FileSize:  fileSize(f),
+		MimeType:  mime,
+		ChatID:    st.PosterChatId,
+		Date:      time.Unix(int64(st.Date), 0),
+		Caption:   caption,
+		SenderID:  senderID(st.PosterId),
+	}
+}
+
+// DownloadAvatars 下载聊天的头像历史（私聊为对方的个人头像历史）；spec.Members 时一并下载成员的个人头像，
+// 成员列表不可见（如非管理员的频道）只记警告。头像以 avatar 媒体类型写入下载历史，
+// 消息 id 由照片 id 派生（downloader.AvatarMessageID），重复运行按已存在文件跳过
+func (c *Client) DownloadAvatars(ctx context.Context, spec *downloader.HistorySpec) error {
+	td := c.client()
+	if td == nil {
+		return errors.New("TDLib 未连接")
+	}
+	chat, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.Chat, error) {
+		return td.GetChat(cc, &tdclient.GetChatRequest{ChatId: spec.ChatID})
+	})
+	if err != nil {
+		return fmt.Errorf("无法访问聊天 %d: %w", spec.ChatID, err)
+	}
+	c.logger.Info("开始下载聊天 %d 的头像", spec.ChatID)
+
+	photos, err := c.chatPhotoHistory(ctx, td, chat)
+	if err != nil {
+		return err
+	}
+	var media []*downloader.MediaInfo
+	for _, p := range photos {
+		if mi := avatarMedia(spec.ChatID, 0, p); mi != nil {
+			media = append(media, mi)
+		}
+	}
+
+	if spec.Members {
+		userIDs, err := c.chatMemberIDs(ctx, td, chat)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			c.logger.Warn("获取聊天 %d 成员列表失败，跳过成员头像: %v", spec.ChatID, err)
+		}
+		for i, uid := range userIDs {
+			photos, err := c.userProfilePhotos(ctx, td, uid)
+			if err != nil {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return ctxErr
+				}
+				c.logger.Warn("获取用户 %d 的头像失败: %v", uid, err)
+				continue
+			}
+			for _, p := range photos {
+				if mi := avatarMedia(spec.ChatID, uid, p); mi != nil {
+					media = append(media, mi)
+				}
+			}
+			c.reportScanProgress(spec.TaskID, int64(i+1), int64(len(media)), 0)
+		}
+	}
+	// 按日期、大小与文件名/MIME 过滤（其余条件在创建任务时已拒绝）
+	matched, match := media[:0], spec.Filters.Matcher()
+	for _, mi := range media {
+		if match.Match(mi) {
+			mi.TaskID = spec.TaskID
+			matched = append(matched, mi)
+		}
+	}
+	if skipped := len(media) - len(matched); skipped > 0 {
+		c.logger.Info("头像过滤跳过 %d 张", skipped)
+	}
+	media = matched
+	c.logger.Info("头像扫描完成: 发现 %d 张头像", len(media))
+	return c.downloadAll(ctx, media)
+}
+
+// chatPhotoHistory 返回聊天的头像历史（按照片 id 去重）：私聊取对方的个人头像列表；
+// 群组/频道取历史中的"更换头像"服务消息，并补上当前头像（服务消息可能已被删除）
+func (c *Client) chatPhotoHistory(ctx context.Context, td *tdclient.Client, chat *tdclient.Chat) ([]*tdclient.ChatPhoto, error) {
+	var current *tdclient.ChatPhoto
+	switch t := chat.Type.(type) {
+	case *tdclient.ChatTypePrivate:
+		return c.userProfilePhotos(ctx, td, t.UserId)
+	case *tdclient.ChatTypeBasicGroup:
+		if info, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.BasicGroupFullInfo, error) {
+			return td.GetBasicGroupFullInfo(cc, &tdclient.GetBasicGroupFullInfoRequest{BasicGroupId: t.BasicGroupId})
+		}); err == nil {
+			current = info.Photo
+		}
+	case *tdclient.ChatTypeSupergroup:
+		if info, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.SupergroupFullInfo, error) {
+			return td.GetSupergroupFullInfo(cc, &tdclient.GetSupergroupFullInfoRequest{SupergroupId: t.SupergroupId})
+		}); err == nil {
+			current = info.Photo
+		}
+	}
+
+	var photos []*tdclient.ChatPhoto
+	seen := make(map[int64]bool)
+	add := func(p *tdclient.ChatPhoto) {
+		if p != nil && !seen[int64(p.Id)] {
+			seen[int64(p.Id)] = true
+			photos = append(photos, p)
+		}
+	}
+	add(current)
+	var fromMsgID int64
+	for {
+		found, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.FoundChatMessages, error) {
+			return td.SearchChatMessages(cc, &tdclient.SearchChatMessagesRequest{
+				ChatId:        chat.Id,
+				FromMessageId: fromMsgID,
+				Limit:         DefaultMessageLimit,
+				Filter:        &tdclient.SearchMessagesFilterChatPhoto{},
+			})
+		})
+		if err != nil {
+			return nil, fmt.Errorf("获取聊天 %d 的头像历史失败: %w", chat.Id, err)
+		}
+		for _, m := range found.Messages {
+			if change, ok := m.Content.(*tdclient.MessageChatChangePhoto); ok {
+				add(change.Photo)
+			}
+		}
+		if found.NextFromMessageId == 0 || len(found.Messages) == 0 {
+			return photos, nil
+		}
+		fromMsgID = found.NextFromMessageId
+	}
+}
+
+// userProfilePhotos 分页获取用户的全部个人头像（由新到旧）
+func (c *Client) userProfilePhotos(ctx context.Context, td *tdclient.Client, userID int64) ([]*tdclient.ChatPhoto, error) {
+	var photos []*tdclient.ChatPhoto
+	for {
+		page, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.ChatPhotos, error) {
+			return td.GetUserProfilePhotos(cc, &tdclient.GetUserProfilePhotosRequest{
+				UserId: userID, Offset: int32(len(photos)), Limit: maxProfilePhotoPage,
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+		photos = append(photos, page.Photos...)
+		if len(page.Photos) == 0 || len(photos) >= int(page.TotalCount) {
+			return photos, nil
+		}
+	}
+}
+
+// chatMemberIDs 列出群组/频道中可见的成员用户 id（不含以频道身份出现的成员）；
+// 超级群组按服务端允许的上限分页（大群只能取到部分成员），私聊无成员可列
+func (c *Client) chatMemberIDs(ctx context.Context, td *tdclient.Client, chat *tdclient.Chat) ([]int64, error) {
+	var members []*tdclient.ChatMember
+	switch t := chat.Type.(type) {
+	case *tdclient.ChatTypeBasicGroup:
+		info, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.BasicGroupFullInfo, error) {
+			return td.GetBasicGroupFullInfo(cc, &tdclient.GetBasicGroupFullInfoRequest{BasicGroupId: t.BasicGroupId})
+		})
+		if err != nil {
+			return nil, err
+		}
+		members = info.Members
+	case *tdclient.ChatTypeSupergroup:
+		for {
+			page, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.ChatMembers, error) {
+				return td.GetSupergroupMembers(cc, &tdclient.GetSupergroupMembersRequest{
+					SupergroupId: t.SupergroupId, Offset: int32(len(members)), Limit: maxMemberPage,
+				})
+			})
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, page.Members...)
+			if len(page.Members) == 0 || len(members) >= int(page.TotalCount) {
+				break
+			}
+		}
+	default:
+		return nil, nil
+	}
+
+	ids := make([]int64, 0, len(members))
+	seen := make(map[int64]bool)
+	for _, m := range members {
+		if u, ok := m.MemberId.(*tdclient.MessageSenderUser); ok && !seen[u.UserId] {
+			seen[u.UserId] = true
+			ids = append(ids, u.UserId)
+		}
+	}
+	return ids, nil
+}
+
+// avatarMedia 提取头像文件：动态头像取其 MPEG4 动画，否则取最大尺寸的 JPEG；
+// userID 非 0 时为该成员的个人头像，文件名以头像所属者与照片 id 命名，重复运行路径稳定
+func avatarMedia(chatID, userID int64, p *tdclient.ChatPhoto) *downloader.MediaInfo {
+	owner := chatID
+	if userID != 0 {
+		owner = userID
+	}
+	photoID := int64(p.Id)
+	f, name, mime := largestPhotoFile(&tdclient.Photo{Sizes: p.Sizes}), fmt.Sprintf("avatar_%d_%d.jpg", owner, photoID), "image/jpeg"
+	if p.Animation != nil && p.Animation.File != nil {
+		f, name, mime = p.Animation.File, fmt.Sprintf("avatar_%d_%d.mp4", owner, photoID), "video/mp4"
+	}
+	if f == nil {
+		return nil
+	}
+	var uniqueID string
+	if f.Remote != nil {
+		uniqueID = f.Remote.UniqueId
+	}
+	return &downloader.MediaInfo{
+		MessageID:    downloader.AvatarMessageID(photoID),
+		TDFileID:     f.Id,
+		UniqueID:     uniqueID,
+		MediaType:    mediaTypeAvatar,
+		FileName:     name,
+		FileSize:     fileSize(f),
+		MimeType:     mime,
+		ChatID:       chatID,
+		Date:         time.Unix(int64(p.AddedDate), 0),
+		AvatarUserID: userID,
+	}
+}
+
+// stickerSetManifest 是贴纸包清单（stickers/<包名>/manifest.json）
+type stickerSetManifest struct {
+	ID       int64                 `json:"id"`
+	Name     string                `json:"name"`
+	Title    string                `json:"title"`
+	Stickers []stickerManifestItem `json:"stickers"`
+}
+
+// stickerManifestItem 是清单中的一个贴纸：文件名与对应 emoji
+type stickerManifestItem struct {
+	ID       int64    `json:"id"`
+	File     string   `json:"file"`
+	Emoji    string   `json:"emoji,omitempty"`
+	Emojis   []string `json:"emojis,omitempty"`
+	UniqueID string   `json:"unique_id,omitempty"`
+}
+
+// DownloadStickerSet 下载整个贴纸包（spec.ChatID 为贴纸包 id）到 stickers/<包名>/，并写入含标题与 emoji 映射的清单。
+// 贴纸经 downloader 流水线下载，已下载过的同一文件（unique_id 相同）由去重查询复制而非重新下载
+func (c *Client) DownloadStickerSet(ctx context.Context, spec *downloader.HistorySpec) error {
+	td := c.client()
+	if td == nil {
+		return errors.New("TDLib 未连接")
+	}
+	set, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.StickerSet, error) {
+		return td.GetStickerSet(cc, &tdclient.GetStickerSetRequest{SetId: tdclient.JsonInt64(spec.ChatID)})
+	})
+	if err != nil {
+		return fmt.Errorf("获取贴纸包 %d 失败: %w", spec.ChatID, err)
+	}
+	c.logger.Info("开始下载贴纸包 %s（%s），共 %d 个贴纸", set.Title, set.Name, len(set.Stickers))
+
+	manifest := stickerSetManifest{ID: int64(set.Id), Name: set.Name, Title: set.Title}
+	var media []*downloader.MediaInfo
+	for i, st := range set.Stickers {
+		mi := stickerSetMedia(set, st)
+		if mi == nil {
+			continue
+		}
+		mi.TaskID = spec.TaskID
+		media = append(media, mi)
+		item := stickerManifestItem{ID: int64(st.Id), File: mi.FileName, Emoji: st.Emoji, UniqueID: mi.UniqueID}
+		if i < len(set.Emojis) && set.Emojis[i] != nil {
+			item.Emojis = set.Emojis[i].Emojis
+		}
+		manifest.Stickers = append(manifest.Stickers, item)
+	}
+	if err := c.downloader.WriteStickerSetManifest(set.Name, manifest); err != nil {
+		c.logger.Warn("%v", err)
+	}
+	return c.downloadAll(ctx, media)
+}
+
+// stickerSetMedia 提取贴纸包中的单个贴纸；记录键为 (贴纸包 id, 贴纸 id)，文件名以贴纸 id 命名保证重复运行路径稳定
+func stickerSetMedia(set *tdclient.StickerSet, st *tdclient.Sticker) *downloader.MediaInfo {
+	if st == nil || st.Sticker == nil {
+		return nil
+	}
+	ext, mime := stickerFormat(st)
+	var uniqueID string
+	if st.Sticker.Remote != nil {
+		uniqueID = st.Sticker.Remote.UniqueId
+	}
+	return &downloader.MediaInfo{
+		MessageID:  int64(st.Id),
+		TDFileID:   st.Sticker.Id,
+		UniqueID:   uniqueID,
+		MediaType:  mediaTypeSticker,
+		FileName:   fmt.Sprintf("sticker_%d.%s", int64(st.Id), ext),
+		FileSize:   fileSize(st.Sticker),
+		MimeType:   mime,
+		ChatID:     int64(set.Id),
+		Date:       time.Now(),
+		Caption:    st.Emoji,
+		StickerSet: set.Name,
+	}
+}
+
+// searchPageSize 是全局搜索单页请求的消息数（TDLib 上限 100，实际返回数由服务端决定）
+const searchPageSize = 50
+
+// SearchResult 是全局搜索命中的一条媒体消息，供前端勾选后创建 search 任务
+type SearchResult struct {
+	ChatID    int64  `json:"chat_id"`
+	ChatTitle string `json:"chat_title"`
+	MessageID int64  `json:"message_id"`
+	Date      int64  `json:"date"`
+	MediaType string `json:"media_type"`
+	FileName  string `json:"file_name"`
+	FileSize  int64  `json:"file_size"`
+	Caption   string `json:"caption,omitempty"`
+}
+
+// SearchPage 是全局搜索的一页结果；NextOffset 为空表示没有更多结果
+type SearchPage struct {
+	Results    []SearchResult `json:"results"`
+	NextOffset string         `json:"next_offset,omitempty"`
+	// TotalCount 是服务端估计的命中消息总数（含无媒体的消息），-1 表示未知
+	TotalCount int32 `json:"total_count"`
+}
+
+// SearchGlobal 在全部聊天（不含私密聊天）中按关键词搜索消息，只返回带可下载媒体的命中；
+// mediaType 非空时以对应的服务端过滤器限定类型，offset 为上一页返回的 NextOffset
+func (c *Client) SearchGlobal(ctx context.Context, query, mediaType, offset string) (SearchPage, error) {
+	td := c.client()
+	if td == nil {
+		return SearchPage{}, errors.New("TDLib 未连接")
+	}
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return SearchPage{}, errors.New("搜索关键词不能为空")
+	}
+	var filter tdclient.SearchMessagesFilter
+	if mediaType != "" {
+		f, ok := historyCountFilters[mediaType]
+		if !ok {
+			return SearchPage{}, fmt.Errorf("媒体类型 %s 不支持全局搜索", mediaType)
+		}
+		filter = f
+	}
+	found, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.FoundMessages, error) {
+		return td.SearchMessages(cc, &tdclient.SearchMessagesRequest{
+			Query: query, Offset: offset, Limit: searchPageSize, Filter: filter,
+		})
+	})
+	if err != nil {
+		return SearchPage{}, fmt.Errorf("全局搜索失败: %w", err)
+	}
+
+	page := SearchPage{Results: []SearchResult{}, NextOffset: found.NextOffset, TotalCount: found.TotalCount}
+	titles := make(map[int64]string)
+	for _, m := range found.Messages {
+		mi := c.extractMediaInfo(m)
+		if mi == nil {
+			continue
+		}
+		title, ok := titles[m.ChatId]
+		if !ok {
+			if chat, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.Chat, error) {
+				return td.GetChat(cc, &tdclient.GetChatRequest{ChatId: m.ChatId})
+			}); err == nil {
+				title = chat.Title
+			}
+			titles[m.ChatId] = title
+		}
+		page.Results = append(page.Results, SearchResult{
+			ChatID: m.ChatId, ChatTitle: title, MessageID: m.Id, Date: int64(m.Date),
+			MediaType: mi.MediaType, FileName: mi.FileName, FileSize: mi.FileSize, Caption: mi.Caption,
+		})
+	}
+	return page, nil
+}
+
+// DownloadMessages 下载 search 任务所选的跨聊天消息：逐条重新获取消息（文件引用可能已过期），
+// 经任务过滤器筛选后下载到各自聊天的目录；已删除或不可访问的消息只记警告
+func (c *Client) DownloadMessages(ctx context.Context, spec *downloader.HistorySpec) error {
+	td := c.client()
+	if td == nil {
+		return errors.New("TDLib 未连接")
+	}
+	var media []*downloader.MediaInfo
+	match := spec.Filters.Matcher()
+	for i, ref := range spec.Messages {
+		msg, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.Message, error) {
+			return td.GetMessage(cc, &tdclient.GetMessageRequest{ChatId: ref.ChatID, MessageId: ref.MessageID})
+		})
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			c.logger.Warn("获取聊天 %d 的消息 %d 失败: %v", ref.ChatID, ref.MessageID, err)
+			continue
+		}
+		if mi := c.extractMediaInfo(msg); mi != nil && match.Match(mi) {
+			mi.TaskID = spec.TaskID
+			media = append(media, mi)
+		}
+		c.reportScanProgress(spec.TaskID, int64(i+1), int64(len(media)), 0)
+	}
+	c.logger.Info("搜索结果获取完成: 所选 %d 条消息，发现 %d 个媒体", len(spec.Messages), len(media))
+	return c.downloadAll(ctx, media)
 }
 
 // reportScanProgress 上报历史扫描进度与游标；未注册回调（CLI 模式）或无任务 ID 时静默
@@ -1364,18 +2911,226 @@ func (c *Client) reportScanProgress(taskID string, scannedMessages, foundMedia,
 	c.scanProgressFunc(taskID, scannedMessages, foundMedia, scanCursor)
 }
 
-// fetchHistoryPage 拉取一页历史消息，并剔除 Offset:0 时 TDLib 附带返回的
-// FromMessageId 边界消息本身（非首次请求时），避免重复处理及"仅剩边界消息"导致的死循环
-func fetchHistoryPage(ctx context.Context, td *tdclient.Client, chatID, fromMsgID int64, limit int32) ([]*tdclient.Message, error) {
-	msgs, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.Messages, error) {
+// historyPager 逐页产出聊天历史（默认新到旧，ascending 时旧到新）；返回空页表示已翻到底
+type historyPager interface {
+	next(ctx context.Context) ([]*tdclient.Message, error)
+}
+
+// chatHistoryPager 以 GetChatHistory（限定话题时为 GetForumTopicHistory）逐页翻阅全部历史（含文本消息），
+// 冷缓存空页按 awaitNextHistoryPage 退避。
+// 正序翻页时只在尚未取到任何消息前退避：此后的空页即已到达最新消息
+type chatHistoryPager struct {
+	td          *tdclient.Client
+	chatID      int64
+	topicID     int64 // 论坛话题 id，0 = 整个聊天
+	fromMsgID   int64 // 倒序：0 = 从最新开始；正序：已处理的最新消息 id
+	limit       int32
+	ascending   bool
+	started     bool
+	emptyStreak int
+}
+
+func (p *chatHistoryPager) next(ctx context.Context) ([]*tdclient.Message, error) {
+	for {
+		var pageMsgs []*tdclient.Message
+		var err error
+		if p.ascending {
+			pageMsgs, err = fetchHistoryPageForward(ctx, p.td, p.chatID, p.topicID, p.fromMsgID, p.limit)
+		} else {
+			pageMsgs, err = fetchHistoryPage(ctx, p.td, p.chatID, p.topicID, p.fromMsgID, p.limit)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(pageMsgs) > 0 {
+			p.started = true
+			p.emptyStreak = 0
+			p.fromMsgID = pageMsgs[len(pageMsgs)-1].Id
+			return pageMsgs, nil
+		}
+		if p.ascending && p.started {
+			return nil, nil
+		}
+		p.emptyStreak++
+		stop, err := awaitNextHistoryPage(ctx, p.emptyStreak)
+		if err != nil || stop {
+			return nil, err
+		}
+	}
+}
+
+// searchFiltersFor 返回所选媒体类型对应的服务端搜索过滤器。未限定类型（空）或选中无搜索过滤器的类型（贴纸）时
+// 返回 ok=false，调用方须逐页翻阅全部历史：服务端搜索只命中原生媒体消息，贴纸、付费媒体与网页预览中的媒体
+// 仅在逐页翻阅时发现，未限定类型的完整归档不能漏掉它们
+func searchFiltersFor(mediaTypes []string) (filters []tdclient.SearchMessagesFilter, ok bool) {
+	if len(mediaTypes) == 0 {
+		return nil, false
+	}
+	for _, t := range mediaTypes {
+		f, found := historyCountFilters[t]
+		if !found {
+			return nil, false
+		}
+		filters = append(filters, f)
+	}
+	return filters, true
+}
+
+// searchScope 是聊天内搜索在媒体类型之外的服务端限定条件，零值 = 只按媒体类型过滤
+type searchScope struct {
+	query    string // 关键词/话题标签
+	senderID int64  // 发送者（用户 id 为正，聊天 id 为负），0 = 不限
+}
+
+// messageSenderOf 是 senderID 的逆操作：按 id 符号构造用户或聊天发送者，0 返回 nil（不限）
+func messageSenderOf(id int64) tdclient.MessageSender {
+	switch {
+	case id > 0:
+		return &tdclient.MessageSenderUser{UserId: id}
+	case id < 0:
+		return &tdclient.MessageSenderChat{ChatId: id}
+	default:
+		return nil
+	}
+}
+
+// searchStream 是单个搜索过滤器的翻页状态：buf 为已取回未产出的结果（与翻页方向同序）
+type searchStream struct {
+	filter    tdclient.SearchMessagesFilter
+	fromMsgID int64
+	buf       []*tdclient.Message
+	exhausted bool
+}
+
+// searchHistoryPager 以 SearchChatMessages 按媒体类型分别翻页，多路结果按消息 id 归并。
+// 每页只产出"所有未翻完的过滤器都已越过"的消息，保证产出严格有序，游标与日期截止语义与逐页翻阅一致
+type searchHistoryPager struct {
+	td        *tdclient.Client
+	chatID    int64
+	topicID   int64 // 论坛话题 id，0 = 整个聊天
+	scope     searchScope
+	limit     int32
+	ascending bool
+	streams   []*searchStream
+}
+
+func newSearchHistoryPager(
+	td *tdclient.Client, chatID, topicID, fromMsgID int64, limit int32, ascending bool,
+	scope searchScope, filters []tdclient.SearchMessagesFilter,
+) *searchHistoryPager {
+	p := &searchHistoryPager{td: td, chatID: chatID, topicID: topicID, scope: scope, limit: limit, ascending: ascending}
+	for _, f := range filters {
+		p.streams = append(p.streams, &searchStream{filter: f, fromMsgID: fromMsgID})
+	}
+	return p
+}
+
+// before 报告 a 是否按翻页方向排在 b 之前
+func (p *searchHistoryPager) before(a, b int64) bool {
+	if p.ascending {
+		return a < b
+	}
+	return a > b
+}
+
+func (p *searchHistoryPager) next(ctx context.Context) ([]*tdclient.Message, error) {
+	// 补齐各路缓冲，并求出可安全产出的边界：未翻完的各路中缓冲末条消息里翻页方向上最靠前的一条
+	var bound int64
+	bounded := false
+	for _, s := range p.streams {
+		if len(s.buf) == 0 && !s.exhausted {
+			if err := p.fill(ctx, s); err != nil {
+				return nil, err
+			}
+		}
+		if !s.exhausted && len(s.buf) > 0 {
+			if tail := s.buf[len(s.buf)-1].Id; !bounded || p.before(tail, bound) {
+				bound, bounded = tail, true
+			}
+		}
+	}
+
+	var page []*tdclient.Message
+	for _, s := range p.streams {
+		n := 0
+		for n < len(s.buf) && (!bounded || !p.before(bound, s.buf[n].Id)) {
+			n++
+		}
+		page = append(page, s.buf[:n]...)
+		s.buf = s.buf[n:]
+	}
+	sort.Slice(page, func(i, j int) bool { return p.before(page[i].Id, page[j].Id) })
+	return page, nil
+}
+
+// fill 取回单路的下一页搜索结果；空页（或仅剩边界消息）即视为该路已翻完
+func (p *searchHistoryPager) fill(ctx context.Context, s *searchStream) error {
+	req := &tdclient.SearchChatMessagesRequest{
+		ChatId:        p.chatID,
+		TopicId:       topicFilter(p.topicID),
+		FromMessageId: s.fromMsgID,
+		Offset:        0,
+		Query:         p.scope.query,
+		SenderId:      messageSenderOf(p.scope.senderID),
+		Limit:         p.limit,
+		Filter:        s.filter,
+	}
+	if p.ascending { // 负偏移取起点及更新的消息
+		req.Offset = -(p.limit - 1)
+	}
+	found, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.FoundChatMessages, error) {
+		return p.td.SearchChatMessages(cc, req)
+	})
+	if err != nil {
+		if s.filter == nil {
+			return fmt.Errorf("搜索聊天消息失败: %w", err)
+		}
+		return fmt.Errorf("搜索聊天媒体失败 (%s): %w", s.filter.SearchMessagesFilterConstructor(), err)
+	}
+	for _, m := range found.Messages {
+		if s.fromMsgID == 0 || p.before(s.fromMsgID, m.Id) {
+			s.buf = append(s.buf, m)
+		}
+	}
+	if len(s.buf) == 0 {
+		s.exhausted = true
+		return nil
+	}
+	sort.Slice(s.buf, func(i, j int) bool { return p.before(s.buf[i].Id, s.buf[j].Id) })
+	s.fromMsgID = s.buf[len(s.buf)-1].Id
+	return nil
+}
+
+// getHistory 拉取聊天（topicID 非 0 时为该论坛话题）自 fromMsgID 起的一页历史消息
+func getHistory(
+	ctx context.Context, td *tdclient.Client, chatID, topicID, fromMsgID int64, offset, limit int32,
+) (*tdclient.Messages, error) {
+	return tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.Messages, error) {
+		if topicID != 0 {
+			return td.GetForumTopicHistory(cc, &tdclient.GetForumTopicHistoryRequest{
+				ChatId:        chatID,
+				ForumTopicId:  int32(topicID),
+				FromMessageId: fromMsgID,
+				Offset:        offset,
+				Limit:         limit,
+			})
+		}
 		return td.GetChatHistory(cc, &tdclient.GetChatHistoryRequest{
 			ChatId:        chatID,
 			FromMessageId: fromMsgID,
-			Offset:        0,
+			Offset:        offset,
 			Limit:         limit,
 			OnlyLocal:     false,
 		})
 	})
+}
+
+// fetchHistoryPage 拉取一页历史消息，并剔除 Offset:0 时 TDLib 附带返回的
+// FromMessageId 边界消息本身（非首次请求时），避免重复处理及"仅剩边界消息"导致的死循环
+func fetchHistoryPage(
+	ctx context.Context, td *tdclient.Client, chatID, topicID, fromMsgID int64, limit int32,
+) ([]*tdclient.Message, error) {
+	msgs, err := getHistory(ctx, td, chatID, topicID, fromMsgID, 0, limit)
 	if err != nil {
 		return nil, fmt.Errorf("获取消息历史失败: %w", err)
 	}
@@ -1387,24 +3142,48 @@ func fetchHistoryPage(ctx context.Context, td *tdclient.Client, chatID, fromMsgI
 	return pageMsgs, nil
 }
 
-// extractBatchMedia 从一页历史消息中提取媒体信息、按任务过滤器筛选并打上任务ID；
-// 返回本页最旧消息ID供调用方推进下一页起点，以及整页是否已早于 DateFrom（可提前停止翻页：
-// 历史页按新到旧返回，整页更旧则更早的页必然全部越界）
+// fetchHistoryPageForward 拉取 fromMsgID 之后（更新）的一页历史消息并按旧到新排列；
+// 负偏移使 TDLib 返回起点消息本身及更新的消息，起点本身已处理过，予以剔除
+func fetchHistoryPageForward(
+	ctx context.Context, td *tdclient.Client, chatID, topicID, fromMsgID int64, limit int32,
+) ([]*tdclient.Message, error) {
+	msgs, err := getHistory(ctx, td, chatID, topicID, fromMsgID, -(limit - 1), limit)
+	if err != nil {
+		return nil, fmt.Errorf("获取消息历史失败: %w", err)
+	}
+
+	pageMsgs := make([]*tdclient.Message, 0, len(msgs.Messages))
+	for _, m := range msgs.Messages {
+		if m.Id > fromMsgID {
+			pageMsgs = append(pageMsgs, m)
+		}
+	}
+	sort.Slice(pageMsgs, func(i, j int) bool { return pageMsgs[i].Id < pageMsgs[j].Id })
+	return pageMsgs, nil
+}
+
+// extractBatchMedia 从一页历史消息中提取媒体信息、按任务过滤器（match 为其预编译形式）筛选并打上任务ID；
+// 返回本页末条消息ID供调用方推进下一页起点，以及整页是否已越出日期区间（可提前停止翻页）：
+// 倒序翻页时整页早于 DateFrom、正序翻页时整页晚于 DateTo，则后续页必然全部越界
 func (c *Client) extractBatchMedia(
-	msgs []*tdclient.Message, taskID string, filters downloader.HistoryFilters,
-) (media []*downloader.MediaInfo, lastMsgID int64, pastDateFrom bool) {
-	pastDateFrom = len(msgs) > 0 && filters.DateFrom != 0
+	msgs []*tdclient.Message, taskID string, filters downloader.HistoryFilters, match *downloader.MediaMatcher, ascending bool,
+) (media []*downloader.MediaInfo, lastMsgID int64, pastDateRange bool) {
+	if ascending {
+		pastDateRange = len(msgs) > 0 && filters.DateTo != 0
+	} else {
+		pastDateRange = len(msgs) > 0 && filters.DateFrom != 0
+	}
 	for _, m := range msgs {
-		if int64(m.Date) >= filters.DateFrom {
-			pastDateFrom = false
+		if (!ascending && int64(m.Date) >= filters.DateFrom) || (ascending && int64(m.Date) <= filters.DateTo) {
+			pastDateRange = false
 		}
-		if mi := c.extractMediaInfo(m); mi != nil && filters.Match(mi.MediaType, int64(m.Date), mi.FileSize) {
+		if mi := c.extractMediaInfo(m); mi != nil && match.Match(mi) {
 			mi.TaskID = taskID
 			media = append(media, mi)
 		}
 		lastMsgID = m.Id
 	}
-	return media, lastMsgID, pastDateFrom
+	return media, lastMsgID, pastDateRange
 }
 
 // awaitNextHistoryPage 处理获取到空历史页时的退避逻辑：
@@ -1491,23 +3270,145 @@ func (c *Client) onUpdateFile(f *tdclient.File) {
 	}
 }
 
-// onNewMessage 实时监控：目标聊天的新媒体消息触发下载
+// onNewMessage 实时监控：新媒体消息按聊天路由给每个监控该聊天的任务，通过该任务过滤器的各自触发下载
 func (c *Client) onNewMessage(m *tdclient.Message) {
 	if m == nil {
 		return
 	}
-	target := c.targetChatID.Load()
-	if target == 0 || m.ChatId != target {
+	tasks := c.monitorTasksFor(m.ChatId)
+	if len(tasks) == 0 {
 		return
 	}
 	media := c.extractMediaInfo(m)
 	if media == nil {
-		c.logger.Info("📝 目标聊天新消息（无媒体）: %s", messagePreview(m))
+		c.logger.Info("📝 监控聊天新消息（无媒体）: %s", messagePreview(m))
 		return
 	}
-	media.TaskID = c.monitorTask()
-	c.logger.Info("🎬 检测到目标聊天新媒体: %s", media.FileName)
-	go func() { c.downloader.DownloadSingle(context.Background(), media) }()
+	c.logger.Info("🎬 检测到监控聊天新媒体: %s", media.FileName)
+	for taskID, match := range tasks {
+		if !match.Match(media) {
+			c.logger.Debug("监控任务 %s 过滤器未命中，跳过: %s", taskID, media.FileName)
+			continue
+		}
+		// 已注册投递回调（Web 任务队列）时交由其持久化排队、有界下载；CLI 模式直接下载
+		if c.monitorMediaFunc != nil {
+			c.monitorMediaFunc(taskID, m.ChatId, m.Id)
+			continue
+		}
+		item := *media
+		item.TaskID = taskID
+		go func() { c.downloader.DownloadSingle(context.Background(), &item) }()
+	}
+}
+
+// DownloadMonitorMessage 下载监控任务待下载队列中的一条消息：重新获取消息（TDLib 文件 id 会话本地，
+// 重启后需重取），按该任务当前过滤器复核后同步下载；消息已无媒体或被过滤时静默返回
+func (c *Client) DownloadMonitorMessage(ctx context.Context, taskID string, chatID, messageID int64) error {
+	td := c.client()
+	if td == nil {
+		return errors.New("TDLib 未连接")
+	}
+	msg, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.Message, error) {
+		return td.GetMessage(cc, &tdclient.GetMessageRequest{ChatId: chatID, MessageId: messageID})
+	})
+	if err != nil {
+		return fmt.Errorf("获取消息 %d 失败: %w", messageID, err)
+	}
+	media := c.extractMediaInfo(msg)
+	if media == nil {
+		return nil
+	}
+	c.monitorMu.RLock()
+	match := c.monitors[taskID].match
+	c.monitorMu.RUnlock()
+	if !match.Match(media) {
+		return nil
+	}
+	media.TaskID = taskID
+	c.downloader.PlanBatch([]*downloader.MediaInfo{media})
+	return c.downloader.DownloadMedia(ctx, media)
+}
+
+// LatestMessageID 返回聊天当前最新一条消息的 id（聊天为空时为 0），供增量任务在开扫前确定同步上界
+func (c *Client) LatestMessageID(ctx context.Context, chatID int64) (int64, error) {
+	td := c.client()
+	if td == nil {
+		return 0, errors.New("TDLib 未连接")
+	}
+	chat, err := tdCall(ctx, metadataTimeout, func(cc context.Context) (*tdclient.Chat, error) {
+		return td.GetChat(cc, &tdclient.GetChatRequest{ChatId: chatID})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("获取聊天 %d 信息失败: %w", chatID, err)
+	}
+	if chat.LastMessage == nil {
+		return 0, nil
+	}
+	return chat.LastMessage.Id, nil
+}
+
+// ScanMonitorGap 自聊天最新消息向旧翻页至 afterMessageID（不含），返回聊天当前最新消息 id 与
+// 其间命中该监控任务过滤器的媒体消息 id（按消息先后升序）。afterMessageID 为 0（无水位）时仅取最新消息 id
+func (c *Client) ScanMonitorGap(
+	ctx context.Context, taskID string, chatID, afterMessageID int64,
+) (newest int64, messageIDs []int64, err error) {
+	td := c.client()
+	if td == nil {
+		return 0, nil, errors.New("TDLib 未连接")
+	}
+	c.monitorMu.RLock()
+	match := c.monitors[taskID].match
+	c.monitorMu.RUnlock()
+
+	limit := int32(DefaultMessageLimit)
+	if afterMessageID == 0 {
+		limit = 1
+	}
+	var fromMsgID int64
+	emptyStreak := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, nil, err
+		}
+		pageMsgs, err := fetchHistoryPage(ctx, td, chatID, 0, fromMsgID, limit)
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(pageMsgs) == 0 {
+			emptyStreak++
+			stop, err := awaitNextHistoryPage(ctx, emptyStreak)
+			if err != nil {
+				return 0, nil, err
+			}
+			if stop {
+				break
+			}
+			continue
+		}
+		emptyStreak = 0
+		if newest == 0 {
+			newest = pageMsgs[0].Id
+		}
+		if afterMessageID == 0 {
+			break
+		}
+		reached := false
+		for _, m := range pageMsgs {
+			if m.Id <= afterMessageID {
+				reached = true
+				break
+			}
+			if mi := c.extractMediaInfo(m); mi != nil && match.Match(mi) {
+				messageIDs = append(messageIDs, m.Id)
+			}
+		}
+		if reached {
+			break
+		}
+		fromMsgID = pageMsgs[len(pageMsgs)-1].Id
+	}
+	slices.Reverse(messageIDs)
+	return newest, messageIDs, nil
 }
 
 // onConnectionState 输出连接状态变化
@@ -1518,6 +3419,9 @@ func (c *Client) onConnectionState(state tdclient.ConnectionState) {
 	switch state.(type) {
 	case *tdclient.ConnectionStateReady:
 		c.logger.Debug("TDLib 连接就绪")
+		if c.connReadyFunc != nil {
+			go c.connReadyFunc()
+		}
 	case *tdclient.ConnectionStateConnecting, *tdclient.ConnectionStateConnectingToProxy:
 		c.logger.Debug("TDLib 正在连接...")
 	case *tdclient.ConnectionStateWaitingForNetwork:
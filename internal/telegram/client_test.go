@@ -0,0 +1,61 @@
+package telegram
+
+import (
+	"testing"
+
+	tdclient "github.com/zelenin/go-tdlib/client"
+
+	"tg-down/internal/downloader"
+	"tg-down/internal/logger"
+)
+
+// TestUnfilteredScan_FindsStickerAndPaidMedia 校验未限定媒体类型的历史扫描逐页翻阅全部历史，
+// 且页内的贴纸、付费媒体与网页预览媒体都被提取下载
+func TestUnfilteredScan_FindsStickerAndPaidMedia(t *testing.T) {
+	if _, ok := searchFiltersFor(nil); ok {
+		t.Fatal("未限定媒体类型时不应走服务端搜索（搜索不到贴纸与付费媒体）")
+	}
+	if _, ok := searchFiltersFor([]string{"photo", "video"}); !ok {
+		t.Fatal("只选原生媒体类型时应走服务端搜索")
+	}
+
+	photo := func(id int32) *tdclient.Photo {
+		return &tdclient.Photo{Sizes: []*tdclient.PhotoSize{{Width: 10, Height: 10, Photo: &tdclient.File{Id: id, Size: 100}}}}
+	}
+	msgs := []*tdclient.Message{
+		{Id: 4, ChatId: 7, Content: &tdclient.MessageText{Text: &tdclient.FormattedText{Text: "hi"}}},
+		{Id: 3, ChatId: 7, Content: &tdclient.MessageText{
+			Text:        &tdclient.FormattedText{Text: "https://t.me/c/1/2"},
+			LinkPreview: &tdclient.LinkPreview{Type: &tdclient.LinkPreviewTypePhoto{Photo: photo(30)}},
+		}},
+		{Id: 2, ChatId: 7, Content: &tdclient.MessagePaidMedia{Media: []tdclient.PaidMedia{
+			&tdclient.PaidMediaPhoto{Photo: photo(20)},
+		}}},
+		{Id: 1, ChatId: 7, Content: &tdclient.MessageSticker{Sticker: &tdclient.Sticker{
+			Format: &tdclient.StickerFormatWebp{}, Sticker: &tdclient.File{Id: 10, Size: 50},
+		}}},
+	}
+
+	c := &Client{logger: logger.New(logger.LevelError)}
+	var filters downloader.HistoryFilters
+	media, lastMsgID, _ := c.extractBatchMedia(msgs, "task-1", filters, filters.Matcher(), false)
+	if lastMsgID != 1 {
+		t.Fatalf("lastMsgID = %d, want 1", lastMsgID)
+	}
+	got := make(map[int64]string, len(media))
+	for _, mi := range media {
+		got[mi.MessageID] = mi.MediaType
+		if mi.TaskID != "task-1" {
+			t.Errorf("消息 %d 未打上任务 id", mi.MessageID)
+		}
+	}
+	want := map[int64]string{1: mediaTypeSticker, 2: mediaTypePhoto, 3: mediaTypePhoto}
+	if len(got) != len(want) {
+		t.Fatalf("提取到的媒体 = %v, want %v", got, want)
+	}
+	for id, typ := range want {
+		if got[id] != typ {
+			t.Errorf("消息 %d 媒体类型 = %q, want %q", id, got[id], typ)
+		}
+	}
+}
@@ -3,12 +3,14 @@ package web
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"tg-down/internal/downloader"
 	"tg-down/internal/queue"
@@ -22,6 +24,7 @@ func (s *Server) routes(mux *http.ServeMux) {
 	mux.HandleFunc("GET /api/state", s.handleState)
 	mux.HandleFunc("GET /api/chats", s.handleChats)
 	mux.HandleFunc("POST /api/chats/refresh", s.handleChatsRefresh)
+	mux.HandleFunc("GET /api/chats/{id}/topics", s.handleChatTopics)
 	mux.HandleFunc("GET /api/events", s.handleEvents)
 	mux.HandleFunc("POST /api/auth/credentials", s.handleAuthCredentials)
 	mux.HandleFunc("POST /api/auth/code", s.handleAuthCode)
@@ -33,8 +36,11 @@ func (s *Server) routes(mux *http.ServeMux) {
 	mux.HandleFunc("GET /api/tasks", s.handleTasksList)
 	mux.HandleFunc("POST /api/tasks", s.handleTasksCreate)
 	mux.HandleFunc("POST /api/resolve", s.handleResolve)
+	mux.HandleFunc("POST /api/search", s.handleSearch)
+	mux.HandleFunc("POST /api/preview", s.handlePreview)
 	mux.HandleFunc("POST /api/tasks/{id}/cancel", s.handleTaskCancel)
 	mux.HandleFunc("POST /api/tasks/{id}/retry", s.handleTaskRetry)
+	mux.HandleFunc("POST /api/tasks/{id}/filters", s.handleTaskFilters)
 	mux.HandleFunc("GET /api/download/settings", s.handleDownloadSettings)
 	mux.HandleFunc("POST /api/download/concurrency", s.handleDownloadConcurrency)
 	mux.HandleFunc("POST /api/media/{id}/pause", s.handleMediaPause)
@@ -86,6 +92,27 @@ func (s *Server) handleChatsRefresh(w http.ResponseWriter, _ *http.Request) {
 	s.writeJSON(w, chats)
 }
 
+// handleChatTopics 列出论坛超级群组的话题，供前端为单个话题创建任务
+func (s *Server) handleChatTopics(w http.ResponseWriter, r *http.Request) {
+	chatID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "无效的聊天 id")
+		return
+	}
+	if !s.requireReady(w) {
+		return
+	}
+	topics, err := s.client.ForumTopics(r.Context(), chatID)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if topics == nil {
+		topics = []telegram.ForumTopic{}
+	}
+	s.writeJSON(w, topics)
+}
+
 func (s *Server) handleAuthCredentials(w http.ResponseWriter, r *http.Request) {
 	var body struct {
 		APIID   int    `json:"api_id"`
@@ -247,41 +274,233 @@ func (s *Server) handleTasksCreate(w http.ResponseWriter, r *http.Request) {
 	var body struct {
 		Kind   string `json:"kind"`
 		ChatID int64  `json:"chat_id"`
-		// Filters 是任务级过滤条件（仅 history 任务生效；monitor 在 v2.0 忽略过滤器）
+		// Filters 是任务级过滤条件（history/monitor/story 均生效；monitor 可经 /api/tasks/{id}/filters 运行中修改）
 		Filters downloader.HistoryFilters `json:"filters"`
 		// MessageID 非 0 时创建单消息下载任务（来自 /api/resolve 的消息链接解析）
 		MessageID int64 `json:"message_id"`
+		// RangeStart/RangeEnd 创建消息区间任务（来自 /api/resolve 的两条消息链接解析，闭区间，0 = 该端不限）
+		RangeStart int64 `json:"range_start"`
+		RangeEnd   int64 `json:"range_end"`
 		// ChatTitle 可选；公开频道可能不在缓存聊天列表中，由解析结果直接携带标题
 		ChatTitle string `json:"chat_title"`
+		// InviteLink 非空时先经邀请链接加入聊天（须 Join 显式确认，仅 history），chat_id 取加入后的聊天；
+		// LeaveAfter 为任务完成后退出该聊天，仅在本次确实新加入时生效
+		InviteLink string `json:"invite_link"`
+		Join       bool   `json:"join"`
+		LeaveAfter bool   `json:"leave_after"`
+		// Incremental 为 history 增量模式：只扫描该聊天上次同步水位之后的新消息
+		Incremental bool `json:"incremental"`
+		// OldestFirst 为 history 正序模式：由旧到新下载（不分段）
+		OldestFirst bool `json:"oldest_first"`
+		// Comments 为 history 频道评论模式：同时下载各帖子评论区的媒体
+		Comments bool `json:"comments"`
+		// SearchQuery 为 history 聊天内搜索：只下载匹配该关键词或话题标签（#tag）的消息
+		SearchQuery string `json:"search_query"`
+		// Members 为 avatar 同时下载成员的个人头像
+		Members bool `json:"members"`
+		// StickerSet 为 sticker_set 任务的贴纸包名称：贴纸包 id 常超出 JS 安全整数范围，由服务端据名称重新解析
+		StickerSet string `json:"sticker_set"`
+		// Messages 为 search 任务在搜索预览中勾选的消息（各自的真实 chat_id）
+		Messages []downloader.MessageRef `json:"messages"`
+		filterInput
+		// ScanSegments 为 history 分段并行扫描的段数（0 = 沿用配置），SegmentByDate 时按日期区间分段
+		ScanSegments  int  `json:"scan_segments"`
+		SegmentByDate bool `json:"segment_by_date"`
 	}
 	if !s.decode(w, r, &body) {
 		return
 	}
 	kind := queue.Kind(body.Kind)
-	if kind != queue.KindHistory && kind != queue.KindMonitor {
-		s.writeError(w, http.StatusBadRequest, "kind 必须为 history 或 monitor")
+	switch kind {
+	case queue.KindHistory, queue.KindMonitor, queue.KindStory, queue.KindAvatar, queue.KindStickerSet, queue.KindSearch:
+	default:
+		s.writeError(w, http.StatusBadRequest, "kind 必须为 history、monitor、story、avatar、sticker_set 或 search")
+		return
+	}
+	if kind == queue.KindSearch && (len(body.Messages) == 0 || len(body.Messages) > downloader.MaxSearchMessages) {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("search 任务须选择 1~%d 条消息", downloader.MaxSearchMessages))
+		return
+	}
+	if !s.resolveFilters(w, r, &body.Filters, body.filterInput) {
+		return
+	}
+	f := body.Filters
+	bySource := len(f.SenderIDs) > 0 || len(f.ExcludeSenderIDs) > 0 || f.ExcludeForwards || len(f.ForwardFromIDs) > 0
+	if bySource && kind != queue.KindHistory && kind != queue.KindMonitor {
+		s.writeError(w, http.StatusBadRequest, "发送者与转发来源过滤仅适用于 history 与 monitor 任务")
+		return
+	}
+	if f.HasPopularity() && kind != queue.KindHistory && kind != queue.KindSearch {
+		s.writeError(w, http.StatusBadRequest, "热度阈值仅适用于 history 与 search 任务（新消息与快拍尚无可用的互动计数）")
+		return
+	}
+	if kind == queue.KindAvatar && (len(f.MediaTypes) > 0 || f.TopicID != 0 || f.CaptionInclude != "" || f.CaptionExclude != "") {
+		// 头像没有媒体类型、话题与说明文字，这些条件只会筛掉全部头像
+		s.writeError(w, http.StatusBadRequest, "avatar 任务仅支持日期、文件大小与文件名/MIME 过滤")
+		return
+	}
+	if msg := validateRange(kind, body.MessageID, body.RangeStart, body.RangeEnd); msg != "" {
+		s.writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if body.ScanSegments < 0 || body.ScanSegments > downloader.MaxScanSegments {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("scan_segments 须在 0~%d 之间", downloader.MaxScanSegments))
 		return
 	}
-	if msg := body.Filters.Validate(); msg != "" {
+	body.SearchQuery = strings.TrimSpace(body.SearchQuery)
+	if msg := validateSearchQuery(kind, body.SearchQuery, body.MessageID, body.Comments); msg != "" {
 		s.writeError(w, http.StatusBadRequest, msg)
 		return
 	}
+	if body.InviteLink != "" && (kind != queue.KindHistory || !body.Join) {
+		s.writeError(w, http.StatusBadRequest, "邀请链接须确认加入（join）且只能创建历史下载任务")
+		return
+	}
+	if kind == queue.KindStickerSet && body.StickerSet == "" {
+		s.writeError(w, http.StatusBadRequest, "sticker_set 任务须提供贴纸包名称")
+		return
+	}
 	if !s.requireReady(w) {
 		return
 	}
 	title := body.ChatTitle
-	if title == "" {
+	joined := false
+	if kind == queue.KindStickerSet {
+		target, err := s.client.ResolveTarget(r.Context(), "https://t.me/addstickers/"+body.StickerSet)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		body.ChatID, title = target.ChatID, target.Title
+	}
+	if body.InviteLink != "" {
+		target, ok, err := s.client.JoinInviteLink(r.Context(), body.InviteLink)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		body.ChatID, joined = target.ChatID, ok
+		if title == "" {
+			title = target.Title
+		}
+		if joined {
+			go s.refreshChats(s.baseCtx)
+		}
+	}
+	switch {
+	case title != "":
+	case kind == queue.KindSearch:
+		title = "全局搜索"
+	default:
 		title = s.chatTitle(body.ChatID)
 	}
-	spec := &downloader.HistorySpec{ChatID: body.ChatID, Filters: body.Filters, MessageID: body.MessageID}
+	spec := &downloader.HistorySpec{
+		ChatID: body.ChatID, Filters: body.Filters, MessageID: body.MessageID, Incremental: body.Incremental,
+		RangeStart: body.RangeStart, RangeEnd: body.RangeEnd,
+		OldestFirst: body.OldestFirst, Comments: body.Comments, ScanSegments: body.ScanSegments, SegmentByDate: body.SegmentByDate,
+		LeaveAfter: body.LeaveAfter && joined, Members: body.Members, SearchQuery: body.SearchQuery,
+	}
+	if kind == queue.KindSearch {
+		spec.ChatID, spec.Messages = 0, body.Messages
+	}
 	dto, err := s.queue.Enqueue(kind, spec, title)
 	if err != nil {
+		if spec.LeaveAfter {
+			// 任务未能入队，刚为它加入的聊天不再需要
+			go s.leaveChatAfter(body.ChatID, "")
+		}
 		s.writeError(w, http.StatusConflict, err.Error())
 		return
 	}
 	s.writeJSON(w, dto)
 }
 
+// validateRange 校验消息区间参数，返回首个问题的描述（合法时为空串）
+func validateRange(kind queue.Kind, messageID, start, end int64) string {
+	if start == 0 && end == 0 {
+		return ""
+	}
+	switch {
+	case kind != queue.KindHistory:
+		return "消息区间仅适用于 history 任务"
+	case messageID != 0:
+		return "message_id 与消息区间不能同时指定"
+	case start < 0 || end < 0:
+		return "消息区间不能为负"
+	case end != 0 && start > end:
+		return "range_start 不能大于 range_end"
+	}
+	return ""
+}
+
+// filterInput 是任务、监控过滤器与定时计划请求共用的过滤输入：filters 之外以用户名给出的来源，
+// 或整条过滤表达式
+type filterInput struct {
+	// Senders 为发送者过滤：用户 id 或 @用户名，解析后并入 filters.sender_ids
+	Senders []string `json:"senders"`
+	// ForwardFrom 为转发来源过滤：id 或 @用户名，解析后并入 filters.forward_from_ids
+	ForwardFrom []string `json:"forward_from"`
+	// FilterExpr 为过滤表达式（如 type:video size>50MB -from:@bot），与 filters/senders/forward_from 二选一
+	FilterExpr string `json:"filter_expr"`
+}
+
+// resolveFilters 汇总请求中的过滤条件：filter_expr 非空时解析表达式并替换 f（不能再同时给出其他过滤输入），
+// 随后解析发送者与转发来源用户名并校验。失败时已写出 400 响应（表达式错误附带字符偏移 pos）并返回 false
+func (s *Server) resolveFilters(w http.ResponseWriter, r *http.Request, f *downloader.HistoryFilters, in filterInput) bool {
+	senders, forwardFrom := in.Senders, in.ForwardFrom
+	var exclude []string
+	if expr := strings.TrimSpace(in.FilterExpr); expr != "" {
+		if !f.IsZero() || len(senders) > 0 || len(forwardFrom) > 0 {
+			s.writeError(w, http.StatusBadRequest, "filter_expr 不能与 filters/senders/forward_from 同时指定")
+			return false
+		}
+		parsed, err := downloader.ParseFilterExpr(expr)
+		if err != nil {
+			var pe *downloader.FilterExprError
+			if errors.As(err, &pe) {
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]any{"error": pe.Error(), "pos": pe.Pos})
+				return false
+			}
+			s.writeError(w, http.StatusBadRequest, err.Error())
+			return false
+		}
+		*f, senders, exclude, forwardFrom = parsed.Filters, parsed.Senders, parsed.ExcludeSenders, parsed.ForwardSources
+	}
+	var msg string
+	if err := s.client.ResolveFilterSources(r.Context(), f, senders, exclude, forwardFrom); err != nil {
+		msg = err.Error()
+	} else {
+		msg = f.Validate()
+	}
+	if msg != "" {
+		s.writeError(w, http.StatusBadRequest, msg)
+		return false
+	}
+	return true
+}
+
+// maxSearchQueryLen 是聊天内搜索关键词的最大长度（字符）
+const maxSearchQueryLen = 256
+
+// validateSearchQuery 校验聊天内搜索参数，返回首个问题的描述（合法时为空串）
+func validateSearchQuery(kind queue.Kind, query string, messageID int64, comments bool) string {
+	switch {
+	case query == "":
+		return ""
+	case kind != queue.KindHistory:
+		return "search_query 仅适用于 history 任务"
+	case messageID != 0:
+		return "message_id 与 search_query 不能同时指定"
+	case comments:
+		return "search_query 不能与评论区模式同时使用"
+	case utf8.RuneCountInString(query) > maxSearchQueryLen:
+		return fmt.Sprintf("search_query 不能超过 %d 个字符", maxSearchQueryLen)
+	}
+	return ""
+}
+
 // handleResolve 解析 t.me 链接 / @用户名为聊天与可选消息 id，供前端确认后创建任务
 func (s *Server) handleResolve(w http.ResponseWriter, r *http.Request) {
 	var body struct {
@@ -301,6 +520,105 @@ func (s *Server) handleResolve(w http.ResponseWriter, r *http.Request) {
 	s.writeJSON(w, target)
 }
 
+// handleSearch 在全部聊天中按关键词搜索媒体消息（分页），供前端预览勾选后创建 search 任务
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Query     string `json:"query"`
+		MediaType string `json:"media_type"`
+		Offset    string `json:"offset"`
+	}
+	if !s.decode(w, r, &body) {
+		return
+	}
+	if body.MediaType != "" && !downloader.ValidMediaTypes[body.MediaType] {
+		s.writeError(w, http.StatusBadRequest, "无效的媒体类型: "+body.MediaType)
+		return
+	}
+	if !s.requireReady(w) {
+		return
+	}
+	page, err := s.client.SearchGlobal(r.Context(), body.Query, body.MediaType, body.Offset)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.writeJSON(w, page)
+}
+
+// defaultPreviewScanLimit 是试运行未指定 scan_limit 时扫描的消息数上限，避免大聊天的预览长时间占用请求
+const defaultPreviewScanLimit = 20000
+
+// handlePreview 试运行 history 任务：按与创建任务相同的参数扫描并过滤，但不下载，
+// 返回将下载的文件数与字节数（按类型）、日期跨度、样例文件及会被跳过（已存在/去重）的数量
+func (s *Server) handlePreview(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ChatID      int64                     `json:"chat_id"`
+		Filters     downloader.HistoryFilters `json:"filters"`
+		MessageID   int64                     `json:"message_id"`
+		RangeStart  int64                     `json:"range_start"`
+		RangeEnd    int64                     `json:"range_end"`
+		Incremental bool                      `json:"incremental"`
+		OldestFirst bool                      `json:"oldest_first"`
+		Comments    bool                      `json:"comments"`
+		SearchQuery string                    `json:"search_query"`
+		filterInput
+		// ScanLimit 为最多扫描的消息数（0 = defaultPreviewScanLimit），达到后停止并标记 truncated
+		ScanLimit int64 `json:"scan_limit"`
+	}
+	if !s.decode(w, r, &body) {
+		return
+	}
+	if body.ChatID == 0 {
+		s.writeError(w, http.StatusBadRequest, "chat_id 不能为空")
+		return
+	}
+	if body.ScanLimit < 0 {
+		s.writeError(w, http.StatusBadRequest, "scan_limit 不能为负")
+		return
+	}
+	if !s.requireReady(w) {
+		return
+	}
+	if !s.resolveFilters(w, r, &body.Filters, body.filterInput) {
+		return
+	}
+	if msg := validateRange(queue.KindHistory, body.MessageID, body.RangeStart, body.RangeEnd); msg != "" {
+		s.writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	body.SearchQuery = strings.TrimSpace(body.SearchQuery)
+	if msg := validateSearchQuery(queue.KindHistory, body.SearchQuery, body.MessageID, body.Comments); msg != "" {
+		s.writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	spec := &downloader.HistorySpec{
+		ChatID: body.ChatID, Filters: body.Filters, MessageID: body.MessageID, Incremental: body.Incremental,
+		RangeStart: body.RangeStart, RangeEnd: body.RangeEnd,
+		OldestFirst: body.OldestFirst, Comments: body.Comments, SearchQuery: body.SearchQuery,
+	}
+	// 增量下界与任务一致：只有整聊天扫描才按同步水位（按过滤条件分别记录）截断
+	isWholeChat := body.MessageID == 0 && body.RangeStart == 0 && body.RangeEnd == 0 &&
+		body.Filters.TopicID == 0 && body.SearchQuery == ""
+	if body.Incremental && isWholeChat {
+		watermark, err := s.store.GetChatWatermark(r.Context(), body.ChatID, body.Filters.SyncKey())
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		spec.StopAtMessageID = watermark
+	}
+	limit := body.ScanLimit
+	if limit == 0 {
+		limit = defaultPreviewScanLimit
+	}
+	preview, err := s.client.PreviewHistory(r.Context(), spec, limit)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.writeJSON(w, preview)
+}
+
 func (s *Server) handleTaskCancel(w http.ResponseWriter, r *http.Request) {
 	if err := s.queue.Cancel(r.PathValue("id")); err != nil {
 		s.writeError(w, http.StatusConflict, err.Error())
@@ -318,6 +636,31 @@ func (s *Server) handleTaskRetry(w http.ResponseWriter, r *http.Request) {
 	s.writeJSON(w, dto)
 }
 
+// handleTaskFilters 修改运行中 monitor 任务的过滤条件，请求体即 HistoryFilters（零值 = 不过滤），
+// 也可改为给出 filter_expr 过滤表达式
+func (s *Server) handleTaskFilters(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		downloader.HistoryFilters
+		filterInput
+	}
+	if !s.decode(w, r, &body) {
+		return
+	}
+	if !s.resolveFilters(w, r, &body.HistoryFilters, body.filterInput) {
+		return
+	}
+	if body.HasPopularity() {
+		s.writeError(w, http.StatusBadRequest, "监控任务不支持热度阈值：新消息尚无浏览与回应")
+		return
+	}
+	dto, err := s.queue.UpdateFilters(r.PathValue("id"), body.HistoryFilters)
+	if err != nil {
+		s.writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	s.writeJSON(w, dto)
+}
+
 /* ---- 定时下载计划 ---- */
 
 func (s *Server) handleSchedulesList(w http.ResponseWriter, r *http.Request) {
@@ -326,10 +669,28 @@ func (s *Server) handleSchedulesList(w http.ResponseWriter, r *http.Request) {
 		s.writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	if rows == nil {
-		rows = []*store.ScheduleRow{}
+	out := make([]scheduleDTO, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, newScheduleDTO(row))
 	}
-	s.writeJSON(w, rows)
+	s.writeJSON(w, out)
+}
+
+// scheduleDTO 是定时计划的响应形式：附带过滤条件的表达式文本
+type scheduleDTO struct {
+	*store.ScheduleRow
+	FilterExpr string `json:"filter_expr,omitempty"`
+}
+
+func newScheduleDTO(row *store.ScheduleRow) scheduleDTO {
+	dto := scheduleDTO{ScheduleRow: row}
+	if row.Filters != "" {
+		var f downloader.HistoryFilters
+		if json.Unmarshal([]byte(row.Filters), &f) == nil {
+			dto.FilterExpr = downloader.FormatFilterExpr(f)
+		}
+	}
+	return dto
 }
 
 func (s *Server) handleSchedulesCreate(w http.ResponseWriter, r *http.Request) {
@@ -338,6 +699,8 @@ func (s *Server) handleSchedulesCreate(w http.ResponseWriter, r *http.Request) {
 		IntervalMin int                       `json:"interval_min"`
 		Filters     downloader.HistoryFilters `json:"filters"`
 		ChatTitle   string                    `json:"chat_title"`
+		Incremental bool                      `json:"incremental"`
+		filterInput
 	}
 	if !s.decode(w, r, &body) {
 		return
@@ -351,8 +714,7 @@ func (s *Server) handleSchedulesCreate(w http.ResponseWriter, r *http.Request) {
 			fmt.Sprintf("间隔不能小于 %d 分钟", queue.MinScheduleIntervalMin))
 		return
 	}
-	if msg := body.Filters.Validate(); msg != "" {
-		s.writeError(w, http.StatusBadRequest, msg)
+	if !s.resolveFilters(w, r, &body.Filters, body.filterInput) {
 		return
 	}
 	title := body.ChatTitle
@@ -373,12 +735,13 @@ func (s *Server) handleSchedulesCreate(w http.ResponseWriter, r *http.Request) {
 		Filters:     filtersJSON,
 		Enabled:     true,
 		CreatedAt:   time.Now(),
+		Incremental: body.Incremental,
 	}
 	if err := s.store.CreateSchedule(r.Context(), row); err != nil {
 		s.writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	s.writeJSON(w, row)
+	s.writeJSON(w, newScheduleDTO(row))
 }
 
 func (s *Server) handleScheduleDelete(w http.ResponseWriter, r *http.Request) {
@@ -623,6 +986,11 @@ type historyRecordDTO struct {
 	Reason     string `json:"reason,omitempty"`
 	CreatedAt  int64  `json:"created_at"`
 	FinishedAt *int64 `json:"finished_at,omitempty"`
+	StoryID    int64  `json:"story_id,omitempty"`
+	// Forwarded 为转发消息；ForwardFromID/ForwardMessageID 为原始来源及其消息 id（未知为 0）
+	Forwarded        bool  `json:"forwarded,omitempty"`
+	ForwardFromID    int64 `json:"forward_from_id,omitempty"`
+	ForwardMessageID int64 `json:"forward_message_id,omitempty"`
 }
 
 func toHistoryRecordDTO(rec *store.HistoryRecord) historyRecordDTO {
@@ -640,6 +1008,11 @@ func toHistoryRecordDTO(rec *store.HistoryRecord) historyRecordDTO {
 		Status:    rec.Status,
 		Reason:    rec.Reason,
 		CreatedAt: rec.CreatedAt.Unix(),
+		StoryID:   rec.StoryID,
+
+		Forwarded:        rec.Forwarded,
+		ForwardFromID:    rec.ForwardFromID,
+		ForwardMessageID: rec.ForwardMessageID,
 	}
 	if rec.FinishedAt != nil {
 		sec := rec.FinishedAt.Unix()
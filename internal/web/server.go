@@ -42,6 +42,7 @@ const (
 	initialReconnectDelay = 2 * time.Second
 	maxReconnectDelay     = 30 * time.Second
 	reconnectFactor       = 2
+	leaveChatTimeout      = 30 * time.Second
 
 	// SSE 事件类型
 	eventState = "state"
@@ -73,7 +74,8 @@ type Server struct {
 	token        string   // 访问令牌（TG_DOWN_WEB_TOKEN）；非本地监听时必需
 	allowedHosts []string // 额外放行的 Host 白名单
 	hub          *sseHub
-	baseCtx      context.Context // 下载任务的生命周期父上下文（在 Run 中设置）
+	notifier     *notify.Notifier // 任务完成通知（未配置通知渠道时为 nil）
+	baseCtx      context.Context  // 下载任务的生命周期父上下文（在 Run 中设置）
 
 	mu       sync.RWMutex
 	state    State
@@ -90,7 +92,7 @@ type Server struct {
 // errAuthAborted 标记用户主动中止登录（返回上一步），区别于真实认证失败
 var errAuthAborted = errors.New("登录已被用户中止")
 
-// New 创建 Web 管理端：按配置构建任务队列管理器并接线完成通知
+// New 创建 Web 管理端：按配置构建任务队列管理器并接线任务终结回调（完成通知、完成后退出聊天）
 func New(client *telegram.Client, st *store.Store, log *logger.Logger, addr string, cfg *config.Config) *Server {
 	if addr == "" {
 		addr = DefaultAddr
@@ -100,13 +102,11 @@ func New(client *telegram.Client, st *store.Store, log *logger.Logger, addr stri
 	if cfg.Notify.TelegramSelf {
 		selfSend = client.SendSelfMessage
 	}
-	if n := notify.New(selfSend, cfg.Notify.WebhookURL, log); n != nil {
-		q.SetOnTerminal(n.TaskFinished)
-	}
-	return &Server{
+	s := &Server{
 		client:       client,
 		store:        st,
 		queue:        q,
+		notifier:     notify.New(selfSend, cfg.Notify.WebhookURL, log),
 		logger:       log,
 		addr:         addr,
 		token:        os.Getenv(webTokenEnv),
@@ -119,6 +119,8 @@ func New(client *telegram.Client, st *store.Store, log *logger.Logger, addr stri
 		abortCh:      make(chan struct{}, authChanSize),
 		logoutCh:     make(chan struct{}, authChanSize),
 	}
+	q.SetOnTerminal(s.onTaskTerminal)
+	return s
 }
 
 // Run 启动后台 Telegram 连接与 HTTP 服务，阻塞直到 ctx 取消
@@ -313,6 +315,36 @@ func (s *Server) onTaskChange(dto *queue.TaskDTO) {
 	}
 }
 
+// onTaskTerminal 是 queue.Manager 的任务终结回调：发送完成通知，
+// 并在 leave_after 任务完成后退出经邀请链接临时加入的聊天（最终失败时保留成员身份以便重试）
+func (s *Server) onTaskTerminal(dto *queue.TaskDTO) {
+	if s.notifier != nil {
+		s.notifier.TaskFinished(dto)
+	}
+	if dto.LeaveAfter && dto.Status == string(queue.StatusCompleted) {
+		go s.leaveChatAfter(dto.ChatID, dto.ID)
+	}
+}
+
+// leaveChatAfter 退出 taskID 所在的聊天；该聊天仍有其他排队/运行中的任务（含监控）时暂不退出
+func (s *Server) leaveChatAfter(chatID int64, taskID string) {
+	for _, t := range s.queue.List() {
+		if t.ID != taskID && t.ChatID == chatID &&
+			(t.Status == string(queue.StatusQueued) || t.Status == string(queue.StatusRunning)) {
+			s.logger.Info("聊天 %d 仍有任务 %s 在进行，暂不退出", chatID, t.ID)
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), leaveChatTimeout)
+	defer cancel()
+	if err := s.client.LeaveChat(ctx, chatID); err != nil {
+		s.logger.Warn("任务完成后退出聊天失败: %v", err)
+		return
+	}
+	s.logger.Info("任务 %s 已完成，已退出聊天 %d", taskID, chatID)
+	s.refreshChats(ctx)
+}
+
 // snapshotLoop 定时向 SSE 广播状态快照
 func (s *Server) snapshotLoop(ctx context.Context) {
 	ticker := time.NewTicker(snapshotInterval)
@@ -348,7 +380,7 @@ func (s *Server) snapshot() stateSnapshot {
 		State:            state,
 		Error:            stateErr,
 		Phone:            maskPhone(s.client.Phone()),
-		TargetChat:       s.client.TargetChat(),
+		Monitors:         s.client.Monitors(),
 		ActiveTasks:      s.activeTaskCount(),
 		Stats:            s.client.Stats(),
 		Media:            s.client.ActiveMedia(),
@@ -395,7 +427,7 @@ type stateSnapshot struct {
 	State            State                      `json:"state"`
 	Error            string                     `json:"error,omitempty"`
 	Phone            string                     `json:"phone"`
-	TargetChat       int64                      `json:"target_chat"`
+	Monitors         []telegram.MonitorTarget   `json:"monitors"`
 	ActiveTasks      int                        `json:"active_tasks"`
 	Stats            downloader.Stats           `json:"stats"`
 	Media            []downloader.MediaProgress `json:"media"`